@@ -21,16 +21,42 @@ import (
 )
 
 type Client struct {
-	apiURL  string
-	edgeURL string
-	client  *http.Client
+	apiURL   string
+	edgeURL  string
+	client   *http.Client
+	retry    RetryPolicy
+	timeouts Timeouts
+	// edgeRoutes overrides edgeURL for queries on some datasets.
+	edgeRoutes config.EdgeRoutes
+	// edges tracks the health of edgeURL and its failover edges.
+	edges *edgePool
+	// asyncPollInterval is how often QueryAPLAsync polls a running job.
+	asyncPollInterval time.Duration
+	// maxResponseBytes caps how much of a successful response body is read.
+	// Zero means unlimited.
+	maxResponseBytes int64
 }
 
+// Dataset is a dataset as listed by Axiom.
 type Dataset struct {
-	Name string `json:"name"`
-	Kind string
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Kind is the type of the dataset, such as "axiom:events:v1" or
+	// "otel:metrics:v1".
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created"`
+	// RetentionDays is how long events are kept. It only applies when
+	// UseRetentionPeriod is set.
+	UseRetentionPeriod bool   `json:"useRetentionPeriod"`
+	RetentionDays      int    `json:"retentionDays"`
+	Region             string `json:"region,omitempty"`
 }
 
+// DatasetKindMetrics is the kind of datasets holding OpenTelemetry metrics,
+// which are queried with MPL.
+const DatasetKindMetrics = "otel:metrics:v1"
+
 type DatasetFields struct {
 	DatasetName string         `json:"datasetName"`
 	Fields      []DatasetField `json:"fields"`
@@ -49,6 +75,13 @@ type APLQueryRequest struct {
 	APL       *string   `json:"apl"`
 	StartTime time.Time `json:"startTime"`
 	EndTime   time.Time `json:"endTime"`
+	// Cursor continues a raw event query after the row it points to, such
+	// as the NextCursor of a previous page. IncludeCursor also returns that
+	// row itself.
+	Cursor        string `json:"cursor,omitempty"`
+	IncludeCursor bool   `json:"includeCursor,omitempty"`
+	// Timeout shortens the client's query timeout for this request.
+	Timeout time.Duration `json:"-"`
 }
 
 // MPLQueryRequest represents the MPL query request for edge endpoints.
@@ -57,6 +90,8 @@ type MPLQueryRequest struct {
 	StartTime  time.Time `json:"startTime"`
 	EndTime    time.Time `json:"endTime"`
 	ChartWidth int64     `json:"-"`
+	// Timeout shortens the client's query timeout for this request.
+	Timeout time.Duration `json:"-"`
 }
 
 // APLQueryResponse represents the tabular query response from edge endpoints.
@@ -83,6 +118,20 @@ type APLQueryStatus struct {
 	MinBlockTime   *time.Time      `json:"minBlockTime"`
 	MaxBlockTime   *time.Time      `json:"maxBlockTime"`
 	Messages       []query.Message `json:"messages"`
+	// MinCursor and MaxCursor point at the oldest and newest row returned
+	// by a raw event query. Aggregations have no cursors.
+	MinCursor string `json:"minCursor"`
+	MaxCursor string `json:"maxCursor"`
+}
+
+// NextCursor returns the cursor of the page with the rows just older than
+// this one, or "" when the result cannot be paged. Raw events come back
+// newest first, so the next page continues after the oldest row.
+func (r APLQueryResponse) NextCursor() string {
+	if r.Status == nil {
+		return ""
+	}
+	return r.Status.MinCursor
 }
 
 type APLFieldMetaMap struct {
@@ -119,56 +168,112 @@ func NewClient(opts httpclient.Options, c *config.PluginConfig) (*Client, error)
 	if c.AccessToken != "" {
 		opts.Header.Set("Authorization", "Bearer "+c.AccessToken)
 	}
+	if c.OrgID != "" {
+		opts.Header.Set("X-Axiom-Org-Id", c.OrgID)
+	}
 	// set the SDK identifier
 	opts.Header.Set("User-Agent", fmt.Sprintf("axiom-grafana/v%s", version.Version))
-	// increase timeout for metrics queries
 	if opts.Timeouts == nil {
 		timeouts := httpclient.DefaultTimeoutOptions
 		opts.Timeouts = &timeouts
 	}
-	opts.Timeouts.Timeout = 5 * time.Minute
+	// Each call sets its own deadline from Timeouts, so the HTTP client must
+	// not cut long queries short.
+	opts.Timeouts.Timeout = 0
 
 	client, err := httpclient.New(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{
-		apiURL:  c.APIHost,
-		edgeURL: c.EdgeURL,
-		client:  client,
-	}, nil
+	api := &Client{
+		apiURL:     c.APIHost,
+		edgeURL:    c.EdgeURL,
+		edgeRoutes: c.EdgeRoutes,
+		client:     client,
+		retry: RetryPolicy{
+			MaxRetries: c.MaxRetries,
+			WaitMin:    c.RetryWaitMin,
+			WaitMax:    c.RetryWaitMax,
+		},
+		timeouts: Timeouts{
+			Query:       c.QueryTimeout,
+			Metadata:    c.MetadataTimeout,
+			HealthCheck: c.HealthCheckTimeout,
+			AsyncQuery:  c.AsyncQueryTimeout,
+		},
+		maxResponseBytes: c.MaxResponseBytes,
+	}
+	api.asyncPollInterval = c.AsyncPollInterval
+	if api.asyncPollInterval <= 0 {
+		api.asyncPollInterval = defaultAsyncPollInterval
+	}
+	edgeURLs := append([]string{c.EdgeURL}, c.FailoverEdgeURLs...)
+	api.edges = newEdgePool(edgeURLs, c.EdgeFailureThreshold, c.EdgeProbeInterval, api.probeEdge)
+
+	return api, nil
 }
 
 func (api *Client) DatasetFields(ctx context.Context) ([]*DatasetFields, error) {
+	timeout, cancel := withTimeout(ctx, "metadata request", api.timeouts.Metadata)
+	defer cancel()
+
 	endpoint := "/v1/datasets/_fields"
 	path, err := url.JoinPath(api.apiURL, endpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := api.NewRequest(ctx, http.MethodGet, path, nil)
+	req, err := api.NewRequest(timeout.ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var res []*DatasetFields
 	_, err = api.Do(req, &res)
+	if err != nil {
+		return nil, timeout.wrap(err)
+	}
+
+	return res, nil
+}
+
+// DatasetFieldsOf returns the fields of a single dataset.
+func (api *Client) DatasetFieldsOf(ctx context.Context, dataset string) ([]DatasetField, error) {
+	timeout, cancel := withTimeout(ctx, "metadata request", api.timeouts.Metadata)
+	defer cancel()
+
+	endpoint := fmt.Sprintf("/v1/datasets/%s/fields", url.PathEscape(dataset))
+	path, err := url.JoinPath(api.apiURL, endpoint)
 	if err != nil {
 		return nil, err
 	}
 
+	req, err := api.NewRequest(timeout.ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []DatasetField
+	_, err = api.Do(req, &res)
+	if err != nil {
+		return nil, timeout.wrap(err)
+	}
+
 	return res, nil
 }
 
 func (api *Client) Datasets(ctx context.Context) ([]Dataset, error) {
+	timeout, cancel := withTimeout(ctx, "metadata request", api.timeouts.Metadata)
+	defer cancel()
+
 	endpoint := "/v2/datasets"
 	path, err := url.JoinPath(api.apiURL, endpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := api.NewRequest(ctx, http.MethodGet, path, nil)
+	req, err := api.NewRequest(timeout.ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -176,7 +281,7 @@ func (api *Client) Datasets(ctx context.Context) ([]Dataset, error) {
 	var res []Dataset
 	_, err = api.Do(req, &res)
 	if err != nil {
-		return nil, err
+		return nil, timeout.wrap(err)
 	}
 
 	return res, nil
@@ -193,7 +298,7 @@ func (api *Client) FetchMetricsDataset(ctx context.Context) ([]string, error) {
 	datasets := []string{}
 
 	for _, ds := range res {
-		if ds.Kind == "otel:metrics:v1" {
+		if ds.Kind == DatasetKindMetrics {
 			datasets = append(datasets, ds.Name)
 		}
 	}
@@ -202,15 +307,18 @@ func (api *Client) FetchMetricsDataset(ctx context.Context) ([]string, error) {
 }
 
 func (api *Client) GetMetricsForDataset(ctx context.Context, dataset string, startTime, endTime string) ([]string, error) {
+	timeout, cancel := withTimeout(ctx, "metadata request", api.timeouts.Metadata)
+	defer cancel()
+
 	endpoint := fmt.Sprintf("/v1/query/metrics/info/datasets/%s/metrics", url.PathEscape(dataset))
-	path, err := url.JoinPath(api.edgeURL, endpoint)
+	path, err := url.JoinPath(api.edgeURLFor(dataset), endpoint)
 	if err != nil {
 		return nil, err
 	}
 
 	path = fmt.Sprintf("%s?start=%s&end=%s", path, startTime, endTime)
 
-	req, err := api.NewRequest(ctx, http.MethodGet, path, nil)
+	req, err := api.NewRequest(timeout.ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -218,24 +326,27 @@ func (api *Client) GetMetricsForDataset(ctx context.Context, dataset string, sta
 	var res []string
 	_, err = api.Do(req, &res)
 	if err != nil {
-		return nil, err
+		return nil, timeout.wrap(err)
 	}
 
 	return res, nil
 }
 
 func (api *Client) GetMetricTags(ctx context.Context, dataset string, metric string, startTime, endTime string) ([]string, error) {
+	timeout, cancel := withTimeout(ctx, "metadata request", api.timeouts.Metadata)
+	defer cancel()
+
 	endpoint := fmt.Sprintf("/v1/query/metrics/info/datasets/%s/tags", url.PathEscape(dataset))
 	if metric != "" {
 		endpoint = fmt.Sprintf("/v1/query/metrics/info/datasets/%s/metrics/%s/tags", url.PathEscape(dataset), url.PathEscape(metric))
 	}
-	path, err := url.JoinPath(api.edgeURL, endpoint)
+	path, err := url.JoinPath(api.edgeURLFor(dataset), endpoint)
 	if err != nil {
 		return nil, err
 	}
 	path = fmt.Sprintf("%s?start=%s&end=%s", path, startTime, endTime)
 
-	req, err := api.NewRequest(ctx, http.MethodGet, path, nil)
+	req, err := api.NewRequest(timeout.ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -243,24 +354,27 @@ func (api *Client) GetMetricTags(ctx context.Context, dataset string, metric str
 	var res []string
 	_, err = api.Do(req, &res)
 	if err != nil {
-		return nil, err
+		return nil, timeout.wrap(err)
 	}
 
 	return res, nil
 }
 
 func (api *Client) GetMetricTagValues(ctx context.Context, dataset string, metric string, tag string, startTime, endTime string) ([]string, error) {
+	timeout, cancel := withTimeout(ctx, "metadata request", api.timeouts.Metadata)
+	defer cancel()
+
 	endpoint := fmt.Sprintf("/v1/query/metrics/info/datasets/%s/tags/%s/values", url.PathEscape(dataset), url.PathEscape(tag))
 	if metric != "" {
 		endpoint = fmt.Sprintf("/v1/query/metrics/info/datasets/%s/metrics/%s/tags/%s/values", url.PathEscape(dataset), url.PathEscape(metric), url.PathEscape(tag))
 	}
-	path, err := url.JoinPath(api.edgeURL, endpoint)
+	path, err := url.JoinPath(api.edgeURLFor(dataset), endpoint)
 	if err != nil {
 		return nil, err
 	}
 	path = fmt.Sprintf("%s?start=%s&end=%s", path, startTime, endTime)
 
-	req, err := api.NewRequest(ctx, http.MethodGet, path, nil)
+	req, err := api.NewRequest(timeout.ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -268,22 +382,25 @@ func (api *Client) GetMetricTagValues(ctx context.Context, dataset string, metri
 	var res []string
 	_, err = api.Do(req, &res)
 	if err != nil {
-		return nil, err
+		return nil, timeout.wrap(err)
 	}
 
 	return res, nil
 }
 
 func (api *Client) QueryAPL(ctx context.Context, reqBody APLQueryRequest) (APLQueryResponse, error) {
+	timeout, cancel := withTimeout(ctx, "query", minTimeout(api.timeouts.Query, reqBody.Timeout))
+	defer cancel()
+
 	endpoint := "/v1/query/_apl"
-	path, err := url.JoinPath(api.edgeURL, endpoint)
+	path, err := url.JoinPath(api.edgeURLForAPL(reqBody.APL), endpoint)
 	if err != nil {
 		return APLQueryResponse{}, err
 	}
 
 	path = path + "?format=tabular"
 
-	req, err := api.NewRequest(ctx, http.MethodPost, path, reqBody)
+	req, err := api.NewRequest(timeout.ctx, http.MethodPost, path, reqBody)
 	if err != nil {
 		return APLQueryResponse{}, err
 	}
@@ -291,21 +408,52 @@ func (api *Client) QueryAPL(ctx context.Context, reqBody APLQueryRequest) (APLQu
 	var result APLQueryResponse
 	resp, err := api.Do(req, &result)
 	if err != nil {
-		return APLQueryResponse{}, err
+		return APLQueryResponse{}, timeout.wrap(err)
 	}
 	result.TraceID = traceIDFromResponse(resp)
 
 	return result, nil
 }
 
+// QueryAPLStream runs an APL query like QueryAPL but hands the tabular
+// response body to decode while it is read, so large results never have to be
+// buffered in full. It returns the Axiom trace ID of the response.
+func (api *Client) QueryAPLStream(ctx context.Context, reqBody APLQueryRequest, decode func(io.Reader) error) (string, error) {
+	timeout, cancel := withTimeout(ctx, "query", minTimeout(api.timeouts.Query, reqBody.Timeout))
+	defer cancel()
+
+	endpoint := "/v1/query/_apl"
+	path, err := url.JoinPath(api.edgeURLForAPL(reqBody.APL), endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	path = path + "?format=tabular"
+
+	req, err := api.NewRequest(timeout.ctx, http.MethodPost, path, reqBody)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := api.DoStream(req, decode)
+	if err != nil {
+		return traceIDFromResponse(resp), timeout.wrap(err)
+	}
+
+	return traceIDFromResponse(resp), nil
+}
+
 func (api *Client) QueryMetrics(ctx context.Context, reqBody MPLQueryRequest) (MetricsQueryResponse, error) {
+	timeout, cancel := withTimeout(ctx, "query", minTimeout(api.timeouts.Query, reqBody.Timeout))
+	defer cancel()
+
 	endpoint := "/v1/query/_mpl"
-	path, err := url.JoinPath(api.edgeURL, endpoint)
+	path, err := url.JoinPath(api.edgeURLForMPL(reqBody.MPL), endpoint)
 	if err != nil {
 		return MetricsQueryResponse{}, err
 	}
 
-	req, err := api.NewRequest(ctx, http.MethodPost, path, reqBody)
+	req, err := api.NewRequest(timeout.ctx, http.MethodPost, path, reqBody)
 	if err != nil {
 		return MetricsQueryResponse{}, err
 	}
@@ -319,7 +467,7 @@ func (api *Client) QueryMetrics(ctx context.Context, reqBody MPLQueryRequest) (M
 	var res MetricsQueryResponse
 	resp, err := api.Do(req, &res)
 	if err != nil {
-		return MetricsQueryResponse{}, err
+		return MetricsQueryResponse{}, timeout.wrap(err)
 	}
 	res.TraceID = traceIDFromResponse(resp)
 
@@ -366,28 +514,47 @@ func (api *Client) NewRequest(ctx context.Context, method, path string, body any
 	return req, nil
 }
 
+// Do sends req, retrying transient Axiom failures, and decodes a successful
+// JSON response into out. Every request issued through Do must be safe to
+// repeat; the client only uses it for queries and metadata lookups.
 func (api *Client) Do(req *http.Request, out any) (*http.Response, error) {
-	resp, err := api.client.Do(req)
+	if out == nil {
+		return api.DoStream(req, nil)
+	}
+
+	return api.DoStream(req, func(body io.Reader) error {
+		err := json.NewDecoder(body).Decode(out)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	})
+}
+
+// DoStream is Do for callers that decode the response body themselves. The
+// body passed to decode fails with a *ResponseTooLargeError once it exceeds
+// the configured maximum response size.
+func (api *Client) DoStream(req *http.Request, decode func(io.Reader) error) (resp *http.Response, err error) {
+	req, span := startRequestSpan(req)
+	body := &countingBody{}
+	defer func() { endRequestSpan(span, resp, body.n, err) }()
+
+	resp, err = api.doWithRetry(req)
 	if err != nil {
 		return resp, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return resp, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+		return resp, newAPIError(resp)
 	}
 
-	if out == nil {
+	if decode == nil {
 		return resp, nil
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(out)
-	if err != nil && err != io.EOF {
-		return resp, err
-	}
-
-	return resp, nil
+	body.r = resp.Body
+	return resp, decode(limitResponseBody(body, api.maxResponseBytes))
 }
 
 // ValidateCredentials validates the credentials by performing an APL query that we expect to fail (empty)
@@ -395,6 +562,9 @@ func (api *Client) Do(req *http.Request, out any) (*http.Response, error) {
 // that we got past network and authentication issues and looked at our request
 // it also should be somewhat inexpensive for the server
 func (api *Client) ValidateCredentials(ctx context.Context) error {
+	timeout, cancel := withTimeout(ctx, "health check", api.timeouts.HealthCheck)
+	defer cancel()
+
 	logger := log.DefaultLogger.FromContext(ctx)
 
 	var axiErr axiom.HTTPError
@@ -402,11 +572,12 @@ func (api *Client) ValidateCredentials(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	r, err := api.NewRequest(ctx, http.MethodPost, path, nil)
+	r, err := api.NewRequest(timeout.ctx, http.MethodPost, path, nil)
 	if err != nil {
 		return err
 	}
-	res, err := api.client.Do(r)
+	res, err := api.send(r)
+	err = timeout.wrap(err)
 	if err != nil && errors.As(err, &axiErr) {
 		if axiErr.Status == 422 {
 			// expected 422 for empty query, HEALTHY
@@ -415,6 +586,10 @@ func (api *Client) ValidateCredentials(ctx context.Context) error {
 	}
 	if err != nil {
 		logger.Error("failed to query Axiom", "error", err.Error())
+		var timeoutErr *TimeoutError
+		if errors.As(err, &timeoutErr) {
+			return err
+		}
 		return fmt.Errorf("invalid edge url or API token")
 	}
 	if res == nil {
@@ -2,11 +2,16 @@ package axiomapi
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/axiomhq/axiom-grafana/pkg/config"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
@@ -114,3 +119,664 @@ func TestValidateCredentialsReturnsTransportError(t *testing.T) {
 		t.Fatalf("expected validation error for transport failure, got %v", err)
 	}
 }
+
+func TestQueryAPLRetriesTransientStatus(t *testing.T) {
+	var attempts atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body APLQueryRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.APL == nil {
+			t.Errorf("expected replayed request body, got %v (err %v)", body.APL, err)
+		}
+
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"format":"tabular","tables":[]}`))
+	}))
+	defer upstream.Close()
+
+	client := newRetryTestClient(t, upstream.URL, 3)
+	apl := "['logs']"
+	_, err := client.QueryAPL(context.Background(), APLQueryRequest{APL: &apl})
+	if err != nil {
+		t.Fatalf("expected query to succeed after retries, got error: %v", err)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Fatalf("expected 3 attempts, got %d", got)
+	}
+}
+
+func TestDoGivesUpAfterMaxRetries(t *testing.T) {
+	var attempts atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	client := newRetryTestClient(t, upstream.URL, 2)
+	_, err := client.Datasets(context.Background())
+	if err == nil {
+		t.Fatal("expected error after exhausting retries")
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Fatalf("expected initial attempt plus 2 retries, got %d", got)
+	}
+}
+
+func TestDoDoesNotRetryClientErrors(t *testing.T) {
+	var attempts atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer upstream.Close()
+
+	client := newRetryTestClient(t, upstream.URL, 3)
+	if _, err := client.Datasets(context.Background()); err == nil {
+		t.Fatal("expected error for bad request")
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Fatalf("expected a single attempt, got %d", got)
+	}
+}
+
+func TestDoStopsRetryingWhenContextIsCancelled(t *testing.T) {
+	var attempts atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	client := newRetryTestClient(t, upstream.URL, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := client.Datasets(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancellation, got %v", err)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Fatalf("expected a single attempt, got %d", got)
+	}
+}
+
+func TestRetryPolicyBackoffHonorsRetryAfter(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, WaitMin: time.Millisecond, WaitMax: 4 * time.Millisecond}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"2"}}}
+	if got := policy.backoff(0, resp); got != 2*time.Second {
+		t.Fatalf("expected Retry-After seconds to be honored, got %s", got)
+	}
+
+	now := time.Date(2026, 6, 11, 2, 0, 0, 0, time.UTC)
+	resp = &http.Response{Header: http.Header{"Retry-After": []string{now.Add(5 * time.Second).Format(http.TimeFormat)}}}
+	if got, ok := retryAfterDuration(resp, now); !ok || got != 5*time.Second {
+		t.Fatalf("expected Retry-After date to be parsed, got %s (ok %v)", got, ok)
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		got := policy.backoff(attempt, &http.Response{Header: http.Header{}})
+		if got < policy.WaitMin || got > policy.WaitMax {
+			t.Fatalf("expected backoff within [%s, %s], got %s", policy.WaitMin, policy.WaitMax, got)
+		}
+	}
+}
+
+func newRetryTestClient(t *testing.T, baseURL string, maxRetries int) *Client {
+	t.Helper()
+
+	client, err := NewClient(httpclient.Options{}, &config.PluginConfig{
+		APIHost:      baseURL,
+		EdgeURL:      baseURL,
+		MaxRetries:   maxRetries,
+		RetryWaitMin: time.Millisecond,
+		RetryWaitMax: 2 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("expected client, got error: %v", err)
+	}
+
+	return client
+}
+
+func TestDoReturnsStructuredAPIError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Axiom-Trace-Id", "trace-123")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"code":403,"message":"token lacks query permission"}`))
+	}))
+	defer upstream.Close()
+
+	client := newRetryTestClient(t, upstream.URL, 0)
+	_, err := client.Datasets(context.Background())
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected APIError, got %T: %v", err, err)
+	}
+	if apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status 403, got %d", apiErr.StatusCode)
+	}
+	if apiErr.Code != "403" {
+		t.Fatalf("expected code 403, got %q", apiErr.Code)
+	}
+	if apiErr.Message != "token lacks query permission" {
+		t.Fatalf("expected decoded message, got %q", apiErr.Message)
+	}
+	if apiErr.TraceID != "trace-123" {
+		t.Fatalf("expected trace ID, got %q", apiErr.TraceID)
+	}
+	if apiErr.Error() != "unexpected status 403: token lacks query permission (trace ID trace-123)" {
+		t.Fatalf("unexpected error text %q", apiErr.Error())
+	}
+}
+
+func TestDoFallsBackToRawBodyForNonJSONErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("upstream connect error\n"))
+	}))
+	defer upstream.Close()
+
+	client := newRetryTestClient(t, upstream.URL, 0)
+	_, err := client.Datasets(context.Background())
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected APIError, got %T: %v", err, err)
+	}
+	if apiErr.Message != "upstream connect error" {
+		t.Fatalf("expected raw body as message, got %q", apiErr.Message)
+	}
+	if !apiErr.IsServerError() {
+		t.Fatal("expected 502 to be reported as a server error")
+	}
+}
+
+func TestLimitResponseBodyRejectsOversizedBodies(t *testing.T) {
+	body, err := io.ReadAll(limitResponseBody(strings.NewReader("12345"), 5))
+	if err != nil {
+		t.Fatalf("expected body at the limit to be accepted, got error: %v", err)
+	}
+	if string(body) != "12345" {
+		t.Fatalf("expected full body, got %q", body)
+	}
+
+	_, err = io.ReadAll(limitResponseBody(strings.NewReader("123456"), 5))
+	var tooLarge *ResponseTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("expected ResponseTooLargeError, got %v", err)
+	}
+	if tooLarge.Limit != 5 {
+		t.Fatalf("expected limit 5, got %d", tooLarge.Limit)
+	}
+}
+
+func TestClientAppliesSeparateTimeoutsPerRequestKind(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+
+	client, err := NewClient(httpclient.Options{}, &config.PluginConfig{
+		APIHost:         upstream.URL,
+		EdgeURL:         upstream.URL,
+		QueryTimeout:    5 * time.Second,
+		MetadataTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("expected client, got error: %v", err)
+	}
+
+	_, err = client.Datasets(context.Background())
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected *TimeoutError, got %T: %v", err, err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected error to wrap context.DeadlineExceeded, got %v", err)
+	}
+	if got, want := err.Error(), "metadata request timed out after 50ms"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	apl := "['logs']"
+	_, err = client.QueryAPL(context.Background(), APLQueryRequest{APL: &apl})
+	if err != nil {
+		t.Fatalf("expected query to finish within the query timeout, got %v", err)
+	}
+
+	_, err = client.QueryAPL(context.Background(), APLQueryRequest{APL: &apl, Timeout: 50 * time.Millisecond})
+	if !errors.As(err, &timeoutErr) || timeoutErr.Op != "query" || timeoutErr.Timeout != 50*time.Millisecond {
+		t.Fatalf("expected per-request query timeout, got %v", err)
+	}
+}
+
+func TestNewClientSendsOrgIDHeader(t *testing.T) {
+	var gotOrgID, gotAuthorization string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotOrgID = r.Header.Get("X-Axiom-Org-Id")
+		gotAuthorization = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer upstream.Close()
+
+	client, err := NewClient(httpclient.Options{}, &config.PluginConfig{
+		AccessToken: "xapt-123",
+		OrgID:       "acme-x1y2",
+		APIHost:     upstream.URL,
+		EdgeURL:     upstream.URL,
+	})
+	if err != nil {
+		t.Fatalf("expected client, got error: %v", err)
+	}
+
+	if _, err := client.Datasets(context.Background()); err != nil {
+		t.Fatalf("expected datasets, got error: %v", err)
+	}
+	if gotOrgID != "acme-x1y2" {
+		t.Fatalf("expected org ID header, got %q", gotOrgID)
+	}
+	if gotAuthorization != "Bearer xapt-123" {
+		t.Fatalf("expected bearer token, got %q", gotAuthorization)
+	}
+}
+
+func TestDatasetsInAPL(t *testing.T) {
+	tests := []struct {
+		name string
+		apl  string
+		want []string
+	}{
+		{name: "bare dataset", apl: "logs | where status == 500", want: []string{"logs"}},
+		{name: "quoted dataset", apl: "['eu-logs'] | project ['field.name']", want: []string{"eu-logs"}},
+		{name: "comment before source", apl: "// errors only\n['eu-logs'] | where level == 'error'", want: []string{"eu-logs"}},
+		{name: "union", apl: "union withsource=ds ['eu-logs'], (['us-logs'] | where x > 1), traces | count", want: []string{"eu-logs", "us-logs", "traces"}},
+		{name: "join", apl: "['a'] | join kind=inner hint.strategy=broadcast (['b'] | take 10) on id", want: []string{"a", "b"}},
+		{name: "lookup", apl: "['a'] | lookup kind=leftouter ['b'] on id", want: []string{"a", "b"}},
+		{name: "let bindings", apl: "let threshold = 10;\nlet errors = ['eu-logs'] | where status >= 500;\nerrors | where n > threshold", want: []string{"eu-logs"}},
+		{name: "function calls", apl: "print now(); datatable(x:long)[1]", want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := DatasetsInAPL(test.apl)
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Fatalf("expected datasets %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestAPLSourceEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		apl  string
+		// want is the query up to the end of its source, or "" when it has
+		// none.
+		want string
+	}{
+		{name: "bare dataset", apl: "logs | where status == 500", want: "logs"},
+		{name: "no pipe", apl: "['eu-logs'] // all events", want: "['eu-logs']"},
+		{name: "multibyte names", apl: "['ログ']\n| take 10", want: "['ログ']"},
+		{name: "union", apl: "union ['a'], (['b'] | where x > 1) | count", want: "union ['a'], (['b'] | where x > 1)"},
+		{name: "let bindings", apl: "let n = 10;\nlet errors = ['logs'] | where status >= 500;\nerrors | take n;", want: "let n = 10;\nlet errors = ['logs'] | where status >= 500;\nerrors"},
+		{name: "only let", apl: "let n = 10;", want: ""},
+		{name: "leading pipe", apl: "| take 10", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			end, ok := APLSourceEnd(test.apl)
+			got := ""
+			if ok {
+				got = test.apl[:end]
+			}
+			if got != test.want {
+				t.Fatalf("expected source %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestMPLSourceEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		mpl  string
+		want string
+	}{
+		{name: "bare source", mpl: "metrics:cpu | group by host using avg", want: "metrics:cpu"},
+		{name: "quoted pipe", mpl: "`team|prod`:`http.requests` | align to 1m using sum", want: "`team|prod`:`http.requests`"},
+		{name: "comment", mpl: "// cpu\nmetrics:cpu\n", want: "// cpu\nmetrics:cpu"},
+		{name: "empty", mpl: "  // nothing", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			end, ok := MPLSourceEnd(test.mpl)
+			got := ""
+			if ok {
+				got = test.mpl[:end]
+			}
+			if got != test.want {
+				t.Fatalf("expected source %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestLiteralRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		ranges func(string) [][2]int
+		want   []string
+	}{
+		{name: "apl", query: "['logs'] // errors\n| where msg == \"a \\\" b\" and x > 1 // done", ranges: APLLiteralRanges, want: []string{"['logs']", "// errors", `"a \" b"`, "// done"}},
+		{name: "apl without literals", query: "logs | take 10", ranges: APLLiteralRanges, want: nil},
+		{name: "mpl", query: "`team/prod`:cpu // load\n| where host == \"a\"", ranges: MPLLiteralRanges, want: []string{"`team/prod`", "// load", `"a"`}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, r := range test.ranges(test.query) {
+				got = append(got, test.query[r[0]:r[1]])
+			}
+			if strings.Join(got, "|") != strings.Join(test.want, "|") {
+				t.Fatalf("expected literals %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestClientRoutesRequestsToDatasetEdge(t *testing.T) {
+	var defaultPaths, euPaths []string
+	newEdge := func(paths *[]string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*paths = append(*paths, r.URL.Path)
+			w.Header().Set("Content-Type", "application/json")
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte(`[]`))
+				return
+			}
+			_, _ = w.Write([]byte(`{}`))
+		}))
+	}
+	defaultEdge := newEdge(&defaultPaths)
+	defer defaultEdge.Close()
+	euEdge := newEdge(&euPaths)
+	defer euEdge.Close()
+
+	client, err := NewClient(httpclient.Options{}, &config.PluginConfig{
+		APIHost: defaultEdge.URL,
+		EdgeURL: defaultEdge.URL,
+		EdgeRoutes: config.EdgeRoutes{
+			{Dataset: "eu-*", EdgeURL: euEdge.URL},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected client, got error: %v", err)
+	}
+
+	ctx := context.Background()
+	euAPL := "['eu-logs'] | count"
+	usAPL := "['us-logs'] | count"
+	euMPL := "`eu-metrics`:cpu"
+	if _, err := client.QueryAPL(ctx, APLQueryRequest{APL: &euAPL}); err != nil {
+		t.Fatalf("expected query, got error: %v", err)
+	}
+	if _, err := client.QueryAPL(ctx, APLQueryRequest{APL: &usAPL}); err != nil {
+		t.Fatalf("expected query, got error: %v", err)
+	}
+	if _, err := client.QueryMetrics(ctx, MPLQueryRequest{MPL: &euMPL}); err != nil {
+		t.Fatalf("expected metrics query, got error: %v", err)
+	}
+	if _, err := client.GetMetricsForDataset(ctx, "eu-metrics", "", ""); err != nil {
+		t.Fatalf("expected metrics, got error: %v", err)
+	}
+	if _, err := client.GetMetricTags(ctx, "us-metrics", "cpu", "", ""); err != nil {
+		t.Fatalf("expected tags, got error: %v", err)
+	}
+
+	wantEU := []string{"/v1/query/_apl", "/v1/query/_mpl", "/v1/query/metrics/info/datasets/eu-metrics/metrics"}
+	if strings.Join(euPaths, ",") != strings.Join(wantEU, ",") {
+		t.Fatalf("expected EU edge to serve %q, got %q", wantEU, euPaths)
+	}
+	wantDefault := []string{"/v1/query/_apl", "/v1/query/metrics/info/datasets/us-metrics/metrics/cpu/tags"}
+	if strings.Join(defaultPaths, ",") != strings.Join(wantDefault, ",") {
+		t.Fatalf("expected default edge to serve %q, got %q", wantDefault, defaultPaths)
+	}
+}
+
+// newUnreachableEdge returns the URL of an edge that drops every connection
+// and counts how often it was dialled.
+func newUnreachableEdge(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected listener, got error: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var dials atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			conn.Close()
+		}
+	}()
+
+	return "http://" + ln.Addr().String(), &dials
+}
+
+func TestClientFailsOverToNextEdge(t *testing.T) {
+	primary, primaryDials := newUnreachableEdge(t)
+
+	var secondaryRequests atomic.Int32
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		secondaryRequests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer secondary.Close()
+
+	client, err := NewClient(httpclient.Options{}, &config.PluginConfig{
+		APIHost:              secondary.URL,
+		EdgeURL:              primary,
+		FailoverEdgeURLs:     []string{secondary.URL},
+		EdgeFailureThreshold: 2,
+		EdgeProbeInterval:    time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("expected client, got error: %v", err)
+	}
+	defer client.Close()
+
+	apl := "['logs']"
+	for i := 0; i < 2; i++ {
+		if _, err := client.QueryAPL(context.Background(), APLQueryRequest{APL: &apl}); err != nil {
+			t.Fatalf("expected query %d to fail over, got error: %v", i, err)
+		}
+	}
+	if got := secondaryRequests.Load(); got != 2 {
+		t.Fatalf("expected failover edge to serve 2 queries, got %d", got)
+	}
+
+	statuses := client.EdgeStatuses()
+	if len(statuses) != 2 || statuses[0].Healthy || statuses[0].ConsecutiveFailures != 2 || statuses[0].LastError == "" || statuses[0].UnhealthySince == nil {
+		t.Fatalf("expected primary edge to be unhealthy after 2 failures, got %+v", statuses)
+	}
+	if !statuses[1].Healthy {
+		t.Fatalf("expected failover edge to be healthy, got %+v", statuses[1])
+	}
+
+	// Unhealthy edges are skipped until a probe reaches them again.
+	dials := primaryDials.Load()
+	if _, err := client.QueryAPL(context.Background(), APLQueryRequest{APL: &apl}); err != nil {
+		t.Fatalf("expected query, got error: %v", err)
+	}
+	if got := primaryDials.Load(); got != dials {
+		t.Fatalf("expected unhealthy edge to be skipped, got %d new connections", got-dials)
+	}
+}
+
+func TestEdgePoolProbesUnhealthyEdgesBackToHealth(t *testing.T) {
+	var reachable atomic.Bool
+	probes := make(chan string, 16)
+	pool := newEdgePool([]string{"https://a", "https://b"}, 1, 10*time.Millisecond, func(ctx context.Context, edgeURL string) error {
+		probes <- edgeURL
+		if reachable.Load() {
+			return nil
+		}
+		return errors.New("connection refused")
+	})
+	defer pool.close()
+
+	pool.failed("https://a", errors.New("connection refused"), false)
+	if got := strings.Join(pool.candidates(), ","); got != "https://b,https://a" {
+		t.Fatalf("expected unhealthy edge to be tried last, got %q", got)
+	}
+
+	if got := <-probes; got != "https://a" {
+		t.Fatalf("expected probe of unhealthy edge, got %q", got)
+	}
+	reachable.Store(true)
+
+	deadline := time.After(time.Second)
+	for !pool.status()[0].Healthy {
+		select {
+		case <-deadline:
+			t.Fatalf("expected edge to recover, got %+v", pool.status())
+		case <-probes:
+		}
+	}
+	if got := strings.Join(pool.candidates(), ","); got != "https://a,https://b" {
+		t.Fatalf("expected recovered edge to be preferred again, got %q", got)
+	}
+}
+
+func TestQueryAPLAsyncCancelsJobWhenContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var polls atomic.Int32
+	cancelled := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodPost:
+			_, _ = w.Write([]byte(`{"id":"job/1","state":"running"}`))
+		case http.MethodGet:
+			if polls.Add(1) == 2 {
+				cancel()
+			}
+			_, _ = w.Write([]byte(`{"state":"running"}`))
+		case http.MethodDelete:
+			cancelled <- r.URL.EscapedPath()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer upstream.Close()
+
+	client, err := NewClient(httpclient.Options{}, &config.PluginConfig{
+		APIHost:           upstream.URL,
+		EdgeURL:           upstream.URL,
+		AsyncPollInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("expected client, got error: %v", err)
+	}
+
+	apl := "['logs']"
+	_, err = client.QueryAPLAsync(ctx, APLQueryRequest{APL: &apl})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	var partialErr *PartialResultError
+	if errors.As(err, &partialErr) {
+		t.Fatalf("expected no partial result before Axiom reported rows, got %v", partialErr)
+	}
+
+	select {
+	case path := <-cancelled:
+		if path != "/v1/query/_apl/async/job%2F1" {
+			t.Fatalf("expected escaped job path, got %q", path)
+		}
+	default:
+		t.Fatal("expected the job to be cancelled on Axiom")
+	}
+}
+
+func TestQueryAPLAsyncReportsFailedJobs(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"id":"job-1","state":"running"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"state":"failed","error":"out of memory"}`))
+	}))
+	defer upstream.Close()
+
+	client, err := NewClient(httpclient.Options{}, &config.PluginConfig{
+		APIHost:           upstream.URL,
+		EdgeURL:           upstream.URL,
+		AsyncPollInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("expected client, got error: %v", err)
+	}
+
+	apl := "['logs']"
+	_, err = client.QueryAPLAsync(context.Background(), APLQueryRequest{APL: &apl})
+	if err == nil || err.Error() != "async query job-1 failed: out of memory" {
+		t.Fatalf("expected failed job error, got %v", err)
+	}
+}
+
+func TestEndpointLabelReplacesNamesWithPlaceholders(t *testing.T) {
+	tests := map[string]string{
+		"/v1/query/_apl":                                                         "/v1/query/_apl",
+		"/v1/datasets/_fields":                                                   "/v1/datasets/_fields",
+		"/v1/query/_apl/async/job-1":                                             "/v1/query/_apl/async/{id}",
+		"/v1/query/metrics/info/datasets/m1/metrics":                             "/v1/query/metrics/info/datasets/{dataset}/metrics",
+		"/v1/query/metrics/info/datasets/m1/tags/host/values":                    "/v1/query/metrics/info/datasets/{dataset}/tags/{tag}/values",
+		"/v1/query/metrics/info/datasets/m1/metrics/cpu%2Fuser/tags/host/values": "/v1/query/metrics/info/datasets/{dataset}/metrics/{metric}/tags/{tag}/values",
+	}
+
+	for path, want := range tests {
+		if got := endpointLabel(path); got != want {
+			t.Fatalf("endpointLabel(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
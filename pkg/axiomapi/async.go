@@ -0,0 +1,224 @@
+package axiomapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/axiomhq/axiom-grafana/pkg/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	asyncQueryEndpoint = "/v1/query/_apl/async"
+
+	// defaultAsyncPollInterval applies when the client is built without a
+	// poll interval.
+	defaultAsyncPollInterval = time.Second
+)
+
+// AsyncQueryState is the state of an asynchronous APL query job.
+type AsyncQueryState string
+
+const (
+	AsyncQueryRunning   AsyncQueryState = "running"
+	AsyncQuerySucceeded AsyncQueryState = "succeeded"
+	AsyncQueryFailed    AsyncQueryState = "failed"
+	AsyncQueryCanceled  AsyncQueryState = "canceled"
+)
+
+// AsyncQueryJob is the state of an APL query running in the background on
+// Axiom. Result holds the rows found so far while the job is running and the
+// full result once it succeeded.
+type AsyncQueryJob struct {
+	ID     string            `json:"id"`
+	State  AsyncQueryState   `json:"state"`
+	Result *APLQueryResponse `json:"result"`
+	Error  string            `json:"error"`
+
+	// edgeURL is the edge running the job. Polls and cancellation must go to
+	// the same edge.
+	edgeURL string
+	traceID string
+}
+
+// PartialResultError is returned by QueryAPLAsync when the query was stopped
+// before it finished but Axiom had already reported part of the result.
+// Result.Status.IsPartial is always set.
+type PartialResultError struct {
+	Result APLQueryResponse
+	Err    error
+}
+
+func (e *PartialResultError) Error() string {
+	return fmt.Sprintf("query stopped before it finished: %v", e.Err)
+}
+
+func (e *PartialResultError) Unwrap() error {
+	return e.Err
+}
+
+// QueryAPLAsync runs an APL query as a background job on Axiom and polls it
+// until it finishes. Unlike QueryAPL no single request has to last as long
+// as the query, so it is bounded by the async query timeout instead of the
+// query timeout. When ctx ends first, the job is cancelled on Axiom and the
+// partial result, if there is one, is returned in a *PartialResultError.
+func (api *Client) QueryAPLAsync(ctx context.Context, reqBody APLQueryRequest) (APLQueryResponse, error) {
+	timeout, cancel := withTimeout(ctx, "query", minTimeout(api.timeouts.AsyncQuery, reqBody.Timeout))
+	defer cancel()
+
+	job, err := api.SubmitAPLAsync(timeout.ctx, reqBody)
+	if err != nil {
+		return APLQueryResponse{}, timeout.wrap(err)
+	}
+
+	var partial *APLQueryResponse
+	for {
+		switch job.State {
+		case AsyncQuerySucceeded:
+			if job.Result == nil {
+				return APLQueryResponse{}, fmt.Errorf("async query %s finished without a result", job.ID)
+			}
+			result := *job.Result
+			result.TraceID = job.traceID
+			return result, nil
+		case AsyncQueryFailed:
+			return APLQueryResponse{}, fmt.Errorf("async query %s failed: %s", job.ID, job.Error)
+		case AsyncQueryCanceled:
+			return APLQueryResponse{}, fmt.Errorf("async query %s was cancelled on Axiom", job.ID)
+		}
+		if job.Result != nil {
+			partial = job.Result
+		}
+
+		err = util.SleepContext(timeout.ctx, api.asyncPollInterval)
+		if err == nil {
+			var next AsyncQueryJob
+			next, err = api.PollAPLAsync(timeout.ctx, job)
+			if err == nil {
+				job = next
+				continue
+			}
+		}
+
+		api.cancelAbandonedJob(ctx, job)
+		err = timeout.wrap(err)
+		if partial == nil || timeout.ctx.Err() == nil {
+			return APLQueryResponse{}, err
+		}
+
+		result := *partial
+		result.TraceID = job.traceID
+		status := APLQueryStatus{}
+		if result.Status != nil {
+			status = *result.Status
+		}
+		status.IsPartial = true
+		result.Status = &status
+		return APLQueryResponse{}, &PartialResultError{Result: result, Err: err}
+	}
+}
+
+// SubmitAPLAsync starts an APL query as a background job on Axiom.
+func (api *Client) SubmitAPLAsync(ctx context.Context, reqBody APLQueryRequest) (AsyncQueryJob, error) {
+	timeout, cancel := withTimeout(ctx, "async query request", api.timeouts.Metadata)
+	defer cancel()
+
+	path, err := url.JoinPath(api.edgeURLForAPL(reqBody.APL), asyncQueryEndpoint)
+	if err != nil {
+		return AsyncQueryJob{}, err
+	}
+	path = path + "?format=tabular"
+
+	req, err := api.NewRequest(timeout.ctx, http.MethodPost, path, reqBody)
+	if err != nil {
+		return AsyncQueryJob{}, err
+	}
+
+	var job AsyncQueryJob
+	resp, err := api.Do(req, &job)
+	if err != nil {
+		return AsyncQueryJob{}, timeout.wrap(err)
+	}
+	if job.ID == "" {
+		return AsyncQueryJob{}, errors.New("axiom did not return an async query ID")
+	}
+	job.edgeURL = asyncJobEdgeURL(resp, req)
+	job.traceID = traceIDFromResponse(resp)
+
+	return job, nil
+}
+
+// PollAPLAsync fetches the current state of job.
+func (api *Client) PollAPLAsync(ctx context.Context, job AsyncQueryJob) (AsyncQueryJob, error) {
+	timeout, cancel := withTimeout(ctx, "async query request", api.timeouts.Metadata)
+	defer cancel()
+
+	path, err := url.JoinPath(job.edgeURL, asyncQueryEndpoint, url.PathEscape(job.ID))
+	if err != nil {
+		return AsyncQueryJob{}, err
+	}
+	path = path + "?format=tabular"
+
+	req, err := api.NewRequest(timeout.ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return AsyncQueryJob{}, err
+	}
+
+	var next AsyncQueryJob
+	if _, err := api.Do(req, &next); err != nil {
+		return AsyncQueryJob{}, timeout.wrap(err)
+	}
+	next.ID = job.ID
+	next.edgeURL = job.edgeURL
+	next.traceID = job.traceID
+
+	return next, nil
+}
+
+// CancelAPLAsync stops job on Axiom.
+func (api *Client) CancelAPLAsync(ctx context.Context, job AsyncQueryJob) error {
+	timeout, cancel := withTimeout(ctx, "async query request", api.timeouts.Metadata)
+	defer cancel()
+
+	path, err := url.JoinPath(job.edgeURL, asyncQueryEndpoint, url.PathEscape(job.ID))
+	if err != nil {
+		return err
+	}
+
+	req, err := api.NewRequest(timeout.ctx, http.MethodDelete, path, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := api.Do(req, nil); err != nil {
+		return timeout.wrap(err)
+	}
+
+	return nil
+}
+
+// cancelAbandonedJob cancels a job nobody waits for anymore so it stops
+// using the org's query budget. It runs even though ctx may already be done.
+func (api *Client) cancelAbandonedJob(ctx context.Context, job AsyncQueryJob) {
+	if err := api.CancelAPLAsync(context.WithoutCancel(ctx), job); err != nil {
+		log.DefaultLogger.FromContext(ctx).Warn("failed to cancel async query", "id", job.ID, "error", err)
+	}
+}
+
+// asyncJobEdgeURL returns the edge that accepted a job, which may be a
+// failover edge rather than the one req was built for.
+func asyncJobEdgeURL(resp *http.Response, req *http.Request) string {
+	sent := req.URL
+	if resp != nil && resp.Request != nil {
+		sent = resp.Request.URL
+	}
+
+	u := *sent
+	u.RawQuery = ""
+	return strings.TrimSuffix(u.String(), asyncQueryEndpoint)
+}
@@ -0,0 +1,464 @@
+package axiomapi
+
+import (
+	"strings"
+	"unicode"
+)
+
+// edgeURLFor returns the edge to send a request for datasets to. A query runs
+// on a single edge, so the first dataset it reads from decides; requests
+// without a routed dataset go to the datasource's own edge.
+func (api *Client) edgeURLFor(datasets ...string) string {
+	if len(datasets) == 0 || datasets[0] == "" {
+		return api.edgeURL
+	}
+	if edgeURL, ok := api.edgeRoutes.Match(datasets[0]); ok {
+		return edgeURL
+	}
+
+	return api.edgeURL
+}
+
+func (api *Client) edgeURLForAPL(apl *string) string {
+	if apl == nil || len(api.edgeRoutes) == 0 {
+		return api.edgeURL
+	}
+	return api.edgeURLFor(DatasetsInAPL(*apl)...)
+}
+
+func (api *Client) edgeURLForMPL(mpl *string) string {
+	if mpl == nil || len(api.edgeRoutes) == 0 {
+		return api.edgeURL
+	}
+	return api.edgeURLFor(datasetInMPL(*mpl))
+}
+
+// aplNonSources are operators that may start a tabular expression without
+// reading from a dataset.
+var aplNonSources = map[string]bool{
+	"datatable":    true,
+	"declare":      true,
+	"evaluate":     true,
+	"externaldata": true,
+	"find":         true,
+	"let":          true,
+	"print":        true,
+	"range":        true,
+	"search":       true,
+	"set":          true,
+	"union":        true,
+}
+
+// DatasetsInAPL returns the datasets an APL query reads from, in the order
+// they first appear. It does not validate the query: it only looks at the
+// places a tabular source can appear, which are the start of a statement or
+// let binding, the arguments of union and the right side of join and lookup.
+func DatasetsInAPL(apl string) []string {
+	s := aplSourceScanner{tokens: tokenizeAPL(apl), bound: map[string]bool{}, seen: map[string]bool{}}
+	s.scan()
+	return s.datasets
+}
+
+// APLSourceEnd returns the byte offset in apl right after the tabular source
+// of its query statement, which is the last statement, such as after
+// ['logs'] in "let n = 5; ['logs'] | take n". Operators inserted there see
+// every event the query reads. It reports false when the query statement is
+// missing or starts with a pipe.
+func APLSourceEnd(apl string) (int, bool) {
+	tokens := tokenizeAPL(apl)
+
+	// The query statement starts after the last top-level semicolon that
+	// is followed by more tokens.
+	statement, depth := 0, 0
+	for i, tok := range tokens {
+		switch {
+		case tok.is(aplPunct, "("), tok.is(aplPunct, "["), tok.is(aplPunct, "{"):
+			depth++
+		case tok.is(aplPunct, ")"), tok.is(aplPunct, "]"), tok.is(aplPunct, "}"):
+			depth--
+		case depth == 0 && tok.is(aplPunct, ";") && i+1 < len(tokens):
+			statement = i + 1
+		}
+	}
+	tokens = tokens[statement:]
+	for len(tokens) > 0 && tokens[len(tokens)-1].is(aplPunct, ";") {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 || tokens[0].is(aplPunct, "|") {
+		return 0, false
+	}
+	if first := tokens[0]; first.kind == aplIdent && (first.text == "let" || first.text == "set" || first.text == "declare") {
+		return 0, false
+	}
+
+	depth = 0
+	for i, tok := range tokens {
+		switch {
+		case tok.is(aplPunct, "("), tok.is(aplPunct, "["), tok.is(aplPunct, "{"):
+			depth++
+		case tok.is(aplPunct, ")"), tok.is(aplPunct, "]"), tok.is(aplPunct, "}"):
+			depth--
+		case depth == 0 && tok.is(aplPunct, "|"):
+			return tokens[i-1].end, true
+		}
+	}
+
+	return tokens[len(tokens)-1].end, true
+}
+
+type aplSourceScanner struct {
+	tokens   []aplToken
+	bound    map[string]bool
+	seen     map[string]bool
+	datasets []string
+}
+
+func (s *aplSourceScanner) scan() {
+	statementStart := true
+	for i := 0; i < len(s.tokens); i++ {
+		tok := s.tokens[i]
+
+		if statementStart {
+			statementStart = false
+			switch {
+			case tok.is(aplIdent, "let"):
+				if s.at(i+1).kind == aplIdent && s.at(i+2).is(aplPunct, "=") {
+					s.bound[s.at(i+1).text] = true
+					s.source(i + 3)
+				}
+			case tok.is(aplIdent, "set"), tok.is(aplIdent, "declare"):
+			default:
+				s.source(i)
+			}
+		}
+
+		switch {
+		case tok.is(aplPunct, ";"):
+			statementStart = true
+		case tok.is(aplIdent, "union"):
+			s.unionSources(s.skipOptions(i + 1))
+		case tok.is(aplIdent, "join"), tok.is(aplIdent, "lookup"):
+			s.source(s.skipOptions(i + 1))
+		}
+	}
+}
+
+// source records the dataset read by the tabular expression starting at i.
+func (s *aplSourceScanner) source(i int) {
+	for s.at(i).is(aplPunct, "(") {
+		i++
+	}
+
+	tok := s.at(i)
+	switch tok.kind {
+	case aplQuotedIdent:
+		s.add(tok.text)
+	case aplIdent:
+		if aplNonSources[tok.text] || s.bound[tok.text] {
+			return
+		}
+		// Function calls, parameters and assignments are not datasets.
+		if next := s.at(i + 1); next.is(aplPunct, "(") || next.is(aplPunct, ":") || next.is(aplPunct, "=") {
+			return
+		}
+		s.add(tok.text)
+	}
+}
+
+// unionSources records every comma separated source of a union, stopping at
+// the end of the union's argument list.
+func (s *aplSourceScanner) unionSources(i int) {
+	s.source(i)
+
+	depth := 0
+	for ; i < len(s.tokens); i++ {
+		tok := s.tokens[i]
+		switch {
+		case tok.is(aplPunct, "("):
+			depth++
+		case tok.is(aplPunct, ")"):
+			if depth == 0 {
+				return
+			}
+			depth--
+		case depth == 0 && (tok.is(aplPunct, "|") || tok.is(aplPunct, ";")):
+			return
+		case depth == 0 && tok.is(aplPunct, ","):
+			s.source(i + 1)
+		}
+	}
+}
+
+// skipOptions skips operator options such as kind=inner or
+// hint.strategy=broadcast and returns the index of the first token after them.
+func (s *aplSourceScanner) skipOptions(i int) int {
+	for s.at(i).kind == aplIdent {
+		j := i + 1
+		for s.at(j).is(aplPunct, ".") && s.at(j+1).kind == aplIdent {
+			j += 2
+		}
+		if !s.at(j).is(aplPunct, "=") {
+			return i
+		}
+		i = j + 2
+	}
+
+	return i
+}
+
+func (s *aplSourceScanner) at(i int) aplToken {
+	if i < 0 || i >= len(s.tokens) {
+		return aplToken{}
+	}
+	return s.tokens[i]
+}
+
+func (s *aplSourceScanner) add(dataset string) {
+	if dataset == "" || s.seen[dataset] {
+		return
+	}
+	s.seen[dataset] = true
+	s.datasets = append(s.datasets, dataset)
+}
+
+type aplTokenKind int
+
+const (
+	aplEOF aplTokenKind = iota
+	aplIdent
+	aplQuotedIdent
+	aplString
+	aplNumber
+	aplPunct
+)
+
+type aplToken struct {
+	kind aplTokenKind
+	text string
+	// start and end are the byte offsets of the token in the query.
+	start, end int
+}
+
+func (t aplToken) is(kind aplTokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// tokenizeAPL splits apl into identifiers, ['quoted'] identifiers, literals and
+// single punctuation characters, dropping whitespace and comments. It is just
+// precise enough to find dataset references.
+func tokenizeAPL(apl string) []aplToken {
+	var tokens []aplToken
+	src := []rune(apl)
+	// offsets maps rune indexes in src to byte offsets in apl.
+	offsets := make([]int, 0, len(src)+1)
+	for i := range apl {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(apl))
+	add := func(kind aplTokenKind, text string, start, end int) {
+		tokens = append(tokens, aplToken{kind: kind, text: text, start: offsets[start], end: offsets[end]})
+	}
+
+	for i := 0; i < len(src); {
+		r := src[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case r == '\'' || r == '"':
+			text, end := readAPLString(src, i)
+			add(aplString, text, i, end)
+			i = end
+		case r == '[':
+			j := skipSpace(src, i+1)
+			if j < len(src) && (src[j] == '\'' || src[j] == '"') {
+				text, end := readAPLString(src, j)
+				end = skipSpace(src, end)
+				if end < len(src) && src[end] == ']' {
+					add(aplQuotedIdent, text, i, end+1)
+					i = end + 1
+					continue
+				}
+			}
+			add(aplPunct, "[", i, i+1)
+			i++
+		case r == '_' || unicode.IsLetter(r):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(src[j]) || unicode.IsDigit(src[j])) {
+				j++
+			}
+			add(aplIdent, string(src[i:j]), i, j)
+			i = j
+		case unicode.IsDigit(r):
+			j := i + 1
+			for j < len(src) && (src[j] == '.' || unicode.IsLetter(src[j]) || unicode.IsDigit(src[j])) {
+				j++
+			}
+			add(aplNumber, string(src[i:j]), i, j)
+			i = j
+		default:
+			add(aplPunct, string(r), i, i+1)
+			i++
+		}
+	}
+
+	return tokens
+}
+
+// readAPLString reads the string literal starting with the quote at start and
+// returns its unescaped text and the index after the closing quote.
+func readAPLString(src []rune, start int) (string, int) {
+	quote := src[start]
+	var b strings.Builder
+
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			if i+1 < len(src) {
+				i++
+				b.WriteRune(src[i])
+			}
+		case quote:
+			return b.String(), i + 1
+		default:
+			b.WriteRune(src[i])
+		}
+	}
+
+	return b.String(), len(src)
+}
+
+func skipSpace(src []rune, i int) int {
+	for i < len(src) && unicode.IsSpace(src[i]) {
+		i++
+	}
+	return i
+}
+
+// datasetInMPL returns the dataset of an MPL query's dataset:metric source,
+// or "" when the query does not start with one.
+func datasetInMPL(mpl string) string {
+	src := []rune(mpl)
+	i := 0
+	for {
+		i = skipSpace(src, i)
+		if i+1 < len(src) && src[i] == '/' && src[i+1] == '/' {
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		}
+		break
+	}
+
+	var dataset string
+	switch {
+	case i < len(src) && src[i] == '`':
+		dataset, i = readAPLString(src, i)
+	default:
+		j := i
+		for j < len(src) && (src[j] == '_' || src[j] == '-' || src[j] == '.' || unicode.IsLetter(src[j]) || unicode.IsDigit(src[j])) {
+			j++
+		}
+		dataset, i = string(src[i:j]), j
+	}
+
+	if dataset == "" || i >= len(src) || src[i] != ':' {
+		return ""
+	}
+	return dataset
+}
+
+// MPLSourceEnd returns the byte offset in mpl right after its source, such
+// as after `team/prod`:cpu in "`team/prod`:cpu | align to 1m using avg".
+// It reports false when the query has no source.
+func MPLSourceEnd(mpl string) (int, bool) {
+	end := -1
+	for i := 0; i < len(mpl); i++ {
+		switch c := mpl[i]; {
+		case c == '/' && i+1 < len(mpl) && mpl[i+1] == '/':
+			for i < len(mpl) && mpl[i] != '\n' {
+				i++
+			}
+		case c == '`' || c == '"':
+			// Skip to the closing quote, leaving i on it.
+			for i++; i < len(mpl) && mpl[i] != c; i++ {
+				if mpl[i] == '\\' {
+					i++
+				}
+			}
+			end = min(i+1, len(mpl))
+		case c == '|':
+			return end, end >= 0
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r':
+			end = i + 1
+		}
+	}
+
+	return end, end >= 0
+}
+
+// APLLiteralRanges returns the byte ranges of apl that hold text rather than
+// code: string literals, ['quoted'] names and comments.
+func APLLiteralRanges(apl string) [][2]int {
+	var ranges [][2]int
+	// Only whitespace and comments lie between tokens.
+	comments := func(from, to int) {
+		for from < to {
+			i := strings.Index(apl[from:to], "//")
+			if i < 0 {
+				return
+			}
+			start := from + i
+			end := strings.IndexByte(apl[start:to], '\n')
+			if end < 0 {
+				end = to
+			} else {
+				end += start
+			}
+			ranges = append(ranges, [2]int{start, end})
+			from = end
+		}
+	}
+
+	prev := 0
+	for _, tok := range tokenizeAPL(apl) {
+		comments(prev, tok.start)
+		if tok.kind == aplString || tok.kind == aplQuotedIdent {
+			ranges = append(ranges, [2]int{tok.start, tok.end})
+		}
+		prev = tok.end
+	}
+	comments(prev, len(apl))
+
+	return ranges
+}
+
+// MPLLiteralRanges returns the byte ranges of mpl that hold text rather than
+// code: "strings", `quoted` names and comments.
+func MPLLiteralRanges(mpl string) [][2]int {
+	var ranges [][2]int
+	for i := 0; i < len(mpl); i++ {
+		switch c := mpl[i]; {
+		case c == '/' && i+1 < len(mpl) && mpl[i+1] == '/':
+			start := i
+			for i < len(mpl) && mpl[i] != '\n' {
+				i++
+			}
+			ranges = append(ranges, [2]int{start, i})
+		case c == '`' || c == '"':
+			start := i
+			for i++; i < len(mpl) && mpl[i] != c; i++ {
+				if mpl[i] == '\\' {
+					i++
+				}
+			}
+			ranges = append(ranges, [2]int{start, min(i+1, len(mpl))})
+		}
+	}
+
+	return ranges
+}
@@ -0,0 +1,134 @@
+package axiomapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBodyBytes bounds how much of an error response is kept in memory.
+const maxErrorBodyBytes = 1 << 20
+
+// APIError is returned for every non-2xx response from Axiom. It carries the
+// decoded error body so callers can tell authentication problems, invalid
+// queries and Axiom outages apart.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Code is the error code reported in the body, if any.
+	Code string
+	// Message is the human readable error message.
+	Message string
+	// TraceID is the value of the X-Axiom-Trace-Id response header.
+	TraceID string
+	// Body is the raw (possibly truncated) response body.
+	Body []byte
+}
+
+type apiErrorBody struct {
+	Code    json.RawMessage `json:"code"`
+	Message string          `json:"message"`
+	Error   string          `json:"error"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Message)
+	if e.TraceID != "" {
+		msg = fmt.Sprintf("%s (trace ID %s)", msg, e.TraceID)
+	}
+	return msg
+}
+
+// IsServerError reports whether Axiom failed to handle a valid request.
+func (e *APIError) IsServerError() bool {
+	return e.StatusCode >= http.StatusInternalServerError
+}
+
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		TraceID:    traceIDFromResponse(resp),
+		Body:       body,
+	}
+
+	var decoded apiErrorBody
+	if err := json.Unmarshal(body, &decoded); err == nil {
+		apiErr.Code = errorCodeString(decoded.Code)
+		apiErr.Message = decoded.Message
+		if apiErr.Message == "" {
+			apiErr.Message = decoded.Error
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(bytes.ReplaceAll(body, []byte("\n"), []byte(" "))))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+
+	return apiErr
+}
+
+// errorCodeString normalizes Axiom's error code, which is a number on some
+// endpoints and a string on others.
+func errorCodeString(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+
+	var code string
+	if err := json.Unmarshal(raw, &code); err == nil {
+		return code
+	}
+
+	return string(raw)
+}
+
+// ResponseTooLargeError is returned while reading a response body that
+// exceeds the configured maximum response size.
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response exceeds the maximum size of %d bytes; narrow the time range or add a limit to the query", e.Limit)
+}
+
+// limitedBody fails reads with a *ResponseTooLargeError once more than limit
+// bytes have been read, rather than silently truncating like io.LimitReader.
+type limitedBody struct {
+	r         io.Reader
+	limit     int64
+	remaining int64
+}
+
+func limitResponseBody(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+
+	// Allow one byte past the limit so a body of exactly limit bytes is
+	// still accepted.
+	return &limitedBody{r: r, limit: limit, remaining: limit + 1}
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, &ResponseTooLargeError{Limit: l.limit}
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining <= 0 {
+		return n - 1, &ResponseTooLargeError{Limit: l.limit}
+	}
+
+	return n, err
+}
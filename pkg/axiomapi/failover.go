@@ -0,0 +1,355 @@
+package axiomapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultEdgeProbeInterval applies when the client is built without a probe
+// interval.
+const defaultEdgeProbeInterval = 30 * time.Second
+
+// EdgeStatus describes what the client currently knows about one edge.
+type EdgeStatus struct {
+	URL                 string     `json:"url"`
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	UnhealthySince      *time.Time `json:"unhealthySince,omitempty"`
+}
+
+// edgePool tracks the health of the datasource's edges from the outcome of
+// the requests sent to them. An edge that fails threshold requests in a row
+// is skipped until a background probe reaches it again.
+type edgePool struct {
+	threshold     int
+	probeInterval time.Duration
+	probe         func(ctx context.Context, edgeURL string) error
+	now           func() time.Time
+
+	// ctx is cancelled by close to stop the background probes.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	edges []*edgeState
+}
+
+type edgeState struct {
+	url            string
+	failures       int
+	unhealthy      bool
+	unhealthySince time.Time
+	lastErr        error
+	probing        bool
+}
+
+func newEdgePool(urls []string, threshold int, probeInterval time.Duration, probe func(context.Context, string) error) *edgePool {
+	if threshold < 1 {
+		threshold = 1
+	}
+	if probeInterval <= 0 {
+		probeInterval = defaultEdgeProbeInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &edgePool{
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		probe:         probe,
+		now:           time.Now,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	for _, u := range urls {
+		p.edges = append(p.edges, &edgeState{url: u})
+	}
+
+	return p
+}
+
+// candidates returns the edges to try in order: healthy edges first, then
+// unhealthy ones as a last resort, both in configured order.
+func (p *edgePool) candidates() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	urls := make([]string, 0, len(p.edges))
+	for _, e := range p.edges {
+		if !e.unhealthy {
+			urls = append(urls, e.url)
+		}
+	}
+	for _, e := range p.edges {
+		if e.unhealthy {
+			urls = append(urls, e.url)
+		}
+	}
+
+	return urls
+}
+
+func (p *edgePool) succeeded(edgeURL string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e := p.edge(edgeURL); e != nil {
+		e.failures = 0
+		e.unhealthy = false
+		e.unhealthySince = time.Time{}
+		e.lastErr = nil
+	}
+}
+
+// failed records a transport error. force marks the edge unhealthy right
+// away instead of waiting for the threshold.
+func (p *edgePool) failed(edgeURL string, err error, force bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := p.edge(edgeURL)
+	if e == nil {
+		return
+	}
+	e.failures++
+	e.lastErr = err
+	if e.unhealthy || (!force && e.failures < p.threshold) {
+		return
+	}
+
+	e.unhealthy = true
+	e.unhealthySince = p.now()
+	if !e.probing && p.ctx.Err() == nil {
+		e.probing = true
+		p.wg.Add(1)
+		go p.probeUntilHealthy(e.url)
+	}
+}
+
+// probeUntilHealthy probes edgeURL every probeInterval until it answers or
+// the pool is closed.
+func (p *edgePool) probeUntilHealthy(edgeURL string) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			p.stopProbing(edgeURL)
+			return
+		case <-ticker.C:
+		}
+
+		err := p.probe(p.ctx, edgeURL)
+		if p.ctx.Err() != nil {
+			p.stopProbing(edgeURL)
+			return
+		}
+		if err == nil {
+			p.succeeded(edgeURL)
+			p.stopProbing(edgeURL)
+			return
+		}
+
+		p.mu.Lock()
+		if e := p.edge(edgeURL); e != nil {
+			e.lastErr = err
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *edgePool) stopProbing(edgeURL string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e := p.edge(edgeURL); e != nil {
+		e.probing = false
+	}
+}
+
+func (p *edgePool) status() []EdgeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]EdgeStatus, 0, len(p.edges))
+	for _, e := range p.edges {
+		status := EdgeStatus{
+			URL:                 e.url,
+			Healthy:             !e.unhealthy,
+			ConsecutiveFailures: e.failures,
+		}
+		if e.lastErr != nil {
+			status.LastError = e.lastErr.Error()
+		}
+		if e.unhealthy {
+			since := e.unhealthySince
+			status.UnhealthySince = &since
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// close stops the background probes and waits for them to return.
+func (p *edgePool) close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// edge returns the state of edgeURL. p.mu must be held.
+func (p *edgePool) edge(edgeURL string) *edgeState {
+	for _, e := range p.edges {
+		if e.url == edgeURL {
+			return e
+		}
+	}
+	return nil
+}
+
+// send sends req once. Requests to the datasource's edge go to the first
+// healthy edge and move on to the next one when the edge cannot be reached.
+// Requests to other hosts, such as the API or a routed edge, are sent as is.
+func (api *Client) send(req *http.Request) (*http.Response, error) {
+	rest, ok := edgeRelativeURL(req.URL.String(), api.edgeURL)
+	if api.edges == nil || !ok {
+		return api.roundTrip(req)
+	}
+
+	var lastErr error
+	for i, edgeURL := range api.edges.candidates() {
+		if i > 0 && !canRetry(req) {
+			break
+		}
+
+		attempt, err := requestForEdge(req, edgeURL+rest, i > 0)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := api.roundTrip(attempt)
+		if err == nil {
+			api.edges.succeeded(edgeURL)
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			// The caller gave up; that says nothing about the edge.
+			return nil, err
+		}
+
+		api.edges.failed(edgeURL, err, false)
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// requestForEdge points a copy of req at target. The body is rewound for
+// every attempt after the first.
+func requestForEdge(req *http.Request, target string, rewind bool) (*http.Request, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt := req.Clone(req.Context())
+	attempt.URL = u
+	attempt.Host = ""
+	if rewind && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		attempt.Body = body
+	}
+
+	return attempt, nil
+}
+
+// edgeRelativeURL returns the part of target after base, if target is on base.
+func edgeRelativeURL(target, base string) (string, bool) {
+	if base == "" || !strings.HasPrefix(target, base) {
+		return "", false
+	}
+
+	rest := target[len(base):]
+	if rest != "" && rest[0] != '/' && rest[0] != '?' {
+		return "", false
+	}
+
+	return rest, true
+}
+
+// probeEdge reports whether edgeURL can be reached. Any HTTP response counts,
+// since only transport errors make an edge unhealthy.
+func (api *Client) probeEdge(ctx context.Context, edgeURL string) error {
+	timeout, cancel := withTimeout(ctx, "edge probe", api.timeouts.HealthCheck)
+	defer cancel()
+
+	path, err := url.JoinPath(edgeURL, "/v1/query/_apl")
+	if err != nil {
+		return err
+	}
+	req, err := api.NewRequest(timeout.ctx, http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return timeout.wrap(err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// EdgeStatuses returns the health of every edge the datasource fails over
+// between, in configured order.
+func (api *Client) EdgeStatuses() []EdgeStatus {
+	if api.edges == nil {
+		return nil
+	}
+	return api.edges.status()
+}
+
+// CheckEdges probes every edge right away, updates their health with the
+// outcome and returns their statuses.
+func (api *Client) CheckEdges(ctx context.Context) []EdgeStatus {
+	if api.edges == nil {
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for _, edgeURL := range api.edges.candidates() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := api.probeEdge(ctx, edgeURL); err != nil {
+				if ctx.Err() == nil {
+					api.edges.failed(edgeURL, err, true)
+				}
+				return
+			}
+			api.edges.succeeded(edgeURL)
+		}()
+	}
+	wg.Wait()
+
+	return api.edges.status()
+}
+
+// Close stops the client's background edge probes.
+func (api *Client) Close() {
+	if api.edges != nil {
+		api.edges.close()
+	}
+}
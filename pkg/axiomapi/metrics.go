@@ -0,0 +1,74 @@
+package axiomapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// upstreamRequestDuration times every request sent to Axiom, including each
+// retry and failover attempt, until its response headers arrive.
+var upstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "axiom",
+	Subsystem: "datasource",
+	Name:      "upstream_request_duration_seconds",
+	Help:      "Time until Axiom answered a request, by endpoint and HTTP status class.",
+	Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+}, []string{"endpoint", "status_class"})
+
+// Collectors returns the client's Prometheus metrics. They are shared by all
+// clients of the process.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{upstreamRequestDuration}
+}
+
+// roundTrip sends req once and records its duration and outcome.
+func (api *Client) roundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := api.client.Do(req)
+
+	statusClass := "error"
+	if err == nil {
+		statusClass = StatusClass(resp.StatusCode)
+	}
+	upstreamRequestDuration.WithLabelValues(endpointLabel(req.URL.EscapedPath()), statusClass).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
+
+// StatusClass returns the class of an HTTP status code, such as "2xx".
+func StatusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// endpointLabel replaces the dataset, metric, tag and job names in an Axiom
+// API path with placeholders, so the endpoint label stays bounded.
+func endpointLabel(path string) string {
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments); i++ {
+		switch segments[i-1] {
+		case "datasets":
+			if !strings.HasPrefix(segments[i], "_") {
+				segments[i] = "{dataset}"
+			}
+		case "async":
+			segments[i] = "{id}"
+		case "metrics":
+			if i >= 2 && segments[i-2] == "{dataset}" {
+				segments[i] = "{metric}"
+			}
+		case "tags":
+			if i >= 2 && (segments[i-2] == "{dataset}" || segments[i-2] == "{metric}") {
+				segments[i] = "{tag}"
+			}
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,136 @@
+package axiomapi
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/axiomhq/axiom-grafana/pkg/util"
+)
+
+// maxDrainBytes bounds how much of a failed response body is read before the
+// connection is reused for the next attempt.
+const maxDrainBytes = 64 << 10
+
+// RetryPolicy controls how Client retries requests that Axiom rejected with a
+// transient status code. The zero value disables retries.
+type RetryPolicy struct {
+	MaxRetries int
+	WaitMin    time.Duration
+	WaitMax    time.Duration
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns how long to wait before the given retry attempt (starting
+// at zero). A Retry-After header on resp takes precedence over the jittered
+// exponential backoff when it asks for a longer wait.
+func (p RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	wait := p.jitteredBackoff(attempt)
+	if retryAfter, ok := retryAfterDuration(resp, time.Now()); ok && retryAfter > wait {
+		wait = retryAfter
+	}
+
+	return wait
+}
+
+func (p RetryPolicy) jitteredBackoff(attempt int) time.Duration {
+	if p.WaitMin <= 0 {
+		return 0
+	}
+
+	ceiling := p.WaitMin
+	for i := 0; i < attempt && ceiling < p.WaitMax; i++ {
+		ceiling *= 2
+	}
+	if p.WaitMax > 0 && ceiling > p.WaitMax {
+		ceiling = p.WaitMax
+	}
+	if ceiling <= p.WaitMin {
+		return p.WaitMin
+	}
+
+	return p.WaitMin + rand.N(ceiling-p.WaitMin)
+}
+
+// retryAfterDuration parses a Retry-After header given either in seconds or
+// as an HTTP date.
+func retryAfterDuration(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		wait := date.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
+
+// canRetry reports whether req can be sent again. Requests with a body are only
+// replayable when net/http knows how to rewind it.
+func canRetry(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// doWithRetry sends req and retries transient failures according to the
+// client's retry policy. The returned response is the last one received; its
+// body is left unread for the caller.
+func (api *Client) doWithRetry(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		resp, err := api.send(req)
+		if err != nil || attempt >= api.retry.MaxRetries || !isRetryableStatus(resp.StatusCode) || !canRetry(req) {
+			return resp, err
+		}
+
+		wait := api.retry.backoff(attempt, resp)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			// Waiting would outlive the request, so surface Axiom's answer now.
+			return resp, nil
+		}
+
+		_, _ = io.CopyN(io.Discard, resp.Body, maxDrainBytes)
+		resp.Body.Close()
+
+		if err := util.SleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package axiomapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Timeouts bounds how long each kind of request to Axiom may take, including
+// retries and reading the response. A zero value means no limit.
+type Timeouts struct {
+	Query       time.Duration
+	Metadata    time.Duration
+	HealthCheck time.Duration
+	// AsyncQuery bounds an async APL query from submission until its last
+	// poll. Each request it makes is bounded by Metadata.
+	AsyncQuery time.Duration
+}
+
+// TimeoutError is returned when a request to Axiom did not finish in time,
+// whether the limit came from the client, the query or Grafana. It wraps
+// context.DeadlineExceeded.
+type TimeoutError struct {
+	// Op names the kind of request, such as "query".
+	Op string
+	// Timeout is the time the request was given.
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Op, e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// requestTimeout tracks the deadline of a single client call.
+type requestTimeout struct {
+	ctx     context.Context
+	op      string
+	timeout time.Duration
+}
+
+// withTimeout derives a context that expires after timeout, or at the
+// parent's deadline if that comes first. The returned requestTimeout turns
+// errors caused by that deadline into a *TimeoutError.
+func withTimeout(ctx context.Context, op string, timeout time.Duration) (requestTimeout, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); timeout <= 0 || remaining < timeout {
+			timeout = remaining.Round(time.Millisecond)
+		}
+	}
+
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	return requestTimeout{ctx: ctx, op: op, timeout: timeout}, cancel
+}
+
+func (t requestTimeout) wrap(err error) error {
+	if err == nil || !errors.Is(t.ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	// Transport and decoding errors do not always keep the context error, so
+	// make sure callers can still detect the timeout with errors.Is.
+	if !errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+	}
+
+	return &TimeoutError{Op: t.op, Timeout: t.timeout, Err: err}
+}
+
+// minTimeout returns the shorter of two timeouts, treating zero as no limit.
+func minTimeout(a, b time.Duration) time.Duration {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 || a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,67 @@
+package axiomapi
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attributes shared by the client and the plugin.
+const (
+	AttributeQueryKind     = attribute.Key("axiom.query.kind")
+	AttributeRows          = attribute.Key("axiom.rows")
+	AttributeResponseBytes = attribute.Key("axiom.response.bytes")
+	AttributeTraceID       = attribute.Key("axiom.trace_id")
+)
+
+// traceContext always writes W3C trace context, whatever propagator Grafana
+// configured, since that is what Axiom reads.
+var traceContext = propagation.TraceContext{}
+
+// startRequestSpan starts the span of a request to Axiom and adds its
+// traceparent header to req, so Axiom's trace of the query joins Grafana's.
+// The returned request carries the span's context.
+func startRequestSpan(req *http.Request) (*http.Request, trace.Span) {
+	ctx, span := tracing.DefaultTracer().Start(req.Context(), "axiom.request",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+	req = req.WithContext(ctx)
+	traceContext.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	return req, span
+}
+
+// endRequestSpan records the outcome of a request on its span and ends it.
+func endRequestSpan(span trace.Span, resp *http.Response, bytes int64, err error) {
+	if resp != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if traceID := traceIDFromResponse(resp); traceID != "" {
+			span.SetAttributes(AttributeTraceID.String(traceID))
+		}
+	}
+	span.SetAttributes(AttributeResponseBytes.Int64(bytes))
+	if err != nil {
+		_ = tracing.Error(span, err)
+	}
+	span.End()
+}
+
+// countingBody counts the bytes of a response body read through it.
+type countingBody struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
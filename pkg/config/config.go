@@ -4,18 +4,173 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/axiomhq/axiom-grafana/pkg/util"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
 
+const (
+	defaultMaxRetries   = 3
+	defaultRetryWaitMin = 500 * time.Millisecond
+	defaultRetryWaitMax = 10 * time.Second
+	defaultCacheMaxSize = 64
+	// defaultCacheNowTolerance keeps ranges that end within the last minute,
+	// whose newest events may still be ingesting, out of the result cache.
+	defaultCacheNowTolerance = time.Minute
+
+	// defaultMaxResponseSize is in MB, like the maxResponseSizeMB setting.
+	defaultMaxResponseSize = 256
+
+	defaultQueryTimeout       = 5 * time.Minute
+	defaultMetadataTimeout    = 30 * time.Second
+	defaultHealthCheckTimeout = 30 * time.Second
+	defaultAsyncQueryTimeout  = 30 * time.Minute
+	defaultAsyncPollInterval  = time.Second
+
+	defaultEdgeFailureThreshold = 3
+	defaultEdgeProbeInterval    = 30 * time.Second
+
+	defaultSchemaCacheTTL = 5 * time.Minute
+
+	defaultLiveTailPollInterval = 2 * time.Second
+	defaultLiveTailMaxRows      = 1000
+
+	// DefaultMaxConcurrentQueries is how many queries of a single Grafana
+	// request run at once unless configured otherwise.
+	DefaultMaxConcurrentQueries = 10
+)
+
+// TokenType is the kind of Axiom token a datasource authenticates with.
+type TokenType string
+
+const (
+	TokenTypeNone     TokenType = "none"
+	TokenTypeAPI      TokenType = "api"
+	TokenTypePersonal TokenType = "personal"
+)
+
+// PluginConfig is the parsed datasource configuration. Durations and sizes
+// are stored in their Go units and read by ParseConfig from unit-suffixed
+// settings such as retryWaitMinMs, so they carry no JSON tags.
 type PluginConfig struct {
 	AccessToken string `json:"accessToken"`
-	APIHost     string `json:"apiHost"`
-	Edge        string `json:"edge"`
-	EdgeURL     string `json:"edgeURL"`
+	// OrgID is sent as X-Axiom-Org-Id. Personal access tokens need it to pick
+	// the organization; API tokens already belong to one.
+	OrgID   string `json:"orgId"`
+	APIHost string `json:"apiHost"`
+	Edge    string `json:"edge"`
+	EdgeURL string `json:"edgeURL"`
+	// EdgeRoutes sends queries for some datasets to another edge than
+	// EdgeURL, for organizations that keep datasets in several regions.
+	EdgeRoutes EdgeRoutes `json:"edgeRoutes"`
+	// FailoverEdgeURLs are tried in order when EdgeURL cannot be reached.
+	// An edge is skipped after EdgeFailureThreshold transport errors in a row
+	// until a probe every EdgeProbeInterval reaches it again.
+	FailoverEdgeURLs     []string `json:"failoverEdgeURLs"`
+	EdgeFailureThreshold int      `json:"edgeFailureThreshold"`
+	EdgeProbeInterval    time.Duration
+	// MaxRetries is how often a query or metadata request is retried after a
+	// transient Axiom response (429, 502, 503, 504). Zero disables retries.
+	MaxRetries   int `json:"maxRetries"`
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+	// MaxResponseBytes aborts queries whose response body grows beyond this
+	// size instead of letting the plugin run out of memory. It defaults to
+	// 256 MB; zero disables the check.
+	MaxResponseBytes int64
+	// MaxRows caps how many rows an APL query returns into a single frame.
+	// Queries can lower it but not raise it. Zero disables the cap.
+	MaxRows int `json:"maxRows"`
+	// CacheTTL is how long query results are served from the in-process
+	// result cache. Zero disables the cache.
+	CacheTTL      time.Duration
+	CacheMaxBytes int64
+	// CacheNowTolerance bypasses the cache for ranges ending less than this
+	// long before now, where results are likely still changing. It defaults
+	// to one minute.
+	CacheNowTolerance time.Duration
+	// MaxConcurrentQueries bounds how many queries of one Grafana request run
+	// at once.
+	MaxConcurrentQueries int `json:"maxConcurrentQueries"`
+	// MaxInflightQueries bounds how many queries the datasource sends to Axiom
+	// at once across all requests. Zero means no limit.
+	MaxInflightQueries int `json:"maxInflightQueries"`
+	// QueryRateLimit is how many queries per second may be sent to Axiom, with
+	// bursts of up to QueryRateBurst. Zero disables rate limiting.
+	QueryRateLimit float64 `json:"queryRateLimit"`
+	QueryRateBurst int     `json:"queryRateBurst"`
+	// QueryTimeout bounds APL and MPL queries, MetadataTimeout the schema
+	// and autocomplete lookups, and HealthCheckTimeout the health check.
+	QueryTimeout       time.Duration
+	MetadataTimeout    time.Duration
+	HealthCheckTimeout time.Duration
+	// AsyncQueryTimeout bounds queries run as async jobs, which are polled
+	// every AsyncPollInterval instead of holding one request open.
+	AsyncQueryTimeout time.Duration
+	AsyncPollInterval time.Duration
+	// SchemaCacheTTL is how long dataset fields are served from the schema
+	// cache before they are refreshed in the background. Zero disables the
+	// cache.
+	SchemaCacheTTL time.Duration
+	// LiveTailPollInterval is how often a live tail asks Axiom for new events.
+	// LiveTailMaxRows caps the events one poll sends; when more arrived, only
+	// the newest are sent so a busy dataset cannot flood the browser.
+	LiveTailPollInterval time.Duration
+	LiveTailMaxRows      int `json:"liveTailMaxRows"`
+}
+
+// EdgeRoute maps datasets to the edge that serves them. Dataset is either an
+// exact dataset name or a glob such as "eu-*".
+type EdgeRoute struct {
+	Dataset string `json:"dataset"`
+	EdgeURL string `json:"edgeURL"`
+}
+
+func (r EdgeRoute) isGlob() bool {
+	return strings.ContainsAny(r.Dataset, `*?[\`)
+}
+
+// EdgeRoutes is an ordered list of dataset routes.
+type EdgeRoutes []EdgeRoute
+
+// Match returns the edge that serves dataset. Exact dataset names win over
+// globs and globs are tried in the order they were configured. ok is false
+// when no route matches and the datasource's own edge applies.
+func (routes EdgeRoutes) Match(dataset string) (edgeURL string, ok bool) {
+	for _, route := range routes {
+		if !route.isGlob() && route.Dataset == dataset {
+			return route.EdgeURL, true
+		}
+	}
+	for _, route := range routes {
+		if !route.isGlob() {
+			continue
+		}
+		if matched, _ := path.Match(route.Dataset, dataset); matched {
+			return route.EdgeURL, true
+		}
+	}
+
+	return "", false
+}
+
+// TokenType reports which kind of token is configured, judging by the prefix
+// Axiom gives each kind. Tokens without a known prefix are taken to be API
+// tokens.
+func (c *PluginConfig) TokenType() TokenType {
+	switch {
+	case c.AccessToken == "":
+		return TokenTypeNone
+	case strings.HasPrefix(c.AccessToken, "xapt-"):
+		return TokenTypePersonal
+	default:
+		return TokenTypeAPI
+	}
 }
 
 func ParseConfig(ctx context.Context, settings backend.DataSourceInstanceSettings) (*PluginConfig, error) {
@@ -48,14 +203,176 @@ func ParseConfig(ctx context.Context, settings backend.DataSourceInstanceSetting
 		return nil, err
 	}
 
+	edgeRoutes, err := parseEdgeRoutes(data["edgeRoutes"])
+	if err != nil {
+		logger.Error("failed to parse edge routes", "error", err)
+		return nil, err
+	}
+
+	retryWaitMin := millisecondsSetting(data, "retryWaitMinMs", defaultRetryWaitMin)
+	retryWaitMax := millisecondsSetting(data, "retryWaitMaxMs", defaultRetryWaitMax)
+	if retryWaitMax < retryWaitMin {
+		retryWaitMax = retryWaitMin
+	}
+
+	maxConcurrentQueries := intSetting(data, "maxConcurrentQueries", DefaultMaxConcurrentQueries)
+	if maxConcurrentQueries == 0 {
+		maxConcurrentQueries = DefaultMaxConcurrentQueries
+	}
+	queryRateLimit := floatSetting(data, "queryRateLimit", 0)
+
+	liveTailMaxRows := intSetting(data, "liveTailMaxRows", defaultLiveTailMaxRows)
+	if liveTailMaxRows == 0 {
+		liveTailMaxRows = defaultLiveTailMaxRows
+	}
+
 	return &PluginConfig{
-		AccessToken: accessToken,
-		APIHost:     host,
-		Edge:        edge,
-		EdgeURL:     resolvedEdgeURL,
+		AccessToken:       accessToken,
+		OrgID:             strings.TrimSpace(util.CheckString(data["orgId"])),
+		APIHost:           host,
+		Edge:              edge,
+		EdgeURL:           resolvedEdgeURL,
+		EdgeRoutes:        edgeRoutes,
+		MaxRetries:        intSetting(data, "maxRetries", defaultMaxRetries),
+		RetryWaitMin:      retryWaitMin,
+		RetryWaitMax:      retryWaitMax,
+		MaxResponseBytes:  int64(intSetting(data, "maxResponseSizeMB", defaultMaxResponseSize)) << 20,
+		MaxRows:           intSetting(data, "maxRows", 0),
+		CacheTTL:          secondsSetting(data, "cacheTTLSeconds", 0),
+		CacheMaxBytes:     int64(intSetting(data, "cacheMaxSizeMB", defaultCacheMaxSize)) << 20,
+		CacheNowTolerance: secondsSetting(data, "cacheNowToleranceSeconds", defaultCacheNowTolerance),
+
+		MaxConcurrentQueries: maxConcurrentQueries,
+		MaxInflightQueries:   intSetting(data, "maxInflightQueries", 0),
+		QueryRateLimit:       queryRateLimit,
+		QueryRateBurst:       intSetting(data, "queryRateBurst", int(math.Ceil(queryRateLimit))),
+
+		QueryTimeout:       positiveSecondsSetting(data, "queryTimeoutSeconds", defaultQueryTimeout),
+		MetadataTimeout:    positiveSecondsSetting(data, "metadataTimeoutSeconds", defaultMetadataTimeout),
+		HealthCheckTimeout: positiveSecondsSetting(data, "healthCheckTimeoutSeconds", defaultHealthCheckTimeout),
+		AsyncQueryTimeout:  positiveSecondsSetting(data, "asyncQueryTimeoutSeconds", defaultAsyncQueryTimeout),
+		AsyncPollInterval:  positiveMillisecondsSetting(data, "asyncPollIntervalMs", defaultAsyncPollInterval),
+
+		FailoverEdgeURLs:     failoverEdgeURLs(data["failoverEdgeURLs"], resolvedEdgeURL),
+		EdgeFailureThreshold: intSetting(data, "edgeFailureThreshold", defaultEdgeFailureThreshold),
+		EdgeProbeInterval:    positiveSecondsSetting(data, "edgeProbeIntervalSeconds", defaultEdgeProbeInterval),
+
+		SchemaCacheTTL: secondsSetting(data, "schemaCacheTTLSeconds", defaultSchemaCacheTTL),
+
+		LiveTailPollInterval: positiveMillisecondsSetting(data, "liveTailPollIntervalMs", defaultLiveTailPollInterval),
+		LiveTailMaxRows:      liveTailMaxRows,
 	}, nil
 }
 
+// intSetting returns the non-negative integer stored under key, or fallback
+// when the setting is missing or malformed.
+func intSetting(data map[string]any, key string, fallback int) int {
+	value, ok := util.CheckInt(data[key])
+	if !ok || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+func millisecondsSetting(data map[string]any, key string, fallback time.Duration) time.Duration {
+	value, ok := util.CheckInt(data[key])
+	if !ok || value < 0 {
+		return fallback
+	}
+	return time.Duration(value) * time.Millisecond
+}
+
+// floatSetting returns the non-negative number stored under key, or fallback
+// when the setting is missing or malformed.
+func floatSetting(data map[string]any, key string, fallback float64) float64 {
+	value, ok := util.CheckFloat(data[key])
+	if !ok || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+func secondsSetting(data map[string]any, key string, fallback time.Duration) time.Duration {
+	value, ok := util.CheckInt(data[key])
+	if !ok || value < 0 {
+		return fallback
+	}
+	return time.Duration(value) * time.Second
+}
+
+// positiveSecondsSetting is secondsSetting for settings where zero would mean
+// "fail immediately" and therefore also falls back.
+func positiveSecondsSetting(data map[string]any, key string, fallback time.Duration) time.Duration {
+	value := secondsSetting(data, key, fallback)
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// parseEdgeRoutes reads the list of {"dataset", "edgeURL"} objects stored
+// under edgeRoutes. Like the datasource's own edge, a route may name a bare
+// edge domain under "edge" instead of a URL.
+func parseEdgeRoutes(value any) (EdgeRoutes, error) {
+	items, _ := value.([]any)
+
+	var routes EdgeRoutes
+	for i, item := range items {
+		entry, _ := item.(map[string]any)
+		dataset := strings.TrimSpace(util.CheckString(entry["dataset"]))
+		edge := strings.TrimSpace(util.CheckString(entry["edge"]))
+		edgeURL := strings.TrimSpace(util.CheckString(entry["edgeURL"]))
+		if dataset == "" && edge == "" && edgeURL == "" {
+			// Blank rows are left behind by the settings editor.
+			continue
+		}
+		if dataset == "" {
+			return nil, fmt.Errorf("edge route %d: dataset is required", i+1)
+		}
+		if edge == "" && edgeURL == "" {
+			return nil, fmt.Errorf("edge route %d (%s): edge URL is required", i+1, dataset)
+		}
+		if _, err := path.Match(dataset, ""); err != nil {
+			return nil, fmt.Errorf("edge route %d: invalid dataset pattern %q: %w", i+1, dataset, err)
+		}
+
+		resolved, err := resolveEdgeUrl(edge, edgeURL)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, EdgeRoute{Dataset: dataset, EdgeURL: resolved})
+	}
+
+	return routes, nil
+}
+
+// failoverEdgeURLs reads the list of failover edge URLs, dropping blanks and
+// repeats of the primary edge.
+func failoverEdgeURLs(value any, primary string) []string {
+	items, _ := value.([]any)
+
+	seen := map[string]bool{primary: true}
+	var urls []string
+	for _, item := range items {
+		u := strings.TrimSuffix(strings.TrimSpace(util.CheckString(item)), "/")
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+
+	return urls
+}
+
+func positiveMillisecondsSetting(data map[string]any, key string, fallback time.Duration) time.Duration {
+	value := millisecondsSetting(data, key, fallback)
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
 func resolveEdgeUrl(edge string, edgeUrl string) (string, error) {
 	// Priority 1: edgeURL takes precedence
 	if edgeUrl != "" {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/stretchr/testify/require"
@@ -130,3 +131,278 @@ func TestParseConfigDefaultsEdgeURL(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "https://us-east-1.aws.edge.axiom.co", cfg.EdgeURL)
 }
+
+func TestParseConfigDefaultsRetryPolicy(t *testing.T) {
+	settings := backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{}`),
+	}
+
+	cfg, err := ParseConfig(context.Background(), settings)
+
+	require.NoError(t, err)
+	require.Equal(t, 3, cfg.MaxRetries)
+	require.Equal(t, 500*time.Millisecond, cfg.RetryWaitMin)
+	require.Equal(t, 10*time.Second, cfg.RetryWaitMax)
+}
+
+func TestParseConfigReadsRetryPolicy(t *testing.T) {
+	settings := backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{
+			"maxRetries": "0",
+			"retryWaitMinMs": 250,
+			"retryWaitMaxMs": 100
+		}`),
+	}
+
+	cfg, err := ParseConfig(context.Background(), settings)
+
+	require.NoError(t, err)
+	require.Zero(t, cfg.MaxRetries)
+	require.Equal(t, 250*time.Millisecond, cfg.RetryWaitMin)
+	require.Equal(t, 250*time.Millisecond, cfg.RetryWaitMax)
+}
+
+func TestParseConfigReadsResponseLimits(t *testing.T) {
+	settings := backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{
+			"maxResponseSizeMB": 2,
+			"maxRows": 5000
+		}`),
+	}
+
+	cfg, err := ParseConfig(context.Background(), settings)
+
+	require.NoError(t, err)
+	require.Equal(t, int64(2<<20), cfg.MaxResponseBytes)
+	require.Equal(t, 5000, cfg.MaxRows)
+}
+
+func TestParseConfigCapsResponseSizeByDefault(t *testing.T) {
+	cfg, err := ParseConfig(context.Background(), backend.DataSourceInstanceSettings{JSONData: json.RawMessage(`{}`)})
+	require.NoError(t, err)
+	require.Equal(t, int64(256<<20), cfg.MaxResponseBytes)
+
+	cfg, err = ParseConfig(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{"maxResponseSizeMB": 0}`),
+	})
+	require.NoError(t, err)
+	require.Zero(t, cfg.MaxResponseBytes)
+}
+
+func TestParseConfigReadsResultCacheSettings(t *testing.T) {
+	settings := backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{
+			"cacheTTLSeconds": 30,
+			"cacheNowToleranceSeconds": 120
+		}`),
+	}
+
+	cfg, err := ParseConfig(context.Background(), settings)
+
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, cfg.CacheTTL)
+	require.Equal(t, int64(64<<20), cfg.CacheMaxBytes)
+	require.Equal(t, 2*time.Minute, cfg.CacheNowTolerance)
+	require.Equal(t, 5*time.Minute, cfg.SchemaCacheTTL)
+}
+
+func TestParseConfigDefaultsCacheNowTolerance(t *testing.T) {
+	cfg, err := ParseConfig(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{"cacheTTLSeconds": 30}`),
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, cfg.CacheNowTolerance)
+}
+
+func TestParseConfigReadsSchemaCacheTTL(t *testing.T) {
+	cfg, err := ParseConfig(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{"schemaCacheTTLSeconds": 0}`),
+	})
+
+	require.NoError(t, err)
+	require.Zero(t, cfg.SchemaCacheTTL)
+}
+
+func TestParseConfigReadsQueryLimits(t *testing.T) {
+	tests := []struct {
+		name               string
+		jsonData           string
+		wantMaxConcurrent  int
+		wantMaxInflight    int
+		wantQueryRateLimit float64
+		wantQueryRateBurst int
+	}{
+		{
+			name:              "defaults",
+			jsonData:          `{}`,
+			wantMaxConcurrent: 10,
+		},
+		{
+			name:               "configured",
+			jsonData:           `{"maxConcurrentQueries": 4, "maxInflightQueries": 8, "queryRateLimit": 2.5, "queryRateBurst": 5}`,
+			wantMaxConcurrent:  4,
+			wantMaxInflight:    8,
+			wantQueryRateLimit: 2.5,
+			wantQueryRateBurst: 5,
+		},
+		{
+			name:               "burst defaults to the rate",
+			jsonData:           `{"maxConcurrentQueries": 0, "queryRateLimit": "2.5"}`,
+			wantMaxConcurrent:  10,
+			wantQueryRateLimit: 2.5,
+			wantQueryRateBurst: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseConfig(context.Background(), backend.DataSourceInstanceSettings{
+				JSONData: json.RawMessage(tt.jsonData),
+			})
+
+			require.NoError(t, err)
+			require.Equal(t, tt.wantMaxConcurrent, cfg.MaxConcurrentQueries)
+			require.Equal(t, tt.wantMaxInflight, cfg.MaxInflightQueries)
+			require.Equal(t, tt.wantQueryRateLimit, cfg.QueryRateLimit)
+			require.Equal(t, tt.wantQueryRateBurst, cfg.QueryRateBurst)
+		})
+	}
+}
+
+func TestParseConfigReadsTimeouts(t *testing.T) {
+	cfg, err := ParseConfig(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{"metadataTimeoutSeconds": 5, "healthCheckTimeoutSeconds": 0}`),
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Minute, cfg.QueryTimeout)
+	require.Equal(t, 5*time.Second, cfg.MetadataTimeout)
+	require.Equal(t, 30*time.Second, cfg.HealthCheckTimeout)
+	require.Equal(t, 30*time.Minute, cfg.AsyncQueryTimeout)
+	require.Equal(t, time.Second, cfg.AsyncPollInterval)
+}
+
+func TestParseConfigReadsAsyncQuerySettings(t *testing.T) {
+	cfg, err := ParseConfig(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{"asyncQueryTimeoutSeconds": 7200, "asyncPollIntervalMs": 250}`),
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Hour, cfg.AsyncQueryTimeout)
+	require.Equal(t, 250*time.Millisecond, cfg.AsyncPollInterval)
+}
+
+func TestParseConfigReadsLiveTailSettings(t *testing.T) {
+	cfg, err := ParseConfig(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, cfg.LiveTailPollInterval)
+	require.Equal(t, 1000, cfg.LiveTailMaxRows)
+
+	cfg, err = ParseConfig(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{"liveTailPollIntervalMs": 500, "liveTailMaxRows": 50}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 500*time.Millisecond, cfg.LiveTailPollInterval)
+	require.Equal(t, 50, cfg.LiveTailMaxRows)
+}
+
+func TestParseConfigReadsOrgIDAndDetectsTokenType(t *testing.T) {
+	tests := []struct {
+		token string
+		want  TokenType
+	}{
+		{token: "", want: TokenTypeNone},
+		{token: "xaat-123", want: TokenTypeAPI},
+		{token: "xapt-123", want: TokenTypePersonal},
+		{token: "legacy-token", want: TokenTypeAPI},
+	}
+
+	for _, tt := range tests {
+		cfg, err := ParseConfig(context.Background(), backend.DataSourceInstanceSettings{
+			JSONData:                json.RawMessage(`{"orgId": " acme-x1y2 "}`),
+			DecryptedSecureJSONData: map[string]string{"accessToken": tt.token},
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, "acme-x1y2", cfg.OrgID)
+		require.Equal(t, tt.want, cfg.TokenType(), tt.token)
+	}
+}
+
+func TestParseConfigReadsEdgeRoutes(t *testing.T) {
+	settings := backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{
+			"edgeURL": "https://us-east-1.aws.edge.axiom.co",
+			"edgeRoutes": [
+				{"dataset": "eu-*", "edgeURL": "https://eu-central-1.aws.edge.axiom.co/"},
+				{"dataset": "eu-audit", "edge": "audit.edge.example.com"},
+				{"dataset": "", "edgeURL": ""}
+			]
+		}`),
+	}
+
+	cfg, err := ParseConfig(context.Background(), settings)
+
+	require.NoError(t, err)
+	require.Equal(t, EdgeRoutes{
+		{Dataset: "eu-*", EdgeURL: "https://eu-central-1.aws.edge.axiom.co"},
+		{Dataset: "eu-audit", EdgeURL: "https://audit.edge.example.com"},
+	}, cfg.EdgeRoutes)
+
+	edgeURL, ok := cfg.EdgeRoutes.Match("eu-logs")
+	require.True(t, ok)
+	require.Equal(t, "https://eu-central-1.aws.edge.axiom.co", edgeURL)
+
+	// Exact names win over globs configured before them.
+	edgeURL, ok = cfg.EdgeRoutes.Match("eu-audit")
+	require.True(t, ok)
+	require.Equal(t, "https://audit.edge.example.com", edgeURL)
+
+	_, ok = cfg.EdgeRoutes.Match("us-logs")
+	require.False(t, ok)
+}
+
+func TestParseConfigRejectsInvalidEdgeRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes string
+		err    string
+	}{
+		{name: "missing edge", routes: `[{"dataset": "eu-logs"}]`, err: "edge route 1 (eu-logs): edge URL is required"},
+		{name: "missing dataset", routes: `[{"edgeURL": "https://eu.example.com"}]`, err: "edge route 1: dataset is required"},
+		{name: "bad glob", routes: `[{"dataset": "eu-[", "edgeURL": "https://eu.example.com"}]`, err: `edge route 1: invalid dataset pattern "eu-[": syntax error in pattern`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := backend.DataSourceInstanceSettings{
+				JSONData: json.RawMessage(`{"edgeRoutes": ` + test.routes + `}`),
+			}
+
+			_, err := ParseConfig(context.Background(), settings)
+
+			require.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestParseConfigReadsFailoverEdges(t *testing.T) {
+	settings := backend.DataSourceInstanceSettings{
+		JSONData: json.RawMessage(`{
+			"edgeURL": "https://us-east-1.aws.edge.axiom.co",
+			"failoverEdgeURLs": ["https://eu-central-1.aws.edge.axiom.co/", "", "https://us-east-1.aws.edge.axiom.co"],
+			"edgeFailureThreshold": 5,
+			"edgeProbeIntervalSeconds": 10
+		}`),
+	}
+
+	cfg, err := ParseConfig(context.Background(), settings)
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://eu-central-1.aws.edge.axiom.co"}, cfg.FailoverEdgeURLs)
+	require.Equal(t, 5, cfg.EdgeFailureThreshold)
+	require.Equal(t, 10*time.Second, cfg.EdgeProbeInterval)
+}
@@ -0,0 +1,290 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// maxTagValueDatasets caps the datasets /tag-values searches when the request
+// names none and the field has to be looked up in every dataset's schema.
+const maxTagValueDatasets = 5
+
+// adhocFilter is a filter of a dashboard's ad-hoc filters variable, as the
+// frontend passes it along with each query.
+type adhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// applyAdhocFilters adds filters to query right after its source, so they
+// apply to every event or series the query reads.
+func applyAdhocFilters(kind, query string, filters []adhocFilter) (string, error) {
+	if len(filters) == 0 {
+		return query, nil
+	}
+
+	if kind == "mpl" {
+		end, ok := axiomapi.MPLSourceEnd(query)
+		if !ok {
+			return "", fmt.Errorf("ad-hoc filters need an MPL query that starts with a dataset:metric source")
+		}
+		var clauses strings.Builder
+		for _, filter := range filters {
+			clause, err := mplAdhocFilter(filter)
+			if err != nil {
+				return "", err
+			}
+			clauses.WriteString(" | where " + clause)
+		}
+		return query[:end] + clauses.String() + query[end:], nil
+	}
+
+	end, ok := axiomapi.APLSourceEnd(query)
+	if !ok {
+		return "", fmt.Errorf("ad-hoc filters need an APL query that starts with a tabular source such as ['dataset']")
+	}
+	conditions := make([]string, 0, len(filters))
+	for _, filter := range filters {
+		condition, err := aplAdhocFilter(filter)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	return query[:end] + " | where " + strings.Join(conditions, " and ") + query[end:], nil
+}
+
+// aplAdhocFilter returns the APL condition of filter. Values are compared as
+// strings, which works whatever the type of the field; < and > compare
+// numbers or RFC 3339 times.
+func aplAdhocFilter(filter adhocFilter) (string, error) {
+	field := aplQuotedIdentifier(filter.Key)
+	value := "tostring(" + field + ")"
+
+	switch filter.Operator {
+	case "=":
+		return value + " == " + aplStringLiteral(filter.Value), nil
+	case "!=":
+		return value + " != " + aplStringLiteral(filter.Value), nil
+	case "=~":
+		return value + " matches regex " + aplStringLiteral(filter.Value), nil
+	case "!~":
+		return "not(" + value + " matches regex " + aplStringLiteral(filter.Value) + ")", nil
+	case "<", ">":
+		if number, ok := adhocNumber(filter.Value); ok {
+			return "todouble(" + field + ") " + filter.Operator + " " + number, nil
+		}
+		if t, err := time.Parse(time.RFC3339Nano, filter.Value); err == nil {
+			return field + " " + filter.Operator + " datetime(" + t.UTC().Format(time.RFC3339Nano) + ")", nil
+		}
+		return "", fmt.Errorf("ad-hoc filter %s %s %q: %s needs a number or an RFC 3339 time", filter.Key, filter.Operator, filter.Value, filter.Operator)
+	default:
+		return "", fmt.Errorf("unsupported ad-hoc filter operator %q", filter.Operator)
+	}
+}
+
+// mplAdhocFilter returns the MPL condition of filter on a tag.
+func mplAdhocFilter(filter adhocFilter) (string, error) {
+	tag := mplIdentifier(filter.Key)
+
+	switch filter.Operator {
+	case "=":
+		return tag + " == " + mplStringLiteral(filter.Value), nil
+	case "!=":
+		return tag + " != " + mplStringLiteral(filter.Value), nil
+	case "=~", "!~":
+		return tag + " " + filter.Operator + " /" + strings.ReplaceAll(filter.Value, "/", `\/`) + "/", nil
+	case "<", ">":
+		if number, ok := adhocNumber(filter.Value); ok {
+			return tag + " " + filter.Operator + " " + number, nil
+		}
+		return tag + " " + filter.Operator + " " + mplStringLiteral(filter.Value), nil
+	default:
+		return "", fmt.Errorf("unsupported ad-hoc filter operator %q", filter.Operator)
+	}
+}
+
+// adhocNumber returns value as a number literal when it is a finite number.
+func adhocNumber(value string) (string, bool) {
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+		return "", false
+	}
+	return strconv.FormatFloat(number, 'f', -1, 64), true
+}
+
+var mplPlainIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// mplIdentifier quotes name in backticks unless it is a plain identifier.
+func mplIdentifier(name string) string {
+	if mplPlainIdentifier.MatchString(name) {
+		return name
+	}
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+func mplStringLiteral(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// handleTagKeys lists the keys ad-hoc filters can use: the fields of the
+// events datasets in the dataset parameters and the tags of the metrics
+// datasets in the metricsDataset parameters. Without either, it lists the
+// fields of every dataset.
+func (d *Datasource) handleTagKeys(w http.ResponseWriter, r *http.Request) {
+	logger := log.DefaultLogger.FromContext(r.Context())
+	params := r.URL.Query()
+	datasets, metricsDatasets := params["dataset"], params["metricsDataset"]
+
+	keys := map[string]bool{}
+	if len(datasets) == 0 && len(metricsDatasets) == 0 {
+		schema, err := d.allDatasetFields(r.Context())
+		if err != nil {
+			logger.Error("error looking up schema", "error", err.Error())
+			http.Error(w, "Failed to fetch tag keys", http.StatusInternalServerError)
+			return
+		}
+		for _, fields := range schema.value.([]*axiomapi.DatasetFields) {
+			if fields == nil {
+				continue
+			}
+			for _, field := range fields.Fields {
+				keys[field.Name] = true
+			}
+		}
+	}
+	for _, dataset := range datasets {
+		schema, err := d.datasetFields(r.Context(), dataset)
+		if err != nil {
+			logger.Error("looking up dataset fields failed", "dataset", dataset, "error", err.Error())
+			http.Error(w, "Failed to fetch tag keys", http.StatusInternalServerError)
+			return
+		}
+		for _, field := range schema.value.([]axiomapi.DatasetField) {
+			keys[field.Name] = true
+		}
+	}
+	for _, dataset := range metricsDatasets {
+		tags, err := d.api.GetMetricTags(r.Context(), dataset, "", params.Get("start"), params.Get("end"))
+		if err != nil {
+			logger.Error("failed to fetch dataset tags", "dataset", dataset, "error", err.Error())
+			http.Error(w, "Failed to fetch tag keys", http.StatusInternalServerError)
+			return
+		}
+		for _, tag := range tags {
+			keys[tag] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	writeJSON(w, logger, sorted)
+}
+
+// handleTagValues lists the values of the key parameter for ad-hoc filters,
+// in the same datasets as handleTagKeys. Events datasets suggest their most
+// frequent values between start and end first. Without datasets, the events
+// datasets with a field of that name are searched.
+func (d *Datasource) handleTagValues(w http.ResponseWriter, r *http.Request) {
+	logger := log.DefaultLogger.FromContext(r.Context())
+	params := r.URL.Query()
+	key := params.Get("key")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+	timeRange, err := fieldValuesTimeRange(params.Get("start"), params.Get("end"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	datasets, metricsDatasets := params["dataset"], params["metricsDataset"]
+	if len(datasets) == 0 && len(metricsDatasets) == 0 {
+		datasets, err = d.datasetsWithField(r.Context(), key)
+		if err != nil {
+			logger.Error("error looking up schema", "error", err.Error())
+			http.Error(w, "Failed to fetch tag values", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	counts := map[string]int64{}
+	for _, dataset := range datasets {
+		values, err := d.fieldValues(r.Context(), dataset, key, "", maxFieldValuesLimit, timeRange)
+		if err != nil {
+			logger.Error("fetching field values failed", "dataset", dataset, "field", key, "error", err.Error())
+			http.Error(w, "Failed to fetch tag values", http.StatusInternalServerError)
+			return
+		}
+		for _, value := range values {
+			counts[value.Value] += value.Count
+		}
+	}
+	for _, dataset := range metricsDatasets {
+		values, err := d.api.GetMetricTagValues(r.Context(), dataset, "", key, params.Get("start"), params.Get("end"))
+		if err != nil {
+			logger.Error("fetching tag values failed", "dataset", dataset, "tag", key, "error", err.Error())
+			http.Error(w, "Failed to fetch tag values", http.StatusInternalServerError)
+			return
+		}
+		for _, value := range values {
+			if _, ok := counts[value]; !ok {
+				counts[value] = 0
+			}
+		}
+	}
+
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+
+	writeJSON(w, logger, values)
+}
+
+// datasetsWithField returns the first maxTagValueDatasets datasets, by name,
+// that have a field called name.
+func (d *Datasource) datasetsWithField(ctx context.Context, name string) ([]string, error) {
+	schema, err := d.allDatasetFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var datasets []string
+	for _, fields := range schema.value.([]*axiomapi.DatasetFields) {
+		if fields == nil {
+			continue
+		}
+		for _, field := range fields.Fields {
+			if field.Name == name {
+				datasets = append(datasets, fields.DatasetName)
+				break
+			}
+		}
+	}
+	sort.Strings(datasets)
+
+	return datasets[:min(len(datasets), maxTagValueDatasets)], nil
+}
@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// annotationsQueryType is the query type the frontend sets on annotation
+// queries.
+const annotationsQueryType = "annotations"
+
+// annotationColumns chooses the result columns of an annotation query. Empty
+// choices fall back to defaultAnnotationColumns.
+type annotationColumns struct {
+	Time    string   `json:"time"`
+	TimeEnd string   `json:"timeEnd"`
+	Title   string   `json:"title"`
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags"`
+}
+
+// queryAnnotations runs an APL query and turns each row into an annotation.
+// The frame's fields are named time, timeEnd, title, text and tags, which
+// Grafana maps to annotation events without further configuration.
+func (d *Datasource) queryAnnotations(ctx context.Context, q *queryModel, query backend.DataQuery) (*backend.DataResponse, error) {
+	reqBody := axiomapi.APLQueryRequest{
+		APL:       q.Query,
+		StartTime: query.TimeRange.From,
+		EndTime:   query.TimeRange.To,
+		Timeout:   q.timeout,
+	}
+
+	result, runStats, err := d.queryAPLTableCached(ctx, q, annotationsQueryType, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := startSpan(ctx, "axiom.buildFrames", axiomapi.AttributeQueryKind.String(annotationsQueryType))
+	if len(result.Tables) == 0 {
+		err := fmt.Errorf("annotation query returned no tables")
+		endFramesSpan(span, nil, err)
+		return nil, err
+	}
+	table, err := aplTableFrameBuilder{}.Build(ctx, &result.Tables[0], aplFrameOptions{})
+	if err != nil {
+		endFramesSpan(span, nil, err)
+		return nil, err
+	}
+
+	var columns annotationColumns
+	if q.Annotation != nil {
+		columns = *q.Annotation
+	}
+	frame, err := buildAnnotationFrame(table, columns)
+	if err != nil {
+		endFramesSpan(span, nil, err)
+		response := backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		return &response, nil
+	}
+	frame.RefID = query.RefID
+	frame.Meta = &data.FrameMeta{ExecutedQueryString: *q.Query}
+	applyAxiomTraceID(frame, result.TraceID)
+	applyQueryRunStats([]*data.Frame{frame}, runStats)
+	endFramesSpan(span, []*data.Frame{frame}, nil)
+
+	return &backend.DataResponse{Frames: data.Frames{frame}}, nil
+}
+
+// buildAnnotationFrame maps the rows of table to annotations. Rows without a
+// time are skipped.
+func buildAnnotationFrame(table *data.Frame, chosen annotationColumns) (*data.Frame, error) {
+	columns := defaultAnnotationColumns(table.Fields)
+	byName := map[string]*data.Field{}
+	for _, field := range table.Fields {
+		byName[field.Name] = field
+	}
+	lookup := func(role, name string) (*data.Field, error) {
+		field, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("annotation %s column %q is not in the query result", role, name)
+		}
+		return field, nil
+	}
+
+	var err error
+	var timeField, timeEndField, titleField, textField *data.Field
+	if chosen.Time != "" {
+		if timeField, err = lookup("time", chosen.Time); err != nil {
+			return nil, err
+		}
+	} else if columns.Time != "" {
+		timeField = byName[columns.Time]
+	} else {
+		return nil, fmt.Errorf("annotation query result has no time column")
+	}
+	for _, column := range []struct {
+		role, chosen, fallback string
+		field                  **data.Field
+	}{
+		{"timeEnd", chosen.TimeEnd, columns.TimeEnd, &timeEndField},
+		{"title", chosen.Title, columns.Title, &titleField},
+		{"text", chosen.Text, columns.Text, &textField},
+	} {
+		switch {
+		case column.chosen != "":
+			if *column.field, err = lookup(column.role, column.chosen); err != nil {
+				return nil, err
+			}
+		case column.fallback != "":
+			*column.field = byName[column.fallback]
+		}
+	}
+	tagNames := chosen.Tags
+	if len(tagNames) == 0 {
+		tagNames = columns.Tags
+	}
+	tagFields := make([]*data.Field, 0, len(tagNames))
+	for _, name := range tagNames {
+		field, err := lookup("tags", name)
+		if err != nil {
+			return nil, err
+		}
+		tagFields = append(tagFields, field)
+	}
+
+	times := data.NewField("time", nil, []time.Time{})
+	timeEnds := data.NewField("timeEnd", nil, []*time.Time{})
+	titles := data.NewField("title", nil, []string{})
+	texts := data.NewField("text", nil, []string{})
+	tags := data.NewField("tags", nil, []string{})
+
+	for row := 0; row < table.Rows(); row++ {
+		start, ok := annotationTime(timeField, row)
+		if !ok {
+			continue
+		}
+		times.Append(start)
+		if end, ok := annotationTime(timeEndField, row); ok {
+			timeEnds.Append(&end)
+		} else {
+			timeEnds.Append(nil)
+		}
+		titles.Append(annotationText(titleField, row))
+		texts.Append(annotationText(textField, row))
+
+		var rowTags []string
+		for _, field := range tagFields {
+			rowTags = append(rowTags, annotationTags(field, row)...)
+		}
+		// Grafana splits the tags field on commas.
+		tags.Append(strings.Join(rowTags, ","))
+	}
+
+	frame := data.NewFrame("annotations", times, timeEnds, titles, texts, tags)
+	return frame, nil
+}
+
+// defaultAnnotationColumns picks the time column by the priorities in
+// apl_time.go and the other columns by their names. Text falls back to the
+// log body aliases and then to the first string column.
+func defaultAnnotationColumns(fields []*data.Field) annotationColumns {
+	var columns annotationColumns
+	if i := preferredAPLTimeFieldIndex(fields); i >= 0 {
+		columns.Time = fields[i].Name
+	}
+
+	for _, field := range fields {
+		switch strings.ToLower(field.Name) {
+		case "timeend", "endtime", "end_time":
+			if columns.TimeEnd == "" {
+				columns.TimeEnd = field.Name
+			}
+		case "title":
+			columns.Title = field.Name
+		case "tags":
+			columns.Tags = []string{field.Name}
+		}
+	}
+
+	for _, field := range fields {
+		if strings.EqualFold(field.Name, "text") {
+			columns.Text = field.Name
+			return columns
+		}
+	}
+	for _, field := range fields {
+		if alias, ok := logFieldAliasForName(field.Name); ok && alias.canonicalName == "body" {
+			columns.Text = field.Name
+			return columns
+		}
+	}
+	for _, field := range fields {
+		if field.Type().NonNullableType() == data.FieldTypeString && field.Name != columns.Title && !strings.EqualFold(field.Name, "tags") {
+			columns.Text = field.Name
+			return columns
+		}
+	}
+
+	return columns
+}
+
+func annotationTime(field *data.Field, row int) (time.Time, bool) {
+	if field == nil {
+		return time.Time{}, false
+	}
+
+	switch value := field.At(row).(type) {
+	case time.Time:
+		return value, true
+	case *time.Time:
+		if value != nil {
+			return *value, true
+		}
+	case *string:
+		if value != nil {
+			t, err := time.Parse(time.RFC3339Nano, *value)
+			return t, err == nil
+		}
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// annotationTags reads the tags of a row from an array column, which holds
+// JSON, or from a comma-separated string.
+func annotationTags(field *data.Field, row int) []string {
+	text := annotationText(field, row)
+
+	var items []any
+	var values []string
+	if strings.HasPrefix(text, "[") && json.Unmarshal([]byte(text), &items) == nil {
+		for _, item := range items {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			} else if item != nil {
+				values = append(values, stringifyFrameValue(item))
+			}
+		}
+	} else {
+		values = strings.Split(text, ",")
+	}
+
+	tags := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			tags = append(tags, value)
+		}
+	}
+	return tags
+}
+
+func annotationText(field *data.Field, row int) string {
+	if field == nil {
+		return ""
+	}
+
+	value, ok := field.ConcreteAt(row)
+	if !ok {
+		return ""
+	}
+	switch value := value.(type) {
+	case string:
+		return value
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	default:
+		return stringifyFrameValue(value)
+	}
+}
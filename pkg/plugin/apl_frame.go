@@ -52,12 +52,18 @@ func (b aplResponseFrameBuilder) Build(ctx context.Context, result axiomapi.APLQ
 }
 
 func (b aplResponseFrameBuilder) BuildFrames(ctx context.Context, result axiomapi.APLQueryResponse, opts aplFrameOptions) ([]*data.Frame, error) {
+	return b.BuildDecodedFrames(ctx, aplDecodedResponse{APLQueryResponse: result}, opts)
+}
+
+// BuildDecodedFrames builds frames from a response whose event tables may
+// already be decoded into typed fields by aplStreamDecoder.
+func (b aplResponseFrameBuilder) BuildDecodedFrames(ctx context.Context, result aplDecodedResponse, opts aplFrameOptions) ([]*data.Frame, error) {
 	if len(result.Tables) == 0 {
 		return nil, fmt.Errorf("query returned no tables")
 	}
 
-	if b.shouldBuildTimeSeries(result) {
-		graphFrame, err := aplTimeSeriesFrameBuilder{}.Build(ctx, &result.Tables[0], opts)
+	if b.shouldBuildTimeSeries(result.APLQueryResponse) {
+		graphFrame, err := aplTimeSeriesFrameBuilder{fields: result.tableFields(0)}.Build(ctx, &result.Tables[0], opts)
 		if err != nil {
 			return nil, err
 		}
@@ -73,12 +79,13 @@ func (b aplResponseFrameBuilder) BuildFrames(ctx context.Context, result axiomap
 		return frames, nil
 	}
 
-	table := &result.Tables[0]
+	tableIndex := 0
 	if b.totals && len(result.Tables) > 1 {
-		table = &result.Tables[1]
+		tableIndex = 1
 	}
+	table := &result.Tables[tableIndex]
 
-	frame, err := newAPLEventFrameBuilder(ctx, table.Fields).Build(ctx, table, opts)
+	frame, err := newAPLDecodedFrameBuilder(ctx, table.Fields, result.tableFields(tableIndex)).Build(ctx, table, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -90,13 +97,13 @@ func (b aplResponseFrameBuilder) shouldBuildTimeSeries(result axiomapi.APLQueryR
 	return !b.totals && len(result.Tables) > 1
 }
 
-func (b aplResponseFrameBuilder) timeSeriesTableFrame(ctx context.Context, result axiomapi.APLQueryResponse, opts aplFrameOptions) (*data.Frame, error) {
-	table := &result.Tables[0]
+func (b aplResponseFrameBuilder) timeSeriesTableFrame(ctx context.Context, result aplDecodedResponse, opts aplFrameOptions) (*data.Frame, error) {
+	tableIndex := 0
 	if len(result.Tables) > 1 {
-		table = &result.Tables[1]
+		tableIndex = 1
 	}
 
-	frame, err := aplTableFrameBuilder{}.Build(ctx, table, opts)
+	frame, err := aplTableFrameBuilder{fields: result.tableFields(tableIndex)}.Build(ctx, &result.Tables[tableIndex], opts)
 	if err != nil {
 		return nil, err
 	}
@@ -127,10 +134,25 @@ func newAPLEventFrameBuilder(ctx context.Context, fields []axiQuery.Field) aplFr
 	return aplEventsFrameBuilder{}
 }
 
-type aplTimeSeriesFrameBuilder struct{}
+// newAPLDecodedFrameBuilder is newAPLEventFrameBuilder for tables whose
+// columns may already be decoded into typed fields.
+func newAPLDecodedFrameBuilder(ctx context.Context, fields []axiQuery.Field, decoded []*data.Field) aplFrameBuilder {
+	switch newAPLEventFrameBuilder(ctx, fields).(type) {
+	case aplTraceFrameBuilder:
+		return aplTraceFrameBuilder{fields: decoded}
+	case aplLogsFrameBuilder:
+		return aplLogsFrameBuilder{fields: decoded}
+	default:
+		return aplEventsFrameBuilder{fields: decoded}
+	}
+}
+
+type aplTimeSeriesFrameBuilder struct {
+	fields []*data.Field
+}
 
-func (aplTimeSeriesFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) (*data.Frame, error) {
-	frames, err := aplTimeSeriesFrameBuilder{}.BuildFrames(ctx, result, opts)
+func (b aplTimeSeriesFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) (*data.Frame, error) {
+	frames, err := b.BuildFrames(ctx, result, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -141,10 +163,10 @@ func (aplTimeSeriesFrameBuilder) Build(ctx context.Context, result *axiQuery.Tab
 	return frames[0], nil
 }
 
-func (aplTimeSeriesFrameBuilder) BuildFrames(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) ([]*data.Frame, error) {
+func (b aplTimeSeriesFrameBuilder) BuildFrames(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) ([]*data.Frame, error) {
 	logger := log.DefaultLogger.FromContext(ctx)
 
-	tableFrame, err := aplTableFrameBuilder{}.Build(ctx, result, opts)
+	tableFrame, err := aplTableFrameBuilder{fields: b.fields}.Build(ctx, result, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -229,10 +251,12 @@ func ensureTimeSeriesWideFrameMetadata(frame *data.Frame) {
 	frame.Meta.TypeVersion = data.FrameTypeVersion{0, 1}
 }
 
-type aplEventsFrameBuilder struct{}
+type aplEventsFrameBuilder struct {
+	fields []*data.Field
+}
 
-func (aplEventsFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) (*data.Frame, error) {
-	frame, err := aplTableFrameBuilder{}.Build(ctx, result, opts)
+func (b aplEventsFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) (*data.Frame, error) {
+	frame, err := aplTableFrameBuilder{fields: b.fields}.Build(ctx, result, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -241,134 +265,131 @@ func (aplEventsFrameBuilder) Build(ctx context.Context, result *axiQuery.Table,
 	return frame, nil
 }
 
-type aplTableFrameBuilder struct{}
+type aplTableFrameBuilder struct {
+	// fields are typed columns already decoded by aplStreamDecoder. Columns
+	// without a typed field are converted from the table's generic Columns.
+	fields []*data.Field
+}
 
-func (aplTableFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) (*data.Frame, error) {
+func (b aplTableFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) (*data.Frame, error) {
 	logger := log.DefaultLogger.FromContext(ctx)
 	frame := data.NewFrame("response")
 
-	fields := make([]*data.Field, 0, len(result.Fields))
-	fieldTypes := make([]string, 0, len(result.Fields))
+	if b.fields != nil && len(b.fields) != len(result.Fields) {
+		return nil, fmt.Errorf("decoded %d columns for %d table fields", len(b.fields), len(result.Fields))
+	}
+	if len(result.Columns) > len(result.Fields) {
+		return nil, fmt.Errorf("table column %d has no matching field metadata", len(result.Fields))
+	}
 
+	fields := make([]*data.Field, 0, len(result.Fields))
 	for i, f := range result.Fields {
-		f := f
-		i := i
-		fieldType := f.Type
-		if f.Name == "_time" {
-			fieldType = "datetime"
-		} else if fieldType == "unknown" && i < len(result.Columns) {
-			fieldType = inferUnknownFieldType(f.Name, result.Columns[i])
-			logger.Debug("inferred unknown APL field type", "field", f.Name, "type", fieldType)
+		if b.fields != nil && b.fields[i] != nil {
+			field := b.fields[i]
+			applyAPLFieldMetadata(field, f, opts.FieldMetaByName)
+			fields = append(fields, field)
+			continue
 		}
 
-		var fieldValues any
-		switch fieldType {
-		case "datetime":
-			fieldValues = []*time.Time{}
-		case "integer":
-			fieldValues = []*float64{}
-		case "float":
-			fieldValues = []*float64{}
-		case "bool":
-			fieldValues = []*bool{}
-		case "timespan":
-			fieldValues = []*string{}
-		case "array":
-			fieldValues = []*string{}
-		default:
-			fieldValues = []*string{}
+		var column axiQuery.Column
+		if i < len(result.Columns) {
+			column = result.Columns[i]
 		}
+		fieldType := aplFieldType(ctx, f, column)
 
-		field := data.NewField(f.Name, nil, fieldValues)
+		field := newAPLField(f.Name, fieldType)
 		applyAPLFieldMetadata(field, f, opts.FieldMetaByName)
-
+		for _, value := range column {
+			appendAPLValue(logger, field, fieldType, value)
+		}
 		fields = append(fields, field)
-		fieldTypes = append(fieldTypes, fieldType)
+	}
+	frame.Fields = fields
+	applyAPLFrameMetadata(frame, opts)
+
+	return frame, nil
+}
+
+// aplFieldType resolves the frame type for an APL field. Fields Axiom reports
+// as "unknown" are inferred from their column values when those are known.
+func aplFieldType(ctx context.Context, f axiQuery.Field, column axiQuery.Column) string {
+	if f.Name == "_time" {
+		return "datetime"
+	}
+	if f.Type == "unknown" && column != nil {
+		fieldType := inferUnknownFieldType(f.Name, column)
+		log.DefaultLogger.FromContext(ctx).Debug("inferred unknown APL field type", "field", f.Name, "type", fieldType)
+		return fieldType
 	}
 
-	for colIndex, col := range result.Columns {
-		if colIndex >= len(result.Fields) || colIndex >= len(fields) {
-			return nil, fmt.Errorf("table column %d has no matching field metadata", colIndex)
+	return f.Type
+}
+
+func newAPLField(name string, fieldType string) *data.Field {
+	var fieldValues any
+	switch fieldType {
+	case "datetime":
+		fieldValues = []*time.Time{}
+	case "integer":
+		fieldValues = []*float64{}
+	case "float":
+		fieldValues = []*float64{}
+	case "bool":
+		fieldValues = []*bool{}
+	case "timespan":
+		fieldValues = []*string{}
+	case "array":
+		fieldValues = []*string{}
+	default:
+		fieldValues = []*string{}
+	}
+
+	return data.NewField(name, nil, fieldValues)
+}
+
+// appendAPLValue converts a decoded JSON cell into the representation
+// newAPLField chose for fieldType and appends it to field.
+func appendAPLValue(logger log.Logger, field *data.Field, fieldType string, value any) {
+	if value == nil {
+		field.Append(nil)
+		return
+	}
+
+	switch fieldType {
+	case "datetime":
+		timestamp, ok := value.(time.Time)
+		if ok {
+			field.Append(&timestamp)
+			return
 		}
 
-		for i := 0; i < len(col); i++ {
-			switch fieldTypes[colIndex] {
-			case "datetime":
-				if col[i] == nil {
-					fields[colIndex].Append(nil)
-					continue
-				}
-
-				timestamp, ok := col[i].(time.Time)
-				if ok {
-					fields[colIndex].Append(&timestamp)
-					continue
-				}
-
-				t, err := time.Parse(time.RFC3339Nano, col[i].(string))
-				if err != nil {
-					logger.Warn("Failed to parse time", "time", col[i])
-					fields[colIndex].Append(nil)
-					continue
-				}
-				fields[colIndex].Append(&t)
-			case "integer":
-				if col[i] == nil {
-					fields[colIndex].Append(nil)
-					continue
-				}
-				num := col[i].(float64)
-				fields[colIndex].Append(&num)
-			case "float":
-				if col[i] == nil {
-					fields[colIndex].Append(nil)
-					continue
-				}
-				num := col[i].(float64)
-				fields[colIndex].Append(&num)
-			case "string", "unknown":
-				if col[i] == nil {
-					fields[colIndex].Append(nil)
-					continue
-				}
-				txt, ok := col[i].(string)
-				if !ok {
-					txt = stringifyFrameValue(col[i])
-				}
-				fields[colIndex].Append(&txt)
-			case "bool":
-				if col[i] == nil {
-					fields[colIndex].Append(nil)
-					continue
-				}
-				b := col[i].(bool)
-				fields[colIndex].Append(&b)
-			case "timespan":
-				if col[i] == nil {
-					fields[colIndex].Append(nil)
-					continue
-				}
-				num := col[i].(string)
-				fields[colIndex].Append(&num)
-			case "array":
-				if col[i] == nil {
-					fields[colIndex].Append(nil)
-					continue
-				}
-				txt := stringifyFrameValue(col[i])
-				fields[colIndex].Append(&txt)
-			default:
-				if col[i] == nil {
-					fields[colIndex].Append(nil)
-					continue
-				}
-				txt := stringifyFrameValue(col[i])
-				fields[colIndex].Append(&txt)
-			}
+		t, err := time.Parse(time.RFC3339Nano, value.(string))
+		if err != nil {
+			logger.Warn("Failed to parse time", "time", value)
+			field.Append(nil)
+			return
+		}
+		field.Append(&t)
+	case "integer":
+		num := value.(float64)
+		field.Append(&num)
+	case "float":
+		num := value.(float64)
+		field.Append(&num)
+	case "string", "unknown":
+		txt, ok := value.(string)
+		if !ok {
+			txt = stringifyFrameValue(value)
 		}
+		field.Append(&txt)
+	case "bool":
+		b := value.(bool)
+		field.Append(&b)
+	case "timespan":
+		num := value.(string)
+		field.Append(&num)
+	default:
+		txt := stringifyFrameValue(value)
+		field.Append(&txt)
 	}
-	frame.Fields = fields
-	applyAPLFrameMetadata(frame, opts)
-
-	return frame, nil
 }
@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+
+	axiQuery "github.com/axiomhq/axiom-go/axiom/query"
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// effectiveMaxRows combines the datasource row limit with a query's own
+// limit. A query can ask for fewer rows than the datasource allows, never more.
+// Zero means no limit.
+func effectiveMaxRows(datasourceLimit int, queryLimit *int) int {
+	if queryLimit == nil || *queryLimit <= 0 {
+		return datasourceLimit
+	}
+	if datasourceLimit > 0 && datasourceLimit < *queryLimit {
+		return datasourceLimit
+	}
+
+	return *queryLimit
+}
+
+// limitRows returns the result with at most maxRows rows per table, counting
+// the rows it cuts off in droppedRows. Aggregated tables are left whole,
+// since their rows are buckets or groups rather than events. The result's
+// columns and fields are not modified, so cached results stay intact.
+func (r aplDecodedResponse) limitRows(maxRows int) aplDecodedResponse {
+	if maxRows <= 0 {
+		return r
+	}
+
+	tables := slices.Clone(r.Tables)
+	fields := make([][]*data.Field, len(tables))
+	dropped := make([]int, len(tables))
+	limited := false
+	for i := range tables {
+		fields[i] = r.tableFields(i)
+		dropped[i] = r.dropped(i)
+
+		rows := newAPLTableValues(&tables[i], fields[i]).rowCount()
+		if rows <= maxRows || isAggregatedAPLTable(tables[i]) {
+			continue
+		}
+		limited = true
+		dropped[i] += rows - maxRows
+
+		if tables[i].Columns != nil {
+			columns := make([]axiQuery.Column, len(tables[i].Columns))
+			for j, column := range tables[i].Columns {
+				columns[j] = column[:min(len(column), maxRows)]
+			}
+			tables[i].Columns = columns
+		}
+		if fields[i] != nil {
+			limitedFields := make([]*data.Field, len(fields[i]))
+			for j, field := range fields[i] {
+				if field != nil {
+					limitedFields[j] = firstFieldValues(field, maxRows)
+				}
+			}
+			fields[i] = limitedFields
+		}
+	}
+	if !limited {
+		return r
+	}
+
+	r.Tables, r.fields, r.droppedRows = tables, fields, dropped
+	return r
+}
+
+// firstFieldValues returns a copy of field with only its first n values.
+func firstFieldValues(field *data.Field, n int) *data.Field {
+	if field.Len() <= n {
+		return field
+	}
+
+	limited := data.NewFieldFromFieldType(field.Type(), n)
+	limited.Name = field.Name
+	limited.Labels = field.Labels.Copy()
+	if field.Config != nil {
+		config := *field.Config
+		limited.Config = &config
+	}
+	for i := 0; i < n; i++ {
+		limited.Set(i, field.At(i))
+	}
+
+	return limited
+}
+
+// applyAPLRowLimit warns when rows of an APL result are missing, either
+// because the row limit dropped them or because Axiom matched more raw events
+// than it returned. It reports whether the row limit dropped any rows.
+func applyAPLRowLimit(frames []*data.Frame, result aplDecodedResponse, maxRows int) (truncated bool) {
+	if len(frames) == 0 {
+		return false
+	}
+
+	dropped := result.dropped(0)
+	// Axiom sent the rows that were kept and those the limit dropped.
+	returned := frames[0].Rows() + dropped
+	if dropped > 0 {
+		truncated = true
+		appendFrameNotice(frames[0], data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Showing the first %d of %d rows. Lower the time range or add a limit to the query to see the rest.", maxRows, returned),
+		})
+	}
+
+	// Rows matched counts raw events, so it only describes the returned rows
+	// when the query did not aggregate them.
+	if result.Status == nil || isAggregatedAPLResult(result.APLQueryResponse) {
+		return truncated
+	}
+	if matched := result.Status.RowsMatched; matched > int64(returned) {
+		appendFrameNotice(frames[0], data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Axiom matched %d rows but returned %d. Lower the time range or add a limit to the query to see the rest.", matched, returned),
+		})
+	}
+
+	return truncated
+}
+
+func isAggregatedAPLResult(result axiomapi.APLQueryResponse) bool {
+	return slices.ContainsFunc(result.Tables, isAggregatedAPLTable)
+}
+
+func isAggregatedAPLTable(table axiQuery.Table) bool {
+	if len(table.Groups) > 0 {
+		return true
+	}
+	return slices.ContainsFunc(table.Fields, func(field axiQuery.Field) bool {
+		return field.Aggregation != nil
+	})
+}
+
+func appendFrameNotice(frame *data.Frame, notice data.Notice) {
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.Notices = append(frame.Meta.Notices, notice)
+}
@@ -48,13 +48,18 @@ type logColumn struct {
 	priority int
 }
 
-type aplLogsFrameBuilder struct{}
+type aplLogsFrameBuilder struct {
+	// fields are typed columns already decoded by aplStreamDecoder; see
+	// aplTableValues.
+	fields []*data.Field
+}
 
-func (aplLogsFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) (*data.Frame, error) {
+func (b aplLogsFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) (*data.Frame, error) {
 	logger := log.DefaultLogger.FromContext(ctx)
 	columns := logColumns(result.Fields)
 	timestampColumns := logTimestampColumns(result.Fields)
-	rowCount := traceRowCount(result.Columns)
+	values := newAPLTableValues(result, b.fields)
+	rowCount := values.rowCount()
 
 	timestampField := data.NewField("timestamp", nil, []time.Time{})
 	bodyField := data.NewField("body", nil, []string{})
@@ -63,15 +68,15 @@ func (aplLogsFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, op
 	labelsField := data.NewField("labels", nil, []json.RawMessage{})
 
 	for row := 0; row < rowCount; row++ {
-		timestamp, ok := logRowTimestamp(result, timestampColumns, row)
+		timestamp, ok := logRowTimestamp(values, timestampColumns, row)
 		if !ok {
 			logger.Warn("failed to parse log timestamp", "row", row)
 		}
 		timestampField.Append(timestamp)
-		bodyField.Append(logValueString(logColumnValue(result, columns, "body", row)))
-		severityField.Append(logValueString(logColumnValue(result, columns, "severity", row)))
-		idField.Append(logValueString(logColumnValue(result, columns, "id", row)))
-		labelsField.Append(logLabelsValue(result, columns, row))
+		bodyField.Append(logValueString(logColumnValue(values, columns, "body", row)))
+		severityField.Append(logValueString(logColumnValue(values, columns, "severity", row)))
+		idField.Append(logValueString(logColumnValue(values, columns, "id", row)))
+		labelsField.Append(logLabelsValue(values, columns, row))
 	}
 
 	frame := data.NewFrame(
@@ -133,21 +138,18 @@ func logTimestampColumns(fields []axiQuery.Field) []logColumn {
 	return columns
 }
 
-func logColumnValue(result *axiQuery.Table, columns map[string]logColumn, canonicalName string, row int) any {
+func logColumnValue(values aplTableValues, columns map[string]logColumn, canonicalName string, row int) any {
 	column, ok := columns[canonicalName]
-	if !ok || column.index >= len(result.Columns) || row >= len(result.Columns[column.index]) {
+	if !ok {
 		return nil
 	}
 
-	return result.Columns[column.index][row]
+	return values.value(column.index, row)
 }
 
-func logRowTimestamp(result *axiQuery.Table, columns []logColumn, row int) (time.Time, bool) {
+func logRowTimestamp(values aplTableValues, columns []logColumn, row int) (time.Time, bool) {
 	for _, column := range columns {
-		if column.index >= len(result.Columns) || row >= len(result.Columns[column.index]) {
-			continue
-		}
-		timestamp, ok := logTimestamp(result.Columns[column.index][row])
+		timestamp, ok := logTimestamp(values.value(column.index, row))
 		if ok {
 			return timestamp, true
 		}
@@ -181,17 +183,14 @@ func logValueString(value any) string {
 	}
 }
 
-func logLabelsValue(result *axiQuery.Table, columns map[string]logColumn, row int) json.RawMessage {
+func logLabelsValue(values aplTableValues, columns map[string]logColumn, row int) json.RawMessage {
 	labels := make(map[string]any)
-	for fieldIndex, field := range result.Fields {
+	for fieldIndex, field := range values.table.Fields {
 		if _, isLogField := logFieldAliasForName(field.Name); isLogField {
 			continue
 		}
-		if fieldIndex >= len(result.Columns) || row >= len(result.Columns[fieldIndex]) {
-			continue
-		}
 
-		value := result.Columns[fieldIndex][row]
+		value := values.value(fieldIndex, row)
 		if value == nil {
 			continue
 		}
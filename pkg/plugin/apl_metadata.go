@@ -1,7 +1,11 @@
 package plugin
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 
 	axiQuery "github.com/axiomhq/axiom-go/axiom/query"
@@ -87,6 +91,9 @@ func applyAPLFrameMetadata(frame *data.Frame, opts aplFrameOptions) {
 	frame.Meta.Stats = aplQueryStats(*opts.Status)
 	setFrameMetaCustom(frame, "axiomStatus", opts.Status)
 	frame.Meta.Notices = append(frame.Meta.Notices, aplQueryNotices(*opts.Status)...)
+	if diagnostics := aplStatusDiagnostics(*opts.Status); len(diagnostics) > 0 {
+		setFrameMetaCustom(frame, "aplDiagnostics", diagnostics)
+	}
 	applyAxiomTraceID(frame, opts.TraceID)
 }
 
@@ -182,3 +189,98 @@ func noticeSeverityForPriority(priority string) data.NoticeSeverity {
 		return data.NoticeSeverityInfo
 	}
 }
+
+// aplPositionPattern matches the "line: 1, col: 20: message" prefix Axiom uses
+// for positioned APL compiler messages.
+var aplPositionPattern = regexp.MustCompile(`(?i)line:?\s*(\d+),?\s*col(?:umn)?:?\s*(\d+):?\s*(.*)`)
+
+// aplDiagnostic is a compiler message tied to a position in the APL query.
+// Lines and columns are 1-based, matching what Axiom reports.
+type aplDiagnostic struct {
+	Severity string `json:"severity"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Message  string `json:"message"`
+	Code     string `json:"code,omitempty"`
+}
+
+// parseAPLDiagnostics extracts every positioned message from text. Messages
+// without position info are skipped since the editor cannot place them.
+func parseAPLDiagnostics(text, severity, code string) []aplDiagnostic {
+	var diagnostics []aplDiagnostic
+	for _, line := range strings.Split(text, "\n") {
+		match := aplPositionPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		lineNumber, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		column, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+
+		diagnostics = append(diagnostics, aplDiagnostic{
+			Severity: severity,
+			Line:     lineNumber,
+			Column:   column,
+			Message:  strings.TrimSpace(match[3]),
+			Code:     code,
+		})
+	}
+
+	return diagnostics
+}
+
+func aplStatusDiagnostics(status axiomapi.APLQueryStatus) []aplDiagnostic {
+	var diagnostics []aplDiagnostic
+	for _, message := range status.Messages {
+		severity := noticeSeverityForPriority(message.Priority).String()
+		diagnostics = append(diagnostics, parseAPLDiagnostics(message.Msg, severity, message.Code)...)
+	}
+
+	return diagnostics
+}
+
+// aplErrorDiagnostics returns the compile diagnostics carried by a rejected
+// APL query, or nil when err is not a positioned query error.
+func aplErrorDiagnostics(err error) []aplDiagnostic {
+	var apiErr *axiomapi.APIError
+	if !errors.As(err, &apiErr) {
+		return nil
+	}
+	if apiErr.StatusCode != http.StatusBadRequest && apiErr.StatusCode != http.StatusUnprocessableEntity {
+		return nil
+	}
+
+	return parseAPLDiagnostics(apiErr.Message, data.NoticeSeverityError.String(), apiErr.Code)
+}
+
+// aplDiagnosticsFrame builds an empty frame that carries diagnostics as
+// notices and in Meta.Custom so the query editor can mark the failing tokens.
+func aplDiagnosticsFrame(query string, diagnostics []aplDiagnostic, traceID string) *data.Frame {
+	frame := data.NewFrame("").SetMeta(&data.FrameMeta{ExecutedQueryString: query})
+	for _, diagnostic := range diagnostics {
+		frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{
+			Severity: aplDiagnosticNoticeSeverity(diagnostic.Severity),
+			Text:     fmt.Sprintf("line %d, column %d: %s", diagnostic.Line, diagnostic.Column, diagnostic.Message),
+		})
+	}
+	setFrameMetaCustom(frame, "aplDiagnostics", diagnostics)
+	applyAxiomTraceID(frame, traceID)
+
+	return frame
+}
+
+func aplDiagnosticNoticeSeverity(severity string) data.NoticeSeverity {
+	switch severity {
+	case data.NoticeSeverityError.String():
+		return data.NoticeSeverityError
+	case data.NoticeSeverityWarning.String():
+		return data.NoticeSeverityWarning
+	default:
+		return data.NoticeSeverityInfo
+	}
+}
@@ -0,0 +1,464 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	axiQuery "github.com/axiomhq/axiom-go/axiom/query"
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// aplDecodedResponse is a tabular APL response read by aplStreamDecoder.
+type aplDecodedResponse struct {
+	axiomapi.APLQueryResponse
+	// fields holds the typed frame fields per table. An entry is nil when the
+	// table's values were kept in Tables[i].Columns instead; for logs and trace
+	// tables, single columns may be kept there while the rest are typed.
+	fields [][]*data.Field
+	// droppedRows counts the rows per table that Axiom sent beyond the row
+	// limit and that were never decoded or were cut off afterwards.
+	droppedRows []int
+}
+
+func (r aplDecodedResponse) tableFields(index int) []*data.Field {
+	if index >= len(r.fields) {
+		return nil
+	}
+
+	return r.fields[index]
+}
+
+func (r aplDecodedResponse) dropped(index int) int {
+	if index >= len(r.droppedRows) {
+		return 0
+	}
+
+	return r.droppedRows[index]
+}
+
+// aplTableValues reads the cells of a table whose columns are either generic
+// values in Table.Columns or typed fields decoded by aplStreamDecoder.
+type aplTableValues struct {
+	table  *axiQuery.Table
+	fields []*data.Field
+}
+
+func newAPLTableValues(table *axiQuery.Table, fields []*data.Field) aplTableValues {
+	return aplTableValues{table: table, fields: fields}
+}
+
+func (v aplTableValues) rowCount() int {
+	rowCount := traceRowCount(v.table.Columns)
+	for _, field := range v.fields {
+		if field != nil && field.Len() > rowCount {
+			rowCount = field.Len()
+		}
+	}
+
+	return rowCount
+}
+
+// value returns the cell at column and row in the form the generic JSON
+// decoding would produce, except that datetimes are time.Time. Missing cells
+// are nil.
+func (v aplTableValues) value(column, row int) any {
+	if column < len(v.fields) && v.fields[column] != nil {
+		field := v.fields[column]
+		if row >= field.Len() {
+			return nil
+		}
+		value, ok := field.ConcreteAt(row)
+		if !ok {
+			return nil
+		}
+		return value
+	}
+	if column >= len(v.table.Columns) || row >= len(v.table.Columns[column]) {
+		return nil
+	}
+
+	return v.table.Columns[column][row]
+}
+
+// aplStreamDecoder decodes a tabular APL response while it is read from the
+// wire. Columns of plain event tables go straight into typed frame fields, so
+// the response is never held as raw JSON and generic values at the same time.
+// Logs and trace tables only get typed fields for columns whose values match
+// their declared scalar type; other columns, such as arrays and objects their
+// builders turn into labels and tags, keep their generic values.
+//
+// Rows beyond maxRows are skipped while reading, except in aggregated tables,
+// where they are buckets or groups rather than events. Zero means no limit.
+type aplStreamDecoder struct {
+	ctx     context.Context
+	logger  log.Logger
+	maxRows int
+	result  aplDecodedResponse
+}
+
+func newAPLStreamDecoder(ctx context.Context, maxRows int) *aplStreamDecoder {
+	return &aplStreamDecoder{
+		ctx:     ctx,
+		logger:  log.DefaultLogger.FromContext(ctx),
+		maxRows: maxRows,
+	}
+}
+
+// Result returns the response decoded so far.
+func (d *aplStreamDecoder) Result() aplDecodedResponse {
+	return d.result
+}
+
+func (d *aplStreamDecoder) Decode(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	if err := expectJSONDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		key, err := jsonObjectKey(dec)
+		if err != nil {
+			return err
+		}
+
+		switch key {
+		case "format":
+			err = dec.Decode(&d.result.Format)
+		case "status":
+			err = dec.Decode(&d.result.Status)
+		case "datasetNames":
+			err = dec.Decode(&d.result.DatasetNames)
+		case "fieldsMetaMap":
+			err = dec.Decode(&d.result.FieldsMetaMap)
+		case "tables":
+			err = d.decodeTables(dec)
+		default:
+			err = skipJSONValue(dec)
+		}
+		if err != nil {
+			return fmt.Errorf("decoding APL response %q: %w", key, err)
+		}
+	}
+
+	return expectJSONDelim(dec, '}')
+}
+
+func (d *aplStreamDecoder) decodeTables(dec *json.Decoder) error {
+	ok, err := openJSONArray(dec)
+	if err != nil || !ok {
+		return err
+	}
+
+	for dec.More() {
+		table, fields, dropped, err := d.decodeTable(dec)
+		if err != nil {
+			return err
+		}
+		d.result.Tables = append(d.result.Tables, table)
+		d.result.fields = append(d.result.fields, fields)
+		d.result.droppedRows = append(d.result.droppedRows, dropped)
+	}
+
+	return expectJSONDelim(dec, ']')
+}
+
+func (d *aplStreamDecoder) decodeTable(dec *json.Decoder) (axiQuery.Table, []*data.Field, int, error) {
+	var (
+		table   axiQuery.Table
+		fields  []*data.Field
+		dropped int
+		rest    = map[string]json.RawMessage{}
+	)
+
+	if err := expectJSONDelim(dec, '{'); err != nil {
+		return table, nil, 0, err
+	}
+
+	for dec.More() {
+		key, err := jsonObjectKey(dec)
+		if err != nil {
+			return table, nil, 0, err
+		}
+
+		switch key {
+		case "fields":
+			err = dec.Decode(&table.Fields)
+		case "groups":
+			err = dec.Decode(&table.Groups)
+		case "columns":
+			// Axiom sends fields and groups before columns; if it ever does
+			// not, fall back to generic values and let the frame builder
+			// convert them.
+			limit := d.maxRows
+			if isAggregatedAPLTable(table) {
+				limit = 0
+			}
+			switch {
+			case table.Fields == nil:
+				err = dec.Decode(&table.Columns)
+			case d.decodesTyped(table.Fields):
+				fields, dropped, err = d.decodeTypedColumns(dec, table.Fields, limit)
+			default:
+				fields, table.Columns, dropped, err = d.decodeMixedColumns(dec, table.Fields, limit)
+			}
+		default:
+			var raw json.RawMessage
+			err = dec.Decode(&raw)
+			rest[key] = raw
+		}
+		if err != nil {
+			return table, nil, 0, fmt.Errorf("decoding table %q: %w", key, err)
+		}
+	}
+
+	if err := expectJSONDelim(dec, '}'); err != nil {
+		return table, nil, 0, err
+	}
+
+	if len(rest) > 0 {
+		b, err := json.Marshal(rest)
+		if err != nil {
+			return table, nil, 0, err
+		}
+		if err := json.Unmarshal(b, &table); err != nil {
+			return table, nil, 0, err
+		}
+	}
+
+	return table, fields, dropped, nil
+}
+
+// decodesTyped reports whether a table with these fields is built by the
+// plain table builder and can therefore skip the generic column values.
+func (d *aplStreamDecoder) decodesTyped(fields []axiQuery.Field) bool {
+	_, ok := newAPLEventFrameBuilder(d.ctx, fields).(aplEventsFrameBuilder)
+	return ok
+}
+
+func (d *aplStreamDecoder) decodeTypedColumns(dec *json.Decoder, tableFields []axiQuery.Field, limit int) ([]*data.Field, int, error) {
+	fields := make([]*data.Field, 0, len(tableFields))
+	dropped := 0
+
+	ok, err := openJSONArray(dec)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ok {
+		for dec.More() {
+			if len(fields) >= len(tableFields) {
+				return nil, 0, fmt.Errorf("table column %d has no matching field metadata", len(fields))
+			}
+			field, columnDropped, err := d.decodeTypedColumn(dec, tableFields[len(fields)], limit)
+			if err != nil {
+				return nil, 0, err
+			}
+			fields = append(fields, field)
+			dropped = max(dropped, columnDropped)
+		}
+		if err := expectJSONDelim(dec, ']'); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// Match the generic builder, which emits empty fields for missing columns.
+	// Logs and trace builders read missing columns as nulls either way.
+	for _, f := range tableFields[len(fields):] {
+		fields = append(fields, newAPLField(f.Name, aplFieldType(d.ctx, f, nil)))
+	}
+
+	return fields, dropped, nil
+}
+
+// decodeMixedColumns reads the columns of a logs or trace table. Columns whose
+// values unmarshal into their declared type become typed fields; the others
+// are kept as generic columns at the same index, with nil entries for columns
+// that were typed.
+func (d *aplStreamDecoder) decodeMixedColumns(dec *json.Decoder, tableFields []axiQuery.Field, limit int) ([]*data.Field, []axiQuery.Column, int, error) {
+	var (
+		fields  []*data.Field
+		columns = []axiQuery.Column{}
+		dropped int
+	)
+
+	ok, err := openJSONArray(dec)
+	if err != nil || !ok {
+		return nil, nil, 0, err
+	}
+	for dec.More() {
+		if len(columns) >= len(tableFields) {
+			return nil, nil, 0, fmt.Errorf("table column %d has no matching field metadata", len(columns))
+		}
+		f := tableFields[len(columns)]
+
+		raw, columnDropped, err := readAPLColumn(dec, limit)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		dropped = max(dropped, columnDropped)
+		if f.Type != "unknown" || f.Name == "_time" {
+			if field, ok := unmarshalTypedAPLColumn(f.Name, aplFieldType(d.ctx, f, nil), raw); ok {
+				fields = append(fields, field)
+				columns = append(columns, nil)
+				continue
+			}
+		}
+
+		var column axiQuery.Column
+		if err := json.Unmarshal(raw, &column); err != nil {
+			return nil, nil, 0, err
+		}
+		fields = append(fields, nil)
+		columns = append(columns, column)
+	}
+	if err := expectJSONDelim(dec, ']'); err != nil {
+		return nil, nil, 0, err
+	}
+
+	return fields, columns, dropped, nil
+}
+
+// decodeTypedColumn reads one column and converts it into a typed field. Only
+// this column's raw JSON up to limit values is buffered; well-typed columns
+// are unmarshalled straight into the field's value slice.
+func (d *aplStreamDecoder) decodeTypedColumn(dec *json.Decoder, f axiQuery.Field, limit int) (*data.Field, int, error) {
+	raw, dropped, err := readAPLColumn(dec, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if f.Type != "unknown" || f.Name == "_time" {
+		fieldType := aplFieldType(d.ctx, f, nil)
+		if field, ok := unmarshalTypedAPLColumn(f.Name, fieldType, raw); ok {
+			return field, dropped, nil
+		}
+	}
+
+	// Unknown types are inferred from all values, and columns holding values
+	// that do not match their declared type go through the generic conversion.
+	var column axiQuery.Column
+	if err := json.Unmarshal(raw, &column); err != nil {
+		return nil, 0, err
+	}
+	fieldType := aplFieldType(d.ctx, f, column)
+	field := newAPLField(f.Name, fieldType)
+	for _, value := range column {
+		appendAPLValue(d.logger, field, fieldType, value)
+	}
+
+	return field, dropped, nil
+}
+
+// readAPLColumn reads the raw JSON of a column, keeping only its first limit
+// values, and returns how many values it skipped. Zero means no limit.
+func readAPLColumn(dec *json.Decoder, limit int) (json.RawMessage, int, error) {
+	var raw json.RawMessage
+	if limit <= 0 {
+		err := dec.Decode(&raw)
+		return raw, 0, err
+	}
+
+	ok, err := openJSONArray(dec)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !ok {
+		return json.RawMessage("null"), 0, nil
+	}
+
+	raw = append(raw, '[')
+	values, dropped := 0, 0
+	for dec.More() {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, 0, err
+		}
+		if values == limit {
+			dropped++
+			continue
+		}
+		if values > 0 {
+			raw = append(raw, ',')
+		}
+		raw = append(raw, value...)
+		values++
+	}
+	if err := expectJSONDelim(dec, ']'); err != nil {
+		return nil, 0, err
+	}
+
+	return append(raw, ']'), dropped, nil
+}
+
+func unmarshalTypedAPLColumn(name string, fieldType string, raw json.RawMessage) (*data.Field, bool) {
+	var values any
+	switch fieldType {
+	case "datetime":
+		values = &[]*time.Time{}
+	case "integer", "float":
+		values = &[]*float64{}
+	case "bool":
+		values = &[]*bool{}
+	case "string", "timespan":
+		values = &[]*string{}
+	default:
+		return nil, false
+	}
+
+	if err := json.Unmarshal(raw, values); err != nil {
+		return nil, false
+	}
+
+	return data.NewField(name, nil, reflect.ValueOf(values).Elem().Interface()), true
+}
+
+func jsonObjectKey(dec *json.Decoder) (string, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := token.(string)
+	if !ok {
+		return "", fmt.Errorf("expected object key, got %v", token)
+	}
+
+	return key, nil
+}
+
+func expectJSONDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %q, got %v", delim, token)
+	}
+
+	return nil
+}
+
+// openJSONArray consumes the start of an array. It returns false for null.
+func openJSONArray(dec *json.Decoder) (bool, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	if token == nil {
+		return false, nil
+	}
+	if token != json.Delim('[') {
+		return false, fmt.Errorf("expected array, got %v", token)
+	}
+
+	return true, nil
+}
+
+func skipJSONValue(dec *json.Decoder) error {
+	var raw json.RawMessage
+	return dec.Decode(&raw)
+}
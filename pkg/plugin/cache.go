@@ -0,0 +1,436 @@
+package plugin
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/axiomhq/axiom-grafana/pkg/config"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// exploreApp is the Grafana app name the frontend sends for Explore queries.
+const exploreApp = "explore"
+
+// resultCache is an in-process LRU cache of Axiom query responses shared by
+// all queries of a datasource instance. Entries expire after a fixed TTL and
+// the least recently used ones are evicted once the memory budget is spent.
+type resultCache struct {
+	ttl          time.Duration
+	maxBytes     int64
+	nowTolerance time.Duration
+	now          func() time.Time
+	mu           sync.Mutex
+	entries      map[string]*list.Element
+	lru          *list.List
+	bytes        int64
+	hits, misses int64
+}
+
+type resultCacheEntry struct {
+	key     string
+	value   any
+	size    int64
+	expires time.Time
+}
+
+// resultCacheStats describes how a response was served. It is attached to the
+// frames of the response so cache behaviour shows up in the query inspector.
+type resultCacheStats struct {
+	Hit    bool  `json:"hit"`
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// newResultCache returns nil when caching is disabled, which makes every
+// lookup fall through to Axiom.
+func newResultCache(c *config.PluginConfig) *resultCache {
+	if c.CacheTTL <= 0 || c.CacheMaxBytes <= 0 {
+		return nil
+	}
+
+	return &resultCache{
+		ttl:          c.CacheTTL,
+		maxBytes:     c.CacheMaxBytes,
+		nowTolerance: c.CacheNowTolerance,
+		now:          time.Now,
+		entries:      map[string]*list.Element{},
+		lru:          list.New(),
+	}
+}
+
+// get returns the cached value for key. Misses are counted by set, so a
+// failed query does not skew the stats.
+func (c *resultCache) get(key string) (any, resultCacheStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, resultCacheStats{}, false
+	}
+	entry := element.Value.(*resultCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(element)
+		return nil, resultCacheStats{}, false
+	}
+
+	c.lru.MoveToFront(element)
+	c.hits++
+	return entry.value, resultCacheStats{Hit: true, Hits: c.hits, Misses: c.misses}, true
+}
+
+// set stores value under key. Values larger than the whole budget are not
+// cached at all.
+func (c *resultCache) set(key string, value any, size int64) resultCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.misses++
+	stats := resultCacheStats{Hits: c.hits, Misses: c.misses}
+	if size > c.maxBytes {
+		return stats
+	}
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&resultCacheEntry{
+		key:     key,
+		value:   value,
+		size:    size,
+		expires: c.now().Add(c.ttl),
+	})
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+
+	return stats
+}
+
+func (c *resultCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*resultCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+// shouldUseResultCache reports whether a query may be answered from the
+// cache. Explore is interactive and always goes to Axiom, as do ranges ending
+// so close to now that recent events could still be missing.
+func (d *Datasource) shouldUseResultCache(q *queryModel, query backend.DataQuery) bool {
+	if d.cache == nil || q.App == exploreApp {
+		return false
+	}
+
+	return d.cache.now().Sub(query.TimeRange.To) >= d.cache.nowTolerance
+}
+
+// alignTimeRange widens the range to whole intervals so dashboards refreshed
+// a few seconds apart produce the same cache key.
+func alignTimeRange(timeRange backend.TimeRange, interval time.Duration) backend.TimeRange {
+	if interval <= 0 {
+		return timeRange
+	}
+
+	from := timeRange.From.Truncate(interval)
+	to := timeRange.To.Truncate(interval)
+	if to.Before(timeRange.To) {
+		to = to.Add(interval)
+	}
+
+	return backend.TimeRange{From: from, To: to}
+}
+
+// requestKey is the result cache and in-flight key of a request for q, whose
+// query text is text and whose range is from..to. Once execQuery aligned q's
+// range, the key query and range stand in for them; kind then has to tell apart the different
+// requests built from the same query.
+func (q *queryModel) requestKey(kind, text string, from, to time.Time, extra ...string) string {
+	if q.keyQuery == "" {
+		return resultCacheKey(kind, text, from, to, extra...)
+	}
+
+	return resultCacheKey(kind, q.keyQuery, q.keyRange.From, q.keyRange.To, extra...)
+}
+
+func resultCacheKey(kind, query string, from, to time.Time, extra ...string) string {
+	parts := append([]string{kind, normalizeCacheQuery(query), from.UTC().Format(time.RFC3339Nano), to.UTC().Format(time.RFC3339Nano)}, extra...)
+	return strings.Join(parts, "\x00")
+}
+
+// normalizeCacheQuery drops indentation, trailing whitespace, blank lines and
+// comment lines, none of which change the query result.
+func normalizeCacheQuery(query string) string {
+	lines := strings.Split(query, "\n")
+	normalized := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		normalized = append(normalized, line)
+	}
+
+	return strings.Join(normalized, "\n")
+}
+
+func applyResultCacheStats(frames []*data.Frame, stats *resultCacheStats) {
+	if stats == nil {
+		return
+	}
+
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Stats = append(frame.Meta.Stats,
+			data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "Result cache hits"}, Value: float64(stats.Hits)},
+			data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "Result cache misses"}, Value: float64(stats.Misses)},
+		)
+		setFrameMetaCustom(frame, "resultCache", *stats)
+	}
+}
+
+// queryRunStats describes how a query was answered, for the frame stats.
+type queryRunStats struct {
+	// cache is nil when the result cache was not consulted.
+	cache *resultCacheStats
+	// queued is how long the upstream call waited for the datasource's query
+	// limits. It is nil when no limits are configured or nothing was sent.
+	queued *time.Duration
+}
+
+func applyQueryRunStats(frames []*data.Frame, stats queryRunStats) {
+	applyResultCacheStats(frames, stats.cache)
+	if stats.queued != nil {
+		applyQueueTime(frames, *stats.queued)
+	}
+}
+
+// sharedResult is what a coalesced upstream call hands to each waiter.
+type sharedResult[T any] struct {
+	value T
+	size  int64
+	stats queryRunStats
+}
+
+// sharedQuery answers a query from cache when q allows it; cache may be nil.
+// Misses go upstream through d.inflight, so identical queries running at the
+// same time share one call to Axiom, which then waits for the datasource's
+// query limits. clone copies a result before it is handed to a caller that
+// may modify it.
+func sharedQuery[T any](ctx context.Context, d *Datasource, cache *resultCache, q *queryModel, key string, run func(context.Context) (T, int64, error), clone func(T) T) (T, queryRunStats, error) {
+	return cachedQuery(ctx, d, cache, q, key, true, run, clone)
+}
+
+// unsharedQuery is sharedQuery for queries whose upstream call must run on
+// the caller's own context. It still uses the cache and the query limits but
+// never joins an identical in-flight query, so the caller's deadline reaches
+// the call and whatever the call returns, such as a partial result, goes
+// back to that caller.
+func unsharedQuery[T any](ctx context.Context, d *Datasource, cache *resultCache, q *queryModel, key string, run func(context.Context) (T, int64, error), clone func(T) T) (T, queryRunStats, error) {
+	return cachedQuery(ctx, d, cache, q, key, false, run, clone)
+}
+
+func cachedQuery[T any](ctx context.Context, d *Datasource, cache *resultCache, q *queryModel, key string, share bool, run func(context.Context) (T, int64, error), clone func(T) T) (T, queryRunStats, error) {
+	useCache := q.useCache && cache != nil
+	if useCache {
+		if value, stats, ok := cache.get(key); ok {
+			return clone(value.(T)), queryRunStats{cache: &stats}, nil
+		}
+	}
+
+	// The shared call outlives the caller that starts it, but waiting for
+	// the query limits is still bounded by that caller's deadline.
+	deadline, hasDeadline := ctx.Deadline()
+	upstream := func(ctx context.Context) (any, error) {
+		var result sharedResult[T]
+		if d.limiter != nil {
+			waitCtx := ctx
+			if hasDeadline {
+				var cancel context.CancelFunc
+				waitCtx, cancel = context.WithDeadline(ctx, deadline)
+				defer cancel()
+			}
+			release, queued, err := d.limiter.acquire(waitCtx)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			result.stats.queued = &queued
+		}
+
+		value, size, err := run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.value = value
+		result.size = size
+		if useCache {
+			stats := cache.set(key, clone(value), size)
+			result.stats.cache = &stats
+		}
+		return result, nil
+	}
+
+	var (
+		value  any
+		shared bool
+		err    error
+	)
+	if share {
+		// Queries with different timeouts may fail differently, so they only
+		// share a call with their own kind.
+		inflightKey := key + "\x00" + q.timeout.String()
+		value, shared, err = d.inflight.do(ctx, inflightKey, upstream)
+	} else {
+		value, err = upstream(ctx)
+	}
+	if err != nil {
+		var zero T
+		return zero, queryRunStats{}, err
+	}
+
+	result := value.(sharedResult[T])
+	q.fetchedBytes += result.size
+	if shared {
+		return clone(result.value), result.stats, nil
+	}
+	return result.value, result.stats, nil
+}
+
+// queryAPLCached runs a streamed APL query through sharedQuery. The decoded
+// fields are handed out as copies because frame building and the row limit
+// modify them in place.
+func (d *Datasource) queryAPLCached(ctx context.Context, q *queryModel, reqBody axiomapi.APLQueryRequest) (aplDecodedResponse, queryRunStats, error) {
+	key := q.requestKey("apl", *reqBody.APL, reqBody.StartTime, reqBody.EndTime, reqBody.Cursor)
+	return sharedQuery(ctx, d, d.cache, q, key, func(ctx context.Context) (aplDecodedResponse, int64, error) {
+		return d.queryAPLDecoded(ctx, reqBody)
+	}, aplDecodedResponse.clone)
+}
+
+// queryAPLAsyncCached runs an APL query as an async job through
+// unsharedQuery, so a job stopped by Grafana's deadline hands its partial
+// result to the caller. Partial results come back as an error, so they are
+// never cached.
+func (d *Datasource) queryAPLAsyncCached(ctx context.Context, q *queryModel, reqBody axiomapi.APLQueryRequest) (aplDecodedResponse, queryRunStats, error) {
+	key := q.requestKey("apl-async", *reqBody.APL, reqBody.StartTime, reqBody.EndTime, reqBody.Cursor)
+	return unsharedQuery(ctx, d, d.cache, q, key, func(ctx context.Context) (aplDecodedResponse, int64, error) {
+		result, err := d.api.QueryAPLAsync(ctx, reqBody)
+		return aplDecodedResponse{APLQueryResponse: result}, aplTablesSize(result), err
+	}, aplDecodedResponse.clone)
+}
+
+// queryAPLTableCached runs a buffered APL query through sharedQuery. kind
+// names what the request was built for, such as the logs volume.
+func (d *Datasource) queryAPLTableCached(ctx context.Context, q *queryModel, kind string, reqBody axiomapi.APLQueryRequest, extra ...string) (axiomapi.APLQueryResponse, queryRunStats, error) {
+	key := q.requestKey(kind, *reqBody.APL, reqBody.StartTime, reqBody.EndTime, extra...)
+	return sharedQuery(ctx, d, d.cache, q, key, func(ctx context.Context) (axiomapi.APLQueryResponse, int64, error) {
+		result, err := d.api.QueryAPL(ctx, reqBody)
+		return result, aplTablesSize(result), err
+	}, readOnlyResult[axiomapi.APLQueryResponse])
+}
+
+// queryMetricsCached runs an MPL query through sharedQuery. The chart width
+// is part of the key since Axiom resamples series to it.
+func (d *Datasource) queryMetricsCached(ctx context.Context, q *queryModel, reqBody axiomapi.MPLQueryRequest) (axiomapi.MetricsQueryResponse, queryRunStats, error) {
+	key := q.requestKey("mpl", *reqBody.MPL, reqBody.StartTime, reqBody.EndTime, fmt.Sprint(reqBody.ChartWidth))
+	return sharedQuery(ctx, d, d.cache, q, key, func(ctx context.Context) (axiomapi.MetricsQueryResponse, int64, error) {
+		result, err := d.api.QueryMetrics(ctx, reqBody)
+		return result, metricsResponseSize(result), err
+	}, readOnlyResult[axiomapi.MetricsQueryResponse])
+}
+
+// readOnlyResult is the clone function for responses whose frame builders
+// copy every value, so sharing them is safe.
+func readOnlyResult[T any](result T) T {
+	return result
+}
+
+// clone copies the decoded fields so callers can modify them without touching
+// the cached response. Generic table columns are only ever read, and so are
+// the typed fields of logs and trace tables, which keep their Columns next to
+// the fields and whose builders copy every value.
+func (r aplDecodedResponse) clone() aplDecodedResponse {
+	if r.fields == nil {
+		return r
+	}
+
+	fields := make([][]*data.Field, len(r.fields))
+	for i, tableFields := range r.fields {
+		if tableFields == nil || r.Tables[i].Columns != nil {
+			fields[i] = tableFields
+			continue
+		}
+		fields[i] = make([]*data.Field, len(tableFields))
+		for j, field := range tableFields {
+			if field == nil {
+				continue
+			}
+			copied := data.NewFieldFromFieldType(field.Type(), 0)
+			copied.Name = field.Name
+			copied.Labels = field.Labels.Copy()
+			if field.Config != nil {
+				config := *field.Config
+				copied.Config = &config
+			}
+			copied.AppendAll(field)
+			fields[i][j] = copied
+		}
+	}
+	r.fields = fields
+
+	return r
+}
+
+// aplTablesSize approximates the memory held by generic table columns.
+func aplTablesSize(result axiomapi.APLQueryResponse) int64 {
+	var size int64
+	for _, table := range result.Tables {
+		for _, column := range table.Columns {
+			size += int64(len(column)) * 16
+			for _, value := range column {
+				if s, ok := value.(string); ok {
+					size += int64(len(s))
+				}
+			}
+		}
+	}
+
+	return size
+}
+
+// metricsResponseSize approximates the memory held by an MPL response.
+func metricsResponseSize(result axiomapi.MetricsQueryResponse) int64 {
+	var size int64
+	for _, series := range result.Series {
+		size += int64(len(series.Data)) * 16
+		size += int64(len(series.Metric))
+		for k, v := range series.Tags {
+			size += int64(len(k) + len(v))
+		}
+	}
+
+	return size
+}
+
+// countingReader counts the bytes read through it, which is used as the
+// cache size of a streamed response.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// inflightGroup lets concurrent identical queries share one upstream call.
+// Unlike singleflight, the shared call is detached from the caller that
+// started it: it only stops once every waiter has gone away, so one viewer
+// closing a dashboard does not fail the query for everyone else.
+// The zero value is ready to use.
+type inflightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+type inflightCall struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	// waiters counts callers still waiting; joined counts every caller that
+	// ever waited, which tells whether the result was shared.
+	waiters int
+	joined  int
+	value   any
+	err     error
+}
+
+// do runs fn once for all concurrent callers with the same key and returns
+// its result. shared reports whether other callers received the same value,
+// in which case it must not be modified.
+func (g *inflightGroup) do(ctx context.Context, key string, fn func(context.Context) (any, error)) (value any, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*inflightCall{}
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		// Keep the values of the first caller's context, such as its logger,
+		// but not its cancellation.
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &inflightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go g.run(callCtx, key, call, fn)
+	}
+	call.waiters++
+	call.joined++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.joined > 1, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			g.forget(key, call)
+		}
+		g.mu.Unlock()
+		return nil, false, ctx.Err()
+	}
+}
+
+func (g *inflightGroup) run(ctx context.Context, key string, call *inflightCall, fn func(context.Context) (any, error)) {
+	defer call.cancel()
+
+	value, err := func() (value any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("pkg: %v", r)
+			}
+		}()
+		return fn(ctx)
+	}()
+
+	g.mu.Lock()
+	g.forget(key, call)
+	call.value, call.err = value, err
+	g.mu.Unlock()
+	close(call.done)
+}
+
+// forget removes call so later callers start a fresh one. g.mu must be held.
+func (g *inflightGroup) forget(key string, call *inflightCall) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	axiQuery "github.com/axiomhq/axiom-go/axiom/query"
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// datasetInfo is a dataset in the /datasets catalog.
+type datasetInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Kind        string    `json:"kind"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// RetentionDays is nil when events are kept forever.
+	RetentionDays *int         `json:"retentionDays"`
+	Region        string       `json:"region,omitempty"`
+	Usage         datasetUsage `json:"usage"`
+}
+
+// datasetUsage tells which Grafana signals a dataset can serve.
+type datasetUsage struct {
+	Logs    bool `json:"logs"`
+	Traces  bool `json:"traces"`
+	Metrics bool `json:"metrics"`
+}
+
+// handleDatasets lists the datasets with their metadata. The optional kind
+// parameter keeps datasets of an Axiom kind, such as "otel:metrics:v1", or of
+// a signal, "logs", "traces" or "metrics"; it may be repeated or comma
+// separated. The optional name parameter keeps datasets whose name contains
+// it, ignoring case.
+func (d *Datasource) handleDatasets(w http.ResponseWriter, r *http.Request) {
+	logger := log.DefaultLogger.FromContext(r.Context())
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	datasets, err := d.datasetCatalog(r.Context())
+	if err != nil {
+		logger.Error("error listing datasets", "error", err.Error())
+		http.Error(w, "Failed to list datasets", http.StatusInternalServerError)
+		return
+	}
+
+	var kinds []string
+	for _, value := range r.URL.Query()["kind"] {
+		for _, kind := range strings.Split(value, ",") {
+			if kind = strings.TrimSpace(kind); kind != "" {
+				kinds = append(kinds, kind)
+			}
+		}
+	}
+	name := strings.ToLower(r.URL.Query().Get("name"))
+
+	filtered := []datasetInfo{}
+	for _, dataset := range datasets {
+		if name != "" && !strings.Contains(strings.ToLower(dataset.Name), name) {
+			continue
+		}
+		if len(kinds) > 0 && !dataset.matchesAnyKind(kinds) {
+			continue
+		}
+		filtered = append(filtered, dataset)
+	}
+
+	writeJSON(w, logger, filtered)
+}
+
+// datasetCatalog lists the datasets sorted by name. Events datasets are
+// classified by their fields with the same heuristics that pick the frame
+// type of a query result; when the fields cannot be fetched they are only
+// classified by kind.
+func (d *Datasource) datasetCatalog(ctx context.Context) ([]datasetInfo, error) {
+	datasets, err := d.api.Datasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldsByDataset := map[string][]axiomapi.DatasetField{}
+	if schema, err := d.allDatasetFields(ctx); err != nil {
+		log.DefaultLogger.FromContext(ctx).Warn("failed to fetch dataset fields, classifying datasets by kind only", "error", err)
+	} else {
+		for _, fields := range schema.value.([]*axiomapi.DatasetFields) {
+			if fields != nil {
+				fieldsByDataset[fields.DatasetName] = fields.Fields
+			}
+		}
+	}
+
+	catalog := make([]datasetInfo, 0, len(datasets))
+	for _, dataset := range datasets {
+		info := datasetInfo{
+			ID:          dataset.ID,
+			Name:        dataset.Name,
+			Description: dataset.Description,
+			Kind:        dataset.Kind,
+			CreatedAt:   dataset.CreatedAt,
+			Region:      dataset.Region,
+			Usage:       classifyDataset(ctx, dataset.Kind, fieldsByDataset[dataset.Name]),
+		}
+		if dataset.UseRetentionPeriod && dataset.RetentionDays > 0 {
+			retentionDays := dataset.RetentionDays
+			info.RetentionDays = &retentionDays
+		}
+		catalog = append(catalog, info)
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].Name < catalog[j].Name })
+
+	return catalog, nil
+}
+
+// classifyDataset derives a dataset's usage from the signal in its kind,
+// such as "metrics" in "otel:metrics:v1". Events datasets can hold anything,
+// so their fields decide.
+func classifyDataset(ctx context.Context, kind string, fields []axiomapi.DatasetField) datasetUsage {
+	switch datasetKindSignal(kind) {
+	case "metrics":
+		return datasetUsage{Metrics: true}
+	case "traces":
+		return datasetUsage{Traces: true}
+	case "logs":
+		return datasetUsage{Logs: true}
+	}
+	if len(fields) == 0 {
+		return datasetUsage{}
+	}
+
+	aplFields := datasetAPLFields(fields)
+	return datasetUsage{
+		Traces: fieldsMatchTrace(ctx, aplFields),
+		Logs:   fieldsMatchLogs(aplFields),
+	}
+}
+
+// datasetAPLFields returns the fields a query over the whole dataset
+// returns, for the frame builder heuristics.
+func datasetAPLFields(fields []axiomapi.DatasetField) []axiQuery.Field {
+	// Every event has a _time, though the field list may leave it out.
+	aplFields := []axiQuery.Field{{Name: "_time", Type: "datetime"}}
+	for _, field := range fields {
+		aplFields = append(aplFields, axiQuery.Field{Name: field.Name, Type: field.Type})
+	}
+	return aplFields
+}
+
+func datasetKindSignal(kind string) string {
+	parts := strings.Split(kind, ":")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// matchesAnyKind reports whether the dataset has one of kinds, given either
+// as Axiom kinds or as signals.
+func (d datasetInfo) matchesAnyKind(kinds []string) bool {
+	for _, kind := range kinds {
+		switch strings.ToLower(kind) {
+		case "logs":
+			if d.Usage.Logs {
+				return true
+			}
+		case "traces":
+			if d.Usage.Traces {
+				return true
+			}
+		case "metrics":
+			if d.Usage.Metrics {
+				return true
+			}
+		default:
+			if d.Kind == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -3,7 +3,9 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"runtime/debug"
 	"strings"
 	"time"
@@ -13,8 +15,10 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana-plugin-sdk-go/experimental/concurrent"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Make sure Datasource implements required interfaces. This is important to do
@@ -27,6 +31,7 @@ var (
 	_ backend.CheckHealthHandler    = (*Datasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 	_ backend.CallResourceHandler   = (*Datasource)(nil)
+	_ backend.StreamHandler         = (*Datasource)(nil)
 )
 
 // Datasource is an example datasource which can respond to data queries, reports
@@ -34,6 +39,30 @@ var (
 type Datasource struct {
 	backend.CallResourceHandler
 	api *axiomapi.Client
+	// maxRows caps the rows of each APL result frame. Zero means no limit.
+	maxRows int
+	// cache holds recent query responses. It is nil when caching is disabled.
+	cache *resultCache
+	// schemas holds dataset fields. It is nil when schema caching is
+	// disabled.
+	schemas *schemaCache
+	// fieldValuesCache holds field value suggestions for the APL editor.
+	fieldValuesCache *resultCache
+	// inflight shares upstream calls between identical concurrent queries.
+	inflight inflightGroup
+	// limiter throttles calls to Axiom. It is nil when no limits are set.
+	limiter *queryLimiter
+	// maxConcurrentQueries bounds how many queries of one request run at once.
+	maxConcurrentQueries int
+	// tails are the running live tails, polled every liveTailPollInterval
+	// for at most liveTailMaxRows new events.
+	tails                liveTails
+	liveTailPollInterval time.Duration
+	liveTailMaxRows      int
+	// tokenType and orgID describe the configured credentials for the
+	// health check.
+	tokenType config.TokenType
+	orgID     string
 }
 
 type queryModel struct {
@@ -45,6 +74,45 @@ type queryModel struct {
 	Totals                  bool    `json:"totals"`
 	IncludeTotalsTableFrame bool    `json:"includeTotalsTableFrame"`
 	IncludeLogsVolumeFrame  bool    `json:"includeLogsVolumeFrame"`
+	// MaxRows lowers the datasource's row limit for this query.
+	MaxRows *int `json:"maxRows"`
+	// App is the Grafana app that sent the query, such as "dashboard" or
+	// "explore".
+	App string `json:"app"`
+	// Timeout shortens the datasource's query timeout for this query, as a
+	// Go duration such as "30s".
+	Timeout string `json:"timeout"`
+	// Async runs an APL query as a job on Axiom that is polled until it
+	// finishes, for queries that take longer than a single request may.
+	Async bool `json:"async"`
+	// Cursor asks for the page of raw events after the one whose frames
+	// carried it as nextCursor.
+	Cursor string `json:"cursor"`
+	// AdhocFilters are the dashboard's ad-hoc filters, which are added to
+	// the query after its source.
+	AdhocFilters []adhocFilter `json:"adhocFilters"`
+	// Annotation chooses the columns of an annotation query's result.
+	Annotation *annotationColumns `json:"annotation"`
+
+	// useCache is set by execQuery when the query may be served from the
+	// result cache.
+	useCache bool
+	// keyQuery and keyRange stand in for the request's query text and range
+	// in result cache and in-flight keys: they are the query expanded over its
+	// range aligned to whole intervals, so dashboards loaded or refreshed a
+	// few seconds apart share results. Axiom still gets the range the panel
+	// asked for. keyQuery is empty for queries that did not go through
+	// execQuery.
+	keyQuery string
+	keyRange backend.TimeRange
+	// timeout is the parsed Timeout, or zero for the datasource default.
+	timeout time.Duration
+	// fetchedBytes counts the bytes of the results fetched from Axiom for
+	// this query, for its trace span.
+	fetchedBytes int64
+	// fromAlerting is set when the query comes from an alert rule or an
+	// expression, which need numeric frames.
+	fromAlerting bool
 }
 
 // NewDatasource creates a new datasource instance.
@@ -66,7 +134,18 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 	}
 
 	ds := Datasource{
-		api: api,
+		api:     api,
+		maxRows: config.MaxRows,
+		cache:   newResultCache(config),
+		schemas: newSchemaCache(config),
+		limiter: newQueryLimiter(config),
+
+		fieldValuesCache:     newFieldValuesCache(),
+		maxConcurrentQueries: config.MaxConcurrentQueries,
+		liveTailPollInterval: config.LiveTailPollInterval,
+		liveTailMaxRows:      config.LiveTailMaxRows,
+		tokenType:            config.TokenType(),
+		orgID:                config.OrgID,
 	}
 	resourceHandler := ds.newResourceHandler()
 	ds.CallResourceHandler = resourceHandler
@@ -79,6 +158,9 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 // be disposed and a new one will be created using NewSampleDatasource factory function.
 func (d *Datasource) Dispose() {
 	// Clean up datasource instance resources.
+	d.tails.close()
+	d.api.Close()
+	d.schemas.close()
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -86,6 +168,9 @@ func (d *Datasource) Dispose() {
 // The QueryDataResponse contains a map of RefID to the response for each query, and each response
 // contains Frames ([]*Frame).
 func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := startSpan(ctx, "axiom.QueryData", attribute.Int("axiom.queries", len(req.Queries)))
+	defer span.End()
+
 	logger := log.DefaultLogger.FromContext(ctx)
 	// log panic
 	defer func() {
@@ -101,10 +186,46 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 		}
 	}()
 
-	return concurrent.QueryData(ctx, req, d.execQuery, 10)
+	maxConcurrentQueries := d.maxConcurrentQueries
+	if maxConcurrentQueries <= 0 {
+		maxConcurrentQueries = config.DefaultMaxConcurrentQueries
+	}
+
+	// The alerting headers are not forwarded HTTP headers, so concurrent.Query
+	// leaves them out.
+	fromAlerting := isAlertingRequest(req.Headers)
+	return concurrent.QueryData(ctx, req, func(ctx context.Context, query concurrent.Query) backend.DataResponse {
+		return d.execQuery(ctx, query, fromAlerting)
+	}, maxConcurrentQueries)
 }
 
-func (d *Datasource) execQuery(ctx context.Context, query concurrent.Query) (response backend.DataResponse) {
+// execQuery runs a single query. fromAlerting is set for requests from alert
+// rules and expressions.
+func (d *Datasource) execQuery(ctx context.Context, query concurrent.Query, fromAlerting bool) (response backend.DataResponse) {
+	ctx, span := startSpan(ctx, "axiom.execQuery", attributeRefID.String(query.DataQuery.RefID))
+	start := time.Now()
+	// Unmarshal the JSON into our queryModel.
+	var qm queryModel
+	qm.fromAlerting = fromAlerting
+	// metricsKind labels the query's metrics once it is known to run.
+	var metricsKind string
+	defer func() {
+		if metricsKind != "" {
+			observeQuery(metricsKind, start, response, qm.fetchedBytes)
+		}
+		if response.Error != nil {
+			_ = tracing.Error(span, response.Error)
+		}
+		span.SetAttributes(
+			axiomapi.AttributeRows.Int(framesRows(response.Frames)),
+			axiomapi.AttributeResponseBytes.Int64(qm.fetchedBytes),
+		)
+		if traceID := framesTraceID(response.Frames); traceID != "" {
+			span.SetAttributes(axiomapi.AttributeTraceID.String(traceID))
+		}
+		span.End()
+	}()
+
 	logger := log.DefaultLogger.FromContext(ctx)
 	// log panic
 	defer func() {
@@ -119,9 +240,6 @@ func (d *Datasource) execQuery(ctx context.Context, query concurrent.Query) (res
 		}
 	}()
 
-	// Unmarshal the JSON into our queryModel.
-	var qm queryModel
-
 	err := json.Unmarshal(query.DataQuery.JSON, &qm)
 	if err != nil {
 		// Log the actual error since it will be included in the Grafana server log and return a more generic message to the end user.
@@ -137,24 +255,81 @@ func (d *Datasource) execQuery(ctx context.Context, query concurrent.Query) (res
 		return backend.DataResponse{}
 	}
 
+	if qm.Timeout != "" {
+		// Axiom's query endpoints take no deadline of their own, so the
+		// timeout is enforced by cancelling the request, which also stops the
+		// query in Axiom.
+		qm.timeout, err = time.ParseDuration(qm.Timeout)
+		if err != nil || qm.timeout <= 0 {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("invalid query timeout %q: use a positive duration such as 30s or 2m", qm.Timeout))
+		}
+	}
+
 	kind := "apl"
 	if qm.Kind != nil && *qm.Kind != "" {
 		kind = *qm.Kind
 	}
+	span.SetAttributes(axiomapi.AttributeQueryKind.String(kind))
+
+	// Macros are expanded before the ad-hoc filters are added, so a macro
+	// typed into a filter value stays part of the value.
+	saved := *qm.Query
+	expanded, err := expandMacros(kind, saved, query.DataQuery)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	qm.useCache = d.shouldUseResultCache(&qm, query.DataQuery)
+	// Viewers of a "now-6h" dashboard each send a slightly different now, so
+	// the key range is aligned even when the cache is off for the query to
+	// let them share one upstream call.
+	keyQuery := query.DataQuery
+	keyQuery.TimeRange = alignTimeRange(query.DataQuery.TimeRange, query.DataQuery.Interval)
+	// The same expansion already succeeded over the exact range.
+	qm.keyQuery, _ = expandMacros(kind, saved, keyQuery)
+	qm.keyRange = keyQuery.TimeRange
+
+	if len(qm.AdhocFilters) > 0 {
+		expanded, err = applyAdhocFilters(kind, expanded, qm.AdhocFilters)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		qm.keyQuery, _ = applyAdhocFilters(kind, qm.keyQuery, qm.AdhocFilters)
+	}
+	qm.Query = &expanded
 
 	var queryResponse *backend.DataResponse
+	isEventsQuery := false
 
 	// make request to axiom
+	metricsKind = kind
 	if isLogsVolumeQuery(query.DataQuery, &qm) {
+		metricsKind = "logs-volume"
 		queryResponse, err = d.queryLogsVolume(ctx, &qm, query.DataQuery, datasourceName(query.PluginContext))
+	} else if query.DataQuery.QueryType == annotationsQueryType {
+		if kind == "mpl" {
+			return backend.ErrDataResponse(backend.StatusBadRequest, "annotation queries need an APL query")
+		}
+		metricsKind = annotationsQueryType
+		queryResponse, err = d.queryAnnotations(ctx, &qm, query.DataQuery)
 	} else if kind == "mpl" {
 		queryResponse, err = d.queryMetrics(ctx, &qm, query.DataQuery.RefID, query.DataQuery.TimeRange.From, query.DataQuery.TimeRange.To, query.DataQuery.MaxDataPoints)
 	} else {
+		isEventsQuery = true
 		queryResponse, err = d.queryEvents(ctx, &qm, query.DataQuery, datasourceName(query.PluginContext))
 	}
 	if err != nil {
 		logger.Error("failed to query axiom", "error", err)
-		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("axiom error: %v", err.Error()))
+		if traceID := apiErrorTraceID(err); traceID != "" {
+			span.SetAttributes(axiomapi.AttributeTraceID.String(traceID))
+		}
+		response := queryErrorResponse(err)
+		// Only APL queries that ran as saved are diagnosed: logs volume wraps
+		// the user's query and macros and ad-hoc filters rewrite it, so
+		// Axiom's positions would not line up with the editor.
+		if diagnostics := aplErrorDiagnostics(err); isEventsQuery && *qm.Query == saved && len(diagnostics) > 0 {
+			response.Frames = append(response.Frames, aplDiagnosticsFrame(*qm.Query, diagnostics, apiErrorTraceID(err)))
+		}
+		return response
 	}
 	if queryResponse == nil {
 		logger.Error("query returned nil response")
@@ -187,15 +362,36 @@ func (d *Datasource) queryEvents(ctx context.Context, q *queryModel, query backe
 		APL:       q.Query,
 		StartTime: query.TimeRange.From,
 		EndTime:   query.TimeRange.To,
+		Timeout:   q.timeout,
+		Cursor:    q.Cursor,
 	}
 
-	result, err := d.api.QueryAPL(ctx, reqBody)
+	var (
+		result   aplDecodedResponse
+		runStats queryRunStats
+		err      error
+	)
+	if q.Async {
+		result, runStats, err = d.queryAPLAsyncCached(ctx, q, reqBody)
+		// Partial results carry Status.IsPartial, which adds the partial
+		// response notice.
+		var partialErr *axiomapi.PartialResultError
+		if errors.As(err, &partialErr) {
+			result, err = aplDecodedResponse{APLQueryResponse: partialErr.Result}, nil
+		}
+	} else {
+		result, runStats, err = d.queryAPLCached(ctx, q, reqBody)
+	}
 	if err != nil {
 		return nil, err
 	}
+	// The datasource limit already applied while decoding; a query may ask
+	// for fewer rows still.
+	maxRows := effectiveMaxRows(d.maxRows, q.MaxRows)
+	result = result.limitRows(maxRows)
 
 	frameOptions := aplFrameOptions{
-		FieldMetaByName: fieldMetaByNameForResponse(result),
+		FieldMetaByName: fieldMetaByNameForResponse(result.APLQueryResponse),
 		Status:          result.Status,
 		TraceID:         result.TraceID,
 	}
@@ -203,10 +399,19 @@ func (d *Datasource) queryEvents(ctx context.Context, q *queryModel, query backe
 		frameOptions.Query = *q.Query
 	}
 
-	frames, err := newAPLResponseFrameBuilder(q.Totals, q.IncludeTotalsTableFrame).BuildFrames(ctx, result, frameOptions)
+	frameCtx, span := startSpan(ctx, "axiom.buildFrames", axiomapi.AttributeQueryKind.String("apl"))
+	frames, err := newAPLResponseFrameBuilder(q.Totals, q.IncludeTotalsTableFrame).BuildDecodedFrames(frameCtx, result, frameOptions)
 	if err != nil {
+		endFramesSpan(span, nil, err)
 		return nil, err
 	}
+	if q.fromAlerting {
+		frames = numericLongFrames(frames)
+	}
+	truncated := applyAPLRowLimit(frames, result, maxRows)
+	applyQueryRunStats(frames, runStats)
+	applyNextCursor(frames, result.APLQueryResponse, truncated)
+	endFramesSpan(span, frames, nil)
 
 	var response backend.DataResponse
 	if shouldPrependLogsVolumeFrame(q, frames) {
@@ -220,6 +425,25 @@ func (d *Datasource) queryEvents(ctx context.Context, q *queryModel, query backe
 	return &response, nil
 }
 
+// queryAPLDecoded runs an APL query and streams the response into typed
+// frame fields as it arrives, skipping the rows beyond the datasource's row
+// limit. It also returns the size of the response body.
+func (d *Datasource) queryAPLDecoded(ctx context.Context, reqBody axiomapi.APLQueryRequest) (aplDecodedResponse, int64, error) {
+	decoder := newAPLStreamDecoder(ctx, d.maxRows)
+	var body *countingReader
+	traceID, err := d.api.QueryAPLStream(ctx, reqBody, func(r io.Reader) error {
+		body = &countingReader{r: r}
+		return decoder.Decode(body)
+	})
+	if err != nil {
+		return aplDecodedResponse{}, 0, err
+	}
+
+	result := decoder.Result()
+	result.TraceID = traceID
+	return result, body.n, nil
+}
+
 func shouldPrependLogsVolumeFrame(q *queryModel, frames []*data.Frame) bool {
 	if !q.IncludeLogsVolumeFrame || len(frames) != 1 {
 		return false
@@ -237,13 +461,15 @@ func (d *Datasource) queryMetrics(ctx context.Context, q *queryModel, refID stri
 		StartTime:  startTime,
 		EndTime:    endTime,
 		ChartWidth: chartWidth,
+		Timeout:    q.timeout,
 	}
 
-	res, err := d.api.QueryMetrics(ctx, reqBody)
+	res, runStats, err := d.queryMetricsCached(ctx, q, reqBody)
 	if err != nil {
 		return nil, err
 	}
 
+	_, span := startSpan(ctx, "axiom.buildFrames", axiomapi.AttributeQueryKind.String("mpl"))
 	var response backend.DataResponse
 	frameBuilder := newMetricsFrameBuilder(res.Metadata, refID)
 
@@ -257,6 +483,11 @@ func (d *Datasource) queryMetrics(ctx context.Context, q *queryModel, refID stri
 		applyAxiomTraceID(tableFrame, res.TraceID)
 		response.Frames = append(response.Frames, tableFrame)
 	}
+	for _, frame := range response.Frames {
+		frame.Meta.ExecutedQueryString = *q.Query
+	}
+	applyQueryRunStats(response.Frames, runStats)
+	endFramesSpan(span, response.Frames, nil)
 
 	// extract the data from the response
 	return &response, nil
@@ -267,17 +498,56 @@ func (d *Datasource) queryMetrics(ctx context.Context, q *queryModel, refID stri
 // datasource configuration page which allows users to verify that
 // a datasource is working as expected.
 func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	// Personal access tokens can belong to several organizations, so Axiom
+	// cannot tell which one to query without the org ID header.
+	missingOrgID := d.tokenType == config.TokenTypePersonal && d.orgID == ""
+	edges := d.api.CheckEdges(ctx)
+	details, _ := json.Marshal(map[string]any{"tokenType": d.tokenType, "orgIdConfigured": d.orgID != "", "edges": edges})
+
 	err := d.api.ValidateCredentials(ctx)
 	if err != nil {
+		message := fmt.Sprintf("Failed to validate configuration: %s", err.Error())
+		if missingOrgID {
+			message += " (" + missingOrgIDHint + ")"
+		}
 		return &backend.CheckHealthResult{
 			Status:      backend.HealthStatusError,
-			Message:     fmt.Sprintf("Failed to validate configuration: %s", err.Error()),
+			Message:     message,
 			JSONDetails: []byte(`{"error": "` + err.Error() + `"}`),
 		}, nil
 	}
 
+	var warnings []string
+	if missingOrgID {
+		warnings = append(warnings, missingOrgIDHint)
+	}
+	if unreachable := unreachableEdges(edges); len(unreachable) > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d of %d edges are unreachable: %s", len(unreachable), len(edges), strings.Join(unreachable, ", ")))
+	}
+
+	if len(warnings) > 0 {
+		return &backend.CheckHealthResult{
+			Status:      backend.HealthStatusOk,
+			Message:     "Configuration is valid, but " + strings.Join(warnings, "; "),
+			JSONDetails: details,
+		}, nil
+	}
+
 	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: "Configuration is valid and ready to use",
+		Status:      backend.HealthStatusOk,
+		Message:     "Configuration is valid and ready to use",
+		JSONDetails: details,
 	}, nil
 }
+
+func unreachableEdges(edges []axiomapi.EdgeStatus) []string {
+	var urls []string
+	for _, edge := range edges {
+		if !edge.Healthy {
+			urls = append(urls, edge.URL)
+		}
+	}
+	return urls
+}
+
+const missingOrgIDHint = "personal access tokens need an organization ID; set it in the datasource settings or use an API token"
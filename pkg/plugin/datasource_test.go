@@ -1,11 +1,16 @@
 package plugin
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,8 +18,15 @@ import (
 	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
 	"github.com/axiomhq/axiom-grafana/pkg/config"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
@@ -77,6 +89,158 @@ func TestQueryDataSkipsEmptyQueries(t *testing.T) {
 	}
 }
 
+func TestQueryDataMapsAxiomErrorsToGrafanaStatus(t *testing.T) {
+	tests := []struct {
+		upstreamStatus int
+		status         backend.Status
+	}{
+		{upstreamStatus: http.StatusBadRequest, status: backend.StatusBadRequest},
+		{upstreamStatus: http.StatusUnauthorized, status: backend.StatusUnauthorized},
+		{upstreamStatus: http.StatusForbidden, status: backend.StatusForbidden},
+		{upstreamStatus: http.StatusNotFound, status: backend.StatusNotFound},
+		{upstreamStatus: http.StatusUnprocessableEntity, status: backend.StatusValidationFailed},
+		{upstreamStatus: http.StatusTooManyRequests, status: backend.StatusTooManyRequests},
+		{upstreamStatus: http.StatusInternalServerError, status: backend.StatusBadGateway},
+		{upstreamStatus: http.StatusServiceUnavailable, status: backend.StatusBadGateway},
+		{upstreamStatus: http.StatusGatewayTimeout, status: backend.StatusTimeout},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(http.StatusText(test.upstreamStatus), func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("X-Axiom-Trace-Id", "trace-123")
+				w.WriteHeader(test.upstreamStatus)
+				_, err := w.Write([]byte(`{"code":"query_failed","message":"something went wrong"}`))
+				require.NoError(t, err)
+			}))
+			defer upstream.Close()
+
+			ds := Datasource{
+				api: newTestAxiomClient(t, upstream.URL, upstream.URL),
+			}
+
+			resp, err := ds.QueryData(
+				context.Background(),
+				&backend.QueryDataRequest{
+					Queries: []backend.DataQuery{
+						{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs']"}`)},
+					},
+				},
+			)
+			require.NoError(t, err)
+
+			queryResp := resp.Responses["A"]
+			require.Error(t, queryResp.Error)
+			require.Equal(t, test.status, queryResp.Status)
+			require.Equal(t, backend.ErrorSourceDownstream, queryResp.ErrorSource)
+			require.Contains(t, queryResp.Error.Error(), "something went wrong")
+			require.Contains(t, queryResp.Error.Error(), "trace-123")
+		})
+	}
+}
+
+func TestQueryDataReportsFrameBuildFailuresAsPluginErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"format":"tabular","tables":[]}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{
+		api: newTestAxiomClient(t, upstream.URL, upstream.URL),
+	}
+
+	resp, err := ds.QueryData(
+		context.Background(),
+		&backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs']"}`)},
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	queryResp := resp.Responses["A"]
+	require.Error(t, queryResp.Error)
+	require.Equal(t, backend.StatusInternal, queryResp.Status)
+	require.Equal(t, backend.ErrorSourcePlugin, queryResp.ErrorSource)
+}
+
+func TestQueryDataReturnsAPLDiagnosticsForCompileErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Axiom-Trace-Id", "trace-123")
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(`{"code":"apl_parse_error","message":"line: 2, col: 9: syntax error: unexpected token 'whre'\nline: 3, col: 1: expected tabular expression"}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{
+		api: newTestAxiomClient(t, upstream.URL, upstream.URL),
+	}
+
+	resp, err := ds.QueryData(
+		context.Background(),
+		&backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs']\n| whre status == 500"}`)},
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	queryResp := resp.Responses["A"]
+	require.Error(t, queryResp.Error)
+	require.Equal(t, backend.StatusBadRequest, queryResp.Status)
+	require.Len(t, queryResp.Frames, 1)
+
+	meta := queryResp.Frames[0].Meta
+	require.NotNil(t, meta)
+	require.Equal(t, "['logs']\n| whre status == 500", meta.ExecutedQueryString)
+	require.Len(t, meta.Notices, 3)
+	require.Equal(t, data.NoticeSeverityError, meta.Notices[0].Severity)
+	require.Equal(t, "line 2, column 9: syntax error: unexpected token 'whre'", meta.Notices[0].Text)
+	require.Equal(t, "line 3, column 1: expected tabular expression", meta.Notices[1].Text)
+	require.Equal(t, "Axiom trace ID: trace-123", meta.Notices[2].Text)
+
+	custom, ok := meta.Custom.(map[string]any)
+	require.True(t, ok)
+	require.Equal(t, []aplDiagnostic{
+		{Severity: "error", Line: 2, Column: 9, Message: "syntax error: unexpected token 'whre'", Code: "apl_parse_error"},
+		{Severity: "error", Line: 3, Column: 1, Message: "expected tabular expression", Code: "apl_parse_error"},
+	}, custom["aplDiagnostics"])
+}
+
+func TestQueryDataOmitsDiagnosticsFrameForUnpositionedErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(`{"message":"dataset not found"}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{
+		api: newTestAxiomClient(t, upstream.URL, upstream.URL),
+	}
+
+	resp, err := ds.QueryData(
+		context.Background(),
+		&backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['missing']"}`)},
+			},
+		},
+	)
+	require.NoError(t, err)
+	require.Error(t, resp.Responses["A"].Error)
+	require.Empty(t, resp.Responses["A"].Frames)
+}
+
 func TestResourceHandlerFetchesEscapedMetricAutocompleteValues(t *testing.T) {
 	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -428,8 +592,35 @@ func callResource(t *testing.T, handler backend.CallResourceHandler, path string
 		context.Background(),
 		&backend.CallResourceRequest{
 			Method: http.MethodGet,
+			// Grafana sends the query string in URL only.
+			Path: strings.SplitN(path, "?", 2)[0],
+			URL:  path,
+		},
+		backend.CallResourceResponseSenderFunc(func(r *backend.CallResourceResponse) error {
+			resp = r
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	return resp
+}
+
+func postResource(t *testing.T, handler backend.CallResourceHandler, path string, body any) *backend.CallResourceResponse {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	var resp *backend.CallResourceResponse
+	err = handler.CallResource(
+		context.Background(),
+		&backend.CallResourceRequest{
+			Method: http.MethodPost,
 			Path:   path,
 			URL:    path,
+			Body:   payload,
 		},
 		backend.CallResourceResponseSenderFunc(func(r *backend.CallResourceResponse) error {
 			resp = r
@@ -1166,121 +1357,1218 @@ func TestBuildFrameAddsAxiomStatusToFrameMetadata(t *testing.T) {
 	require.True(t, ok)
 	require.Same(t, status, custom["axiomStatus"])
 	require.Equal(t, "trace-123", custom["axiomTraceId"])
+	require.NotContains(t, custom, "aplDiagnostics")
 }
 
-func TestFieldsMatchTrace(t *testing.T) {
+func TestBuildFrameAddsPositionedStatusMessagesAsDiagnostics(t *testing.T) {
+	table := query.Table{
+		Fields:  []query.Field{{Name: "count_", Type: "integer"}},
+		Columns: []query.Column{{float64(10)}},
+	}
+	status := &axiomapi.APLQueryStatus{
+		Messages: []query.Message{
+			{Priority: "warn", Count: 1, Code: "apl_implicitendtimeofnowapplied_1", Msg: "line: 1, col: 20: implicit end time of 'now' applied"},
+			{Priority: "info", Count: 1, Msg: "no position"},
+		},
+	}
+
+	got, err := buildAPLFrame(context.Background(), &table, aplFrameOptions{Status: status})
+	require.NoError(t, err)
+
+	custom, ok := got.Meta.Custom.(map[string]any)
+	require.True(t, ok)
+	require.Equal(t, []aplDiagnostic{
+		{Severity: "warning", Line: 1, Column: 20, Message: "implicit end time of 'now' applied", Code: "apl_implicitendtimeofnowapplied_1"},
+	}, custom["aplDiagnostics"])
+}
+
+func TestAPLStreamDecoderMatchesBufferedFrames(t *testing.T) {
 	tests := []struct {
-		name   string
-		fields []query.Field
-		want   bool
+		name     string
+		totals   bool
+		response string
 	}{
 		{
-			name: "grafana trace fields",
-			fields: []query.Field{
-				{Name: "traceID"},
-				{Name: "spanID"},
-				{Name: "operationName"},
-				{Name: "serviceName"},
-				{Name: "startTime"},
-				{Name: "duration"},
-			},
-			want: true,
+			name: "time series with totals",
+			response: `{
+				"format":"tabular",
+				"status":{"elapsedTime":10,"rowsMatched":3},
+				"tables":[
+					{"name":"0","fields":[{"name":"_time","type":"datetime"},{"name":"service","type":"string"},{"name":"count_","type":"integer","agg":{"name":"count"}}],"range":{"field":"_time","start":"2026-06-11T02:00:00Z","end":"2026-06-11T03:00:00Z"},"columns":[["2026-06-11T02:00:00Z","2026-06-11T02:00:00Z","2026-06-11T02:05:00Z"],["api","web","api"],[1,2,3]]},
+					{"name":"_totals","fields":[{"name":"service","type":"string"},{"name":"count_","type":"integer"}],"columns":[["api","web"],[4,2]]}
+				],
+				"datasetNames":["logs"],
+				"fieldsMetaMap":{"logs":[{"name":"count_","type":"integer","unit":"short"}]}
+			}`,
 		},
 		{
-			name: "apl otel aliases",
-			fields: []query.Field{
-				{Name: "trace_id"},
-				{Name: "span_id"},
-				{Name: "name"},
-				{Name: "service.name"},
-				{Name: "_time"},
-				{Name: "duration"},
-			},
-			want: true,
+			name:   "generic table with unknown and array fields",
+			totals: true,
+			response: `{
+				"format":"tabular",
+				"tables":[{"fields":[{"name":"host","type":"string"},{"name":"tags","type":"array"},{"name":"extra","type":"unknown"},{"name":"ok","type":"bool"},{"name":"missing","type":"float"}],"columns":[["a",null],[["x","y"],null],[1.5,null],[true,false]]}]
+			}`,
 		},
 		{
-			name: "missing duration",
-			fields: []query.Field{
-				{Name: "trace_id"},
-				{Name: "span_id"},
-				{Name: "name"},
-				{Name: "service.name"},
-				{Name: "_time"},
-			},
-			want: false,
+			name:   "logs table",
+			totals: true,
+			response: `{
+				"format":"tabular",
+				"tables":[{"fields":[{"name":"_time","type":"datetime"},{"name":"message","type":"string"},{"name":"attributes","type":"unknown"},{"name":"host","type":"string"},{"name":"status","type":"integer"},{"name":"seen","type":"datetime"}],"columns":[["2026-06-11T02:00:00Z",null],["hello",null],[{"k":"v"},null],["a",null],[200,null],["2026-06-11T01:00:00.5Z",null]]}]
+			}`,
 		},
 		{
-			name: "parent and service tags are not required",
-			fields: []query.Field{
-				{Name: "trace_id"},
-				{Name: "span_id"},
-				{Name: "name"},
-				{Name: "service.name"},
-				{Name: "_time"},
-				{Name: "duration"},
-				{Name: "parent_span_id"},
-			},
-			want: true,
+			name:   "trace table",
+			totals: true,
+			response: `{
+				"format":"tabular",
+				"tables":[{"fields":[{"name":"_time","type":"datetime"},{"name":"trace_id","type":"string"},{"name":"span_id","type":"string"},{"name":"parent_span_id","type":"string"},{"name":"name","type":"string"},{"name":"service.name","type":"string"},{"name":"duration","type":"timespan"},{"name":"attributes","type":"unknown"},{"name":"events","type":"array"},{"name":"status","type":"integer"}],"columns":[["2026-06-11T02:00:00Z"],["t1"],["s1"],[null],["GET /"],["api"],["1.5ms"],[{"http.method":"GET"}],[[{"name":"retry","timestamp":"2026-06-11T02:00:00.001Z"}]],[200]]}]
+			}`,
 		},
 	}
 
 	for _, test := range tests {
 		test := test
 		t.Run(test.name, func(t *testing.T) {
-			require.Equal(t, test.want, fieldsMatchTrace(context.Background(), test.fields))
+			var buffered axiomapi.APLQueryResponse
+			require.NoError(t, json.Unmarshal([]byte(test.response), &buffered))
+			builder := newAPLResponseFrameBuilder(test.totals, true)
+			opts := aplFrameOptions{FieldMetaByName: fieldMetaByNameForResponse(buffered), Status: buffered.Status}
+			want, err := builder.BuildFrames(context.Background(), buffered, opts)
+			require.NoError(t, err)
+
+			decoder := newAPLStreamDecoder(context.Background(), 0)
+			require.NoError(t, decoder.Decode(strings.NewReader(test.response)))
+			streamed := decoder.Result()
+			require.Equal(t, buffered.Format, streamed.Format)
+			require.Equal(t, buffered.DatasetNames, streamed.DatasetNames)
+			require.Len(t, streamed.Tables, len(buffered.Tables))
+			for i := range buffered.Tables {
+				require.Equal(t, buffered.Tables[i].Name, streamed.Tables[i].Name)
+				require.Equal(t, buffered.Tables[i].Fields, streamed.Tables[i].Fields)
+				require.Equal(t, buffered.Tables[i].Range, streamed.Tables[i].Range)
+			}
+
+			opts = aplFrameOptions{FieldMetaByName: fieldMetaByNameForResponse(streamed.APLQueryResponse), Status: streamed.Status}
+			got, err := builder.BuildDecodedFrames(context.Background(), streamed, opts)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
 		})
 	}
 }
 
-func TestBuildFrameSetsTraceMetadata(t *testing.T) {
-	table := query.Table{
-		Fields: []query.Field{
-			{Name: "trace_id", Type: "string"},
-			{Name: "span_id", Type: "string"},
-			{Name: "name", Type: "string"},
-			{Name: "service.name", Type: "string"},
-			{Name: "_time", Type: "datetime"},
-			{Name: "duration", Type: "timespan"},
-			{Name: "attributes.custom", Type: "unknown"},
-			{Name: "http.method", Type: "string"},
-		},
-		Columns: []query.Column{
-			{"trace-1"},
-			{"span-1"},
-			{"GET /"},
-			{"api"},
-			{"2026-06-11T02:19:39Z"},
-			{"666.387µs"},
-			{map[string]any{"normalizedDatasetName": "axiom-dataset"}},
-			{"POST"},
+func TestAPLStreamDecoderTypesScalarLogColumns(t *testing.T) {
+	decoder := newAPLStreamDecoder(context.Background(), 0)
+	require.NoError(t, decoder.Decode(strings.NewReader(`{"tables":[
+		{"fields":[{"name":"_time","type":"datetime"},{"name":"message","type":"string"},{"name":"attributes","type":"unknown"}],"columns":[["2026-06-11T02:00:00Z"],["hello"],[{"k":"v"}]]},
+		{"fields":[{"name":"count_","type":"integer"}],"columns":[[1]]}
+	]}`)))
+
+	result := decoder.Result()
+	require.Len(t, result.Tables, 2)
+
+	// Scalar log columns are typed; objects keep their generic values for the
+	// labels.
+	logFields := result.tableFields(0)
+	require.Len(t, logFields, 3)
+	require.Equal(t, data.FieldTypeNullableTime, logFields[0].Type())
+	require.Equal(t, data.FieldTypeNullableString, logFields[1].Type())
+	require.Nil(t, logFields[2])
+	require.Equal(t, []query.Column{nil, nil, {map[string]any{"k": "v"}}}, result.Tables[0].Columns)
+
+	require.Nil(t, result.Tables[1].Columns)
+	require.Len(t, result.tableFields(1), 1)
+}
+
+func TestQueryDataAbortsResponsesOverMaximumSize(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"format":"tabular","tables":[{"fields":[{"name":"message","type":"string"}],"columns":[["` + strings.Repeat("x", 4096) + `"]]}]}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	timeouts := httpclient.DefaultTimeoutOptions
+	client, err := axiomapi.NewClient(
+		httpclient.Options{Timeouts: &timeouts},
+		&config.PluginConfig{
+			APIHost:          upstream.URL,
+			EdgeURL:          upstream.URL,
+			MaxResponseBytes: 1024,
 		},
-	}
+	)
+	require.NoError(t, err)
+	ds := Datasource{api: client}
 
-	got, err := buildAPLFrame(context.Background(), &table)
+	resp, err := ds.QueryData(
+		context.Background(),
+		&backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs']"}`)},
+			},
+		},
+	)
 	require.NoError(t, err)
-	require.Equal(t, "Trace", got.Name)
-	require.NotNil(t, got.Meta)
-	require.EqualValues(t, data.VisTypeTrace, got.Meta.PreferredVisualization)
-	require.Len(t, got.Fields, 10)
-	require.Equal(t, "startTime", got.Fields[6].Name)
-	require.Equal(t, data.FieldTypeNullableFloat64, got.Fields[6].Type())
-	startTime, ok := got.Fields[6].At(0).(*float64)
-	require.True(t, ok)
-	require.InDelta(t, float64(1781144379000), *startTime, 0.001)
 
-	require.Equal(t, "duration", got.Fields[7].Name)
-	require.Equal(t, data.FieldTypeNullableFloat64, got.Fields[7].Type())
-	duration, ok := got.Fields[7].At(0).(*float64)
-	require.True(t, ok)
-	require.InDelta(t, 0.666387, *duration, 0.000001)
+	queryResp := resp.Responses["A"]
+	require.ErrorContains(t, queryResp.Error, "response exceeds the maximum size of 1024 bytes")
+	require.Equal(t, backend.StatusBadRequest, queryResp.Status)
+	require.Empty(t, queryResp.Frames)
+}
 
-	serviceTags, ok := got.Fields[5].At(0).(*json.RawMessage)
-	require.True(t, ok)
-	require.JSONEq(t, `[{"key":"service.name","value":"api"}]`, string(*serviceTags))
+func TestQueryDataTruncatesRowsAndWarns(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{
+			"format": "tabular",
+			"status": {"rowsMatched": 1000},
+			"tables": [{
+				"name": "0",
+				"fields": [{"name": "message", "type": "string"}],
+				"columns": [["a", "b", "c", "d", "e"]]
+			}]
+		}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
 
-	tags, ok := got.Fields[9].At(0).(*json.RawMessage)
-	require.True(t, ok)
-	require.JSONEq(t, `[{"key":"attributes.custom.normalizedDatasetName","value":"axiom-dataset"},{"key":"http.method","value":"POST"}]`, string(*tags))
+	tests := []struct {
+		name            string
+		datasourceLimit int
+		query           string
+		wantRows        int
+		wantNotices     []string
+	}{
+		{
+			name:        "no limit",
+			query:       `{"kind":"apl","query":"['logs']"}`,
+			wantRows:    5,
+			wantNotices: []string{"Axiom matched 1000 rows but returned 5. Lower the time range or add a limit to the query to see the rest."},
+		},
+		{
+			name:            "datasource limit",
+			datasourceLimit: 3,
+			query:           `{"kind":"apl","query":"['logs']"}`,
+			wantRows:        3,
+			wantNotices: []string{
+				"Showing the first 3 of 5 rows. Lower the time range or add a limit to the query to see the rest.",
+				"Axiom matched 1000 rows but returned 5. Lower the time range or add a limit to the query to see the rest.",
+			},
+		},
+		{
+			name:            "query cannot raise datasource limit",
+			datasourceLimit: 3,
+			query:           `{"kind":"apl","query":"['logs']","maxRows":4}`,
+			wantRows:        3,
+		},
+		{
+			name:            "query lowers datasource limit",
+			datasourceLimit: 3,
+			query:           `{"kind":"apl","query":"['logs']","maxRows":2}`,
+			wantRows:        2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := Datasource{
+				api:     newTestAxiomClient(t, upstream.URL, upstream.URL),
+				maxRows: tt.datasourceLimit,
+			}
+
+			resp, err := ds.QueryData(
+				context.Background(),
+				&backend.QueryDataRequest{
+					Queries: []backend.DataQuery{
+						{RefID: "A", JSON: json.RawMessage(tt.query)},
+					},
+				},
+			)
+			require.NoError(t, err)
+
+			queryResp := resp.Responses["A"]
+			require.NoError(t, queryResp.Error)
+			require.Len(t, queryResp.Frames, 1)
+			require.Equal(t, tt.wantRows, queryResp.Frames[0].Rows())
+
+			if tt.wantNotices == nil {
+				return
+			}
+			var notices []string
+			for _, notice := range queryResp.Frames[0].Meta.Notices {
+				if notice.Severity == data.NoticeSeverityWarning {
+					notices = append(notices, notice.Text)
+				}
+			}
+			require.Equal(t, tt.wantNotices, notices)
+		})
+	}
+}
+
+func TestAPLStreamDecoderSkipsRowsBeyondLimit(t *testing.T) {
+	decoder := newAPLStreamDecoder(context.Background(), 2)
+	require.NoError(t, decoder.Decode(strings.NewReader(`{"tables":[
+		{"fields":[{"name":"status","type":"integer"},{"name":"message","type":"string"}],"columns":[[1,2,3,4,5],["a","b","c","d","e"]]},
+		{"fields":[{"name":"_time","type":"datetime"},{"name":"message","type":"string"},{"name":"attributes","type":"unknown"}],"columns":[["2026-06-11T02:00:00Z","2026-06-11T02:00:01Z","2026-06-11T02:00:02Z"],["x","y","z"],[{"k":1},{"k":2},{"k":3}]]},
+		{"fields":[{"name":"service","type":"string"},{"name":"count_","type":"integer","agg":{"name":"count"}}],"groups":[{"name":"service"}],"columns":[["api","db","web"],[1,2,3]]}
+	]}`)))
+
+	result := decoder.Result()
+	require.Equal(t, 2, result.tableFields(0)[0].Len())
+	require.Equal(t, 2, result.tableFields(0)[1].Len())
+	require.Equal(t, 3, result.dropped(0))
+
+	require.Equal(t, 2, result.tableFields(1)[0].Len())
+	require.Equal(t, query.Column{map[string]any{"k": float64(1)}, map[string]any{"k": float64(2)}}, result.Tables[1].Columns[2])
+	require.Equal(t, 1, result.dropped(1))
+
+	// Groups are not events, so aggregated tables are read whole.
+	require.Equal(t, 3, result.tableFields(2)[0].Len())
+	require.Zero(t, result.dropped(2))
+}
+
+func TestQueryDataDoesNotLimitAggregatedRows(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"format": "tabular",
+			"status": {"rowsMatched": 1000},
+			"tables": [{
+				"name": "0",
+				"fields": [{"name": "service", "type": "string"}, {"name": "count_", "type": "integer", "agg": {"name": "count"}}],
+				"groups": [{"name": "service"}],
+				"columns": [["api", "db", "web"], [1, 2, 3]]
+			}]
+		}`))
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL), maxRows: 2}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs'] | summarize count() by service","maxRows":1}`)}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	require.Len(t, resp.Responses["A"].Frames, 1)
+
+	frame := resp.Responses["A"].Frames[0]
+	require.Equal(t, 3, frame.Rows())
+	if frame.Meta != nil {
+		for _, notice := range frame.Meta.Notices {
+			require.NotEqual(t, data.NoticeSeverityWarning, notice.Severity, notice.Text)
+		}
+	}
+}
+
+func TestApplyAPLRowLimitIgnoresRowsMatchedForAggregations(t *testing.T) {
+	frame := data.NewFrame("", data.NewField("count_", nil, []float64{42}))
+	result := axiomapi.APLQueryResponse{
+		Status: &axiomapi.APLQueryStatus{RowsMatched: 42},
+		Tables: []query.Table{{
+			Fields: []query.Field{{Name: "count_", Type: "integer", Aggregation: &query.Aggregation{Op: query.OpCount}}},
+		}},
+	}
+
+	applyAPLRowLimit([]*data.Frame{frame}, aplDecodedResponse{APLQueryResponse: result}, 0)
+
+	require.Nil(t, frame.Meta)
+}
+
+func TestQueryDataServesRepeatedQueriesFromResultCache(t *testing.T) {
+	var requests int
+	var lastRequest axiomapi.APLQueryRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&lastRequest))
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{
+			"format": "tabular",
+			"tables": [{
+				"name": "0",
+				"fields": [{"name": "message", "type": "string"}],
+				"columns": [["a", "b", "c"]]
+			}]
+		}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	now := time.Date(2026, 6, 11, 12, 0, 0, 0, time.UTC)
+	ds := Datasource{
+		api:     newTestAxiomClient(t, upstream.URL, upstream.URL),
+		maxRows: 2,
+		cache: newResultCache(&config.PluginConfig{
+			CacheTTL:          time.Minute,
+			CacheMaxBytes:     1 << 20,
+			CacheNowTolerance: time.Minute,
+		}),
+	}
+	ds.cache.now = func() time.Time { return now }
+
+	query := func(jsonQuery string, to time.Time) backend.DataResponse {
+		t.Helper()
+		resp, err := ds.QueryData(
+			context.Background(),
+			&backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{
+					RefID:     "A",
+					JSON:      json.RawMessage(jsonQuery),
+					Interval:  time.Minute,
+					TimeRange: backend.TimeRange{From: to.Add(-time.Hour), To: to},
+				}},
+			},
+		)
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+		return resp.Responses["A"]
+	}
+	cacheStats := func(resp backend.DataResponse) resultCacheStats {
+		t.Helper()
+		custom, ok := resp.Frames[0].Meta.Custom.(map[string]any)
+		require.True(t, ok)
+		return custom["resultCache"].(resultCacheStats)
+	}
+
+	past := now.Add(-time.Hour)
+	first := query(`{"kind":"apl","query":"['logs']"}`, past.Add(10*time.Second))
+	require.Equal(t, resultCacheStats{Misses: 1}, cacheStats(first))
+
+	// Same interval-aligned range and the same query modulo whitespace and
+	// comments.
+	second := query(`{"kind":"apl","query":"  ['logs']\n// tail\n"}`, past.Add(20*time.Second))
+	require.Equal(t, resultCacheStats{Hit: true, Hits: 1, Misses: 1}, cacheStats(second))
+	require.Equal(t, 1, requests)
+
+	// Cached fields are copied, so truncating one response leaves the cached
+	// result intact for the next.
+	require.Equal(t, 2, second.Frames[0].Rows())
+	third := query(`{"kind":"apl","query":"['logs']","maxRows":1}`, past.Add(30*time.Second))
+	require.Equal(t, 1, third.Frames[0].Rows())
+	fourth := query(`{"kind":"apl","query":"['logs']"}`, past.Add(40*time.Second))
+	require.Equal(t, 2, fourth.Frames[0].Rows())
+	require.Equal(t, 1, requests)
+
+	query(`{"kind":"apl","query":"['logs']","app":"explore"}`, past.Add(10*time.Second))
+	require.Equal(t, 2, requests)
+
+	recent := query(`{"kind":"apl","query":"['logs']"}`, now.Add(-10*time.Second))
+	require.Equal(t, 3, requests)
+	custom, _ := recent.Frames[0].Meta.Custom.(map[string]any)
+	require.NotContains(t, custom, "resultCache")
+
+	now = now.Add(2 * time.Minute)
+	query(`{"kind":"apl","query":"['logs']"}`, past.Add(10*time.Second))
+	require.Equal(t, 4, requests, "expired entries must be fetched again")
+
+	// Only the cache key is aligned; Axiom and the macros see the range the
+	// panel asked for.
+	query(`{"kind":"apl","query":"['logs'] | where _time <= $__timeTo"}`, past.Add(10*time.Second))
+	require.Equal(t, 5, requests)
+	require.Equal(t, past.Add(10*time.Second), lastRequest.EndTime.UTC())
+	require.Equal(t, "['logs'] | where _time <= datetime(2026-06-11T11:00:10Z)", *lastRequest.APL)
+	query(`{"kind":"apl","query":"['logs'] | where _time <= $__timeTo"}`, past.Add(20*time.Second))
+	require.Equal(t, 5, requests)
+}
+
+func TestResultCacheEvictsLeastRecentlyUsedEntriesOverBudget(t *testing.T) {
+	cache := newResultCache(&config.PluginConfig{CacheTTL: time.Minute, CacheMaxBytes: 100})
+
+	cache.set("a", "a", 40)
+	cache.set("b", "b", 40)
+	_, _, ok := cache.get("a")
+	require.True(t, ok)
+
+	cache.set("c", "c", 40)
+	_, _, ok = cache.get("b")
+	require.False(t, ok, "least recently used entry must be evicted")
+	_, _, ok = cache.get("a")
+	require.True(t, ok)
+
+	cache.set("huge", "huge", 101)
+	_, _, ok = cache.get("huge")
+	require.False(t, ok, "entries larger than the budget are not cached")
+}
+
+func TestQueryDataCoalescesConcurrentIdenticalQueries(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{
+			"format": "tabular",
+			"tables": [{
+				"name": "0",
+				"fields": [{"name": "message", "type": "string"}],
+				"columns": [["a", "b", "c"]]
+			}]
+		}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	timeRange := backend.TimeRange{
+		From: time.Date(2026, 6, 11, 1, 0, 0, 0, time.UTC),
+		To:   time.Date(2026, 6, 11, 2, 0, 0, 0, time.UTC),
+	}
+
+	const viewers = 10
+	responses := make([]backend.DataResponse, viewers)
+	var wg sync.WaitGroup
+	for i := range viewers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each viewer asks for a different row limit, so every waiter
+			// must get its own copy of the shared result.
+			query := fmt.Sprintf(`{"kind":"apl","query":"['logs']","maxRows":%d}`, i%3+1)
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{RefID: "A", JSON: json.RawMessage(query), TimeRange: timeRange}},
+			})
+			require.NoError(t, err)
+			responses[i] = resp.Responses["A"]
+		}()
+	}
+
+	waitForInflightWaiters(t, &ds.inflight, viewers)
+	close(release)
+	wg.Wait()
+
+	require.EqualValues(t, 1, requests.Load())
+	for i, resp := range responses {
+		require.NoError(t, resp.Error)
+		require.Len(t, resp.Frames, 1)
+		require.Equal(t, i%3+1, resp.Frames[0].Rows())
+	}
+}
+
+func TestQueryDataCoalescesQueriesWhoseRangesDifferByMilliseconds(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"format":"tabular","tables":[{"name":"0","fields":[{"name":"message","type":"string"}],"columns":[["a"]]}]}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	// No result cache: viewers of a "now-6h → now" dashboard still share the
+	// call although each of them resolved now a few milliseconds apart.
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	now := time.Date(2026, 6, 11, 2, 0, 10, 0, time.UTC)
+
+	const viewers = 10
+	var wg sync.WaitGroup
+	for i := range viewers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			to := now.Add(time.Duration(i) * time.Millisecond)
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{
+					RefID:     "A",
+					JSON:      json.RawMessage(`{"kind":"apl","query":"['logs']"}`),
+					Interval:  time.Minute,
+					TimeRange: backend.TimeRange{From: to.Add(-6 * time.Hour), To: to},
+				}},
+			})
+			require.NoError(t, err)
+			require.NoError(t, resp.Responses["A"].Error)
+		}()
+	}
+
+	waitForInflightWaiters(t, &ds.inflight, viewers)
+	close(release)
+	wg.Wait()
+
+	require.EqualValues(t, 1, requests.Load())
+}
+
+func TestQueryDataCancellingOneCoalescedWaiterKeepsOthersRunning(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	upstreamCanceled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices a client going away once the body is read.
+		_, err := io.Copy(io.Discard, r.Body)
+		require.NoError(t, err)
+
+		// The first request is answered once released; later ones hang
+		// until Grafana gives up on them.
+		if requests.Add(1) == 1 {
+			<-release
+		} else {
+			<-r.Context().Done()
+			close(upstreamCanceled)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write([]byte(`{"series":[{"metric":"cpu","start":0,"resolution":60,"data":[1,2]}]}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	request := &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			RefID: "A",
+			JSON:  json.RawMessage(`{"kind":"mpl","query":"cpu"}`),
+			TimeRange: backend.TimeRange{
+				From: time.Date(2026, 6, 11, 1, 0, 0, 0, time.UTC),
+				To:   time.Date(2026, 6, 11, 2, 0, 0, 0, time.UTC),
+			},
+		}},
+	}
+	run := func(ctx context.Context) <-chan backend.DataResponse {
+		out := make(chan backend.DataResponse, 1)
+		go func() {
+			resp, err := ds.QueryData(ctx, request)
+			require.NoError(t, err)
+			out <- resp.Responses["A"]
+		}()
+		return out
+	}
+
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	canceled := run(canceledCtx)
+	kept := run(context.Background())
+	waitForInflightWaiters(t, &ds.inflight, 2)
+
+	cancel()
+	require.ErrorContains(t, (<-canceled).Error, context.Canceled.Error())
+
+	close(release)
+	resp := <-kept
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 1)
+	require.EqualValues(t, 1, requests.Load())
+
+	// Once the last waiter gives up, the upstream request is cancelled too.
+	lastCtx, cancelLast := context.WithCancel(context.Background())
+	last := run(lastCtx)
+	waitForInflightWaiters(t, &ds.inflight, 1)
+	cancelLast()
+	require.Error(t, (<-last).Error)
+	select {
+	case <-upstreamCanceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+}
+
+func waitForInflightWaiters(t *testing.T, group *inflightGroup, want int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		group.mu.Lock()
+		defer group.mu.Unlock()
+		waiters := 0
+		for _, call := range group.calls {
+			waiters += call.waiters
+		}
+		return waiters == want
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestQueryDataLimitsInflightQueriesAcrossRequests(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			seen := maxRunning.Load()
+			if current <= seen || maxRunning.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"series":[{"metric":"cpu","start":0,"resolution":60,"data":[1]}]}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{
+		api:     newTestAxiomClient(t, upstream.URL, upstream.URL),
+		limiter: newQueryLimiter(&config.PluginConfig{MaxInflightQueries: 2}),
+	}
+
+	var queries []backend.DataQuery
+	for i := range 6 {
+		queries = append(queries, backend.DataQuery{
+			RefID: fmt.Sprintf("Q%d", i),
+			JSON:  json.RawMessage(fmt.Sprintf(`{"kind":"mpl","query":"cpu-%d"}`, i)),
+		})
+	}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{Queries: queries})
+	require.NoError(t, err)
+
+	require.EqualValues(t, 2, maxRunning.Load())
+	for _, query := range queries {
+		queryResp := resp.Responses[query.RefID]
+		require.NoError(t, queryResp.Error)
+		require.Len(t, queryResp.Frames, 1)
+		stats := queryResp.Frames[0].Meta.Stats
+		require.NotEmpty(t, stats)
+		require.Equal(t, "Queue time", stats[len(stats)-1].DisplayName)
+		require.Equal(t, "ms", stats[len(stats)-1].Unit)
+	}
+}
+
+func TestQueryDataFailsFastWhenRateLimitWaitExceedsDeadline(t *testing.T) {
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"series":[]}`))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{
+		api:     newTestAxiomClient(t, upstream.URL, upstream.URL),
+		limiter: newQueryLimiter(&config.PluginConfig{QueryRateLimit: 0.01, QueryRateBurst: 1}),
+	}
+	query := func(ctx context.Context, refID string) backend.DataResponse {
+		resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{RefID: refID, JSON: json.RawMessage(`{"kind":"mpl","query":"` + refID + `"}`)}},
+		})
+		require.NoError(t, err)
+		return resp.Responses[refID]
+	}
+
+	require.NoError(t, query(context.Background(), "A").Error)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	limited := query(ctx, "B")
+	require.Less(t, time.Since(start), 500*time.Millisecond, "the wait cannot fit the deadline, so it must not start")
+	require.Equal(t, backend.StatusTimeout, limited.Status)
+	require.ErrorContains(t, limited.Error, "query rate limit")
+	require.EqualValues(t, 1, requests.Load())
+}
+
+func TestTokenBucketQueuesCallersAtConfiguredRate(t *testing.T) {
+	now := time.Date(2026, 6, 11, 0, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(2, 2, func() time.Time { return now })
+
+	require.Zero(t, bucket.reserve())
+	require.Zero(t, bucket.reserve())
+	require.Equal(t, 500*time.Millisecond, bucket.reserve())
+	require.Equal(t, time.Second, bucket.reserve())
+
+	bucket.cancel()
+	bucket.cancel()
+	now = now.Add(time.Second)
+	require.Zero(t, bucket.reserve())
+	require.Zero(t, bucket.reserve())
+	require.Equal(t, 500*time.Millisecond, bucket.reserve())
+}
+
+func TestQueryDataReportsPerQueryTimeouts(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.Copy(io.Discard, r.Body)
+		require.NoError(t, err)
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+
+	resp, err := ds.QueryData(
+		context.Background(),
+		&backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs']","timeout":"50ms"}`)},
+				{RefID: "B", JSON: json.RawMessage(`{"kind":"mpl","query":"cpu","timeout":"soon"}`)},
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	timedOut := resp.Responses["A"]
+	require.Equal(t, backend.StatusTimeout, timedOut.Status)
+	require.Equal(t, backend.ErrorSourceDownstream, timedOut.ErrorSource)
+	require.EqualError(t, timedOut.Error, "axiom error: query timed out after 50ms")
+
+	invalid := resp.Responses["B"]
+	require.Equal(t, backend.StatusBadRequest, invalid.Status)
+	require.ErrorContains(t, invalid.Error, `invalid query timeout "soon"`)
+}
+
+func TestQueryDataContinuesFromFrameCursor(t *testing.T) {
+	pages := map[string]string{
+		"":   `{"format":"tabular","status":{"minCursor":"c2","maxCursor":"c1"},"tables":[{"name":"0","fields":[{"name":"_time","type":"datetime"},{"name":"message","type":"string"}],"columns":[["2024-01-01T00:00:02Z","2024-01-01T00:00:01Z"],["newest","newer"]]}]}`,
+		"c2": `{"format":"tabular","status":{"minCursor":"c3","maxCursor":"c3"},"tables":[{"name":"0","fields":[{"name":"_time","type":"datetime"},{"name":"message","type":"string"}],"columns":[["2024-01-01T00:00:00Z"],["oldest"]]}]}`,
+	}
+	var cursors []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body axiomapi.APLQueryRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		cursors = append(cursors, body.Cursor)
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(pages[body.Cursor]))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	query := func(model string) backend.DataResponse {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{RefID: "A", JSON: json.RawMessage(model), TimeRange: backend.TimeRange{From: from, To: to}}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+		return resp.Responses["A"]
+	}
+
+	first := query(`{"kind":"apl","query":"['logs']"}`)
+	require.Len(t, first.Frames, 1)
+	require.Equal(t, data.FrameTypeLogLines, first.Frames[0].Meta.Type)
+	require.Equal(t, "c2", first.Frames[0].Meta.Custom.(map[string]any)["nextCursor"])
+
+	next := query(`{"kind":"apl","query":"['logs']","cursor":"c2"}`)
+	require.Len(t, next.Frames, 1)
+	require.Equal(t, 1, next.Frames[0].Rows())
+	require.Equal(t, "oldest", next.Frames[0].Fields[1].At(0))
+	require.Equal(t, "c3", next.Frames[0].Meta.Custom.(map[string]any)["nextCursor"])
+
+	require.Equal(t, []string{"", "c2"}, cursors)
+}
+
+func TestApplyNextCursorSkipsTruncatedAndAggregatedResults(t *testing.T) {
+	newFrame := func() *data.Frame {
+		return data.NewFrame("", data.NewField("message", nil, []string{"a"}))
+	}
+	raw := axiomapi.APLQueryResponse{Status: &axiomapi.APLQueryStatus{MinCursor: "c1"}}
+	aggregated := axiomapi.APLQueryResponse{
+		Status: &axiomapi.APLQueryStatus{MinCursor: "c1"},
+		Tables: []query.Table{{Fields: []query.Field{{Name: "count_", Aggregation: &query.Aggregation{Op: query.OpCount}}}}},
+	}
+
+	truncated := newFrame()
+	applyNextCursor([]*data.Frame{truncated}, raw, true)
+	require.Nil(t, truncated.Meta)
+
+	aggregate := newFrame()
+	applyNextCursor([]*data.Frame{aggregate}, aggregated, false)
+	require.Nil(t, aggregate.Meta)
+
+	paged := newFrame()
+	applyNextCursor([]*data.Frame{paged}, raw, false)
+	require.Equal(t, "c1", paged.Meta.Custom.(map[string]any)["nextCursor"])
+}
+
+// asyncQueryStandIn emulates Axiom's async APL job endpoints. Every poll
+// answers with the next of polls; the last one repeats.
+type asyncQueryStandIn struct {
+	t         *testing.T
+	polls     []string
+	pollCount atomic.Int32
+	cancelled atomic.Bool
+}
+
+func (s *asyncQueryStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, err := io.Copy(io.Discard, r.Body)
+	require.NoError(s.t, err)
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/query/_apl/async":
+		w.WriteHeader(http.StatusAccepted)
+		_, err = w.Write([]byte(`{"id":"job-1","state":"running"}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/query/_apl/async/job-1":
+		n := int(s.pollCount.Add(1)) - 1
+		_, err = w.Write([]byte(s.polls[min(n, len(s.polls)-1)]))
+	case r.Method == http.MethodDelete && r.URL.Path == "/v1/query/_apl/async/job-1":
+		s.cancelled.Store(true)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+	require.NoError(s.t, err)
+}
+
+func newAsyncTestDatasource(t *testing.T, standIn *asyncQueryStandIn) (*Datasource, *httptest.Server) {
+	t.Helper()
+
+	upstream := httptest.NewServer(standIn)
+	timeouts := httpclient.DefaultTimeoutOptions
+	client, err := axiomapi.NewClient(httpclient.Options{Timeouts: &timeouts}, &config.PluginConfig{
+		APIHost:           upstream.URL,
+		EdgeURL:           upstream.URL,
+		AsyncPollInterval: 5 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	return &Datasource{api: client}, upstream
+}
+
+func TestQueryDataPollsAsyncAPLQueriesUntilTheyFinish(t *testing.T) {
+	standIn := &asyncQueryStandIn{t: t, polls: []string{
+		`{"state":"running","result":{"format":"tabular","tables":[{"name":"0","fields":[{"name":"message","type":"string"}],"columns":[["a"]]}]}}`,
+		`{"state":"succeeded","result":{"format":"tabular","tables":[{"name":"0","fields":[{"name":"message","type":"string"}],"columns":[["a","b","c"]]}]}}`,
+	}}
+	ds, upstream := newAsyncTestDatasource(t, standIn)
+	defer upstream.Close()
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs']","async":true}`)},
+		},
+	})
+	require.NoError(t, err)
+
+	result := resp.Responses["A"]
+	require.NoError(t, result.Error)
+	require.Len(t, result.Frames, 1)
+	require.Equal(t, 3, result.Frames[0].Rows())
+	require.Equal(t, int32(2), standIn.pollCount.Load())
+	require.False(t, standIn.cancelled.Load())
+}
+
+func TestQueryDataReturnsPartialAsyncResultsWhenTheQueryTimesOut(t *testing.T) {
+	standIn := &asyncQueryStandIn{t: t, polls: []string{
+		`{"state":"running","result":{"format":"tabular","status":{"rowsMatched":2},"tables":[{"name":"0","fields":[{"name":"message","type":"string"}],"columns":[["a","b"]]}]}}`,
+	}}
+	ds, upstream := newAsyncTestDatasource(t, standIn)
+	defer upstream.Close()
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs']","async":true,"timeout":"100ms"}`)},
+		},
+	})
+	require.NoError(t, err)
+
+	result := resp.Responses["A"]
+	require.NoError(t, result.Error)
+	require.Len(t, result.Frames, 1)
+	require.Equal(t, 2, result.Frames[0].Rows())
+
+	var notices []string
+	for _, notice := range result.Frames[0].Meta.Notices {
+		notices = append(notices, notice.Text)
+	}
+	require.Contains(t, notices, "Axiom returned a partial response")
+	require.True(t, standIn.cancelled.Load(), "expected the async job to be cancelled on Axiom")
+}
+
+func TestQueryDataReturnsPartialAsyncResultsWhenGrafanaGivesUp(t *testing.T) {
+	standIn := &asyncQueryStandIn{t: t, polls: []string{
+		`{"state":"running","result":{"format":"tabular","status":{"rowsMatched":2},"tables":[{"name":"0","fields":[{"name":"message","type":"string"}],"columns":[["a","b"]]}]}}`,
+	}}
+	ds, upstream := newAsyncTestDatasource(t, standIn)
+	defer upstream.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs']","async":true}`)},
+		},
+	})
+	require.NoError(t, err)
+
+	result := resp.Responses["A"]
+	require.NoError(t, result.Error)
+	require.Len(t, result.Frames, 1)
+	require.Equal(t, 2, result.Frames[0].Rows())
+	require.Equal(t, "Axiom returned a partial response", result.Frames[0].Meta.Notices[0].Text)
+	require.True(t, standIn.cancelled.Load(), "expected the async job to be cancelled on Axiom")
+}
+
+func TestCheckHealthWarnsAboutPersonalTokensWithoutOrgID(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name        string
+		tokenType   config.TokenType
+		orgID       string
+		wantMessage string
+		wantDetails string
+	}{
+		{
+			name:        "api token",
+			tokenType:   config.TokenTypeAPI,
+			wantMessage: "Configuration is valid and ready to use",
+			wantDetails: `{"orgIdConfigured":false,"tokenType":"api"}`,
+		},
+		{
+			name:        "personal token with org ID",
+			tokenType:   config.TokenTypePersonal,
+			orgID:       "acme-x1y2",
+			wantMessage: "Configuration is valid and ready to use",
+			wantDetails: `{"orgIdConfigured":true,"tokenType":"personal"}`,
+		},
+		{
+			name:        "personal token without org ID",
+			tokenType:   config.TokenTypePersonal,
+			wantMessage: "Configuration is valid, but personal access tokens need an organization ID; set it in the datasource settings or use an API token",
+			wantDetails: `{"orgIdConfigured":false,"tokenType":"personal"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := Datasource{
+				api:       newTestAxiomClient(t, upstream.URL, upstream.URL),
+				tokenType: tt.tokenType,
+				orgID:     tt.orgID,
+			}
+
+			result, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+			require.NoError(t, err)
+			require.Equal(t, backend.HealthStatusOk, result.Status)
+			require.Equal(t, tt.wantMessage, result.Message)
+
+			var details map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(result.JSONDetails, &details))
+			delete(details, "edges")
+			gotDetails, err := json.Marshal(details)
+			require.NoError(t, err)
+			require.JSONEq(t, tt.wantDetails, string(gotDetails))
+		})
+	}
+}
+
+func TestCheckHealthReportsEdgeStatus(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer upstream.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+
+	timeouts := httpclient.DefaultTimeoutOptions
+	client, err := axiomapi.NewClient(httpclient.Options{Timeouts: &timeouts}, &config.PluginConfig{
+		APIHost:          upstream.URL,
+		EdgeURL:          downURL,
+		FailoverEdgeURLs: []string{upstream.URL},
+	})
+	require.NoError(t, err)
+	ds := Datasource{api: client, tokenType: config.TokenTypeAPI}
+	defer ds.Dispose()
+
+	result, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+
+	require.NoError(t, err)
+	require.Equal(t, backend.HealthStatusOk, result.Status)
+	require.Equal(t, "Configuration is valid, but 1 of 2 edges are unreachable: "+downURL, result.Message)
+
+	var details struct {
+		Edges []axiomapi.EdgeStatus `json:"edges"`
+	}
+	require.NoError(t, json.Unmarshal(result.JSONDetails, &details))
+	require.Len(t, details.Edges, 2)
+	require.Equal(t, downURL, details.Edges[0].URL)
+	require.False(t, details.Edges[0].Healthy)
+	require.NotEmpty(t, details.Edges[0].LastError)
+	require.Equal(t, upstream.URL, details.Edges[1].URL)
+	require.True(t, details.Edges[1].Healthy)
+}
+
+func BenchmarkAPLResponseDecoding(b *testing.B) {
+	response := benchmarkAPLResponse(20000)
+	builder := newAPLResponseFrameBuilder(false)
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(response)))
+		for i := 0; i < b.N; i++ {
+			var result axiomapi.APLQueryResponse
+			if err := json.NewDecoder(bytes.NewReader(response)).Decode(&result); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := builder.BuildFrames(context.Background(), result, aplFrameOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(response)))
+		for i := 0; i < b.N; i++ {
+			decoder := newAPLStreamDecoder(context.Background(), 0)
+			if err := decoder.Decode(bytes.NewReader(response)); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := builder.BuildDecodedFrames(context.Background(), decoder.Result(), aplFrameOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func benchmarkAPLResponse(rows int) []byte {
+	start := time.Date(2026, 6, 11, 2, 0, 0, 0, time.UTC)
+	times := make([]string, rows)
+	hosts := make([]string, rows)
+	statuses := make([]float64, rows)
+	durations := make([]*float64, rows)
+	for i := 0; i < rows; i++ {
+		times[i] = start.Add(time.Duration(i) * time.Second).Format(time.RFC3339Nano)
+		hosts[i] = fmt.Sprintf("host-%d", i%50)
+		statuses[i] = float64(200 + i%5)
+		if i%10 != 0 {
+			duration := float64(i) / 3
+			durations[i] = &duration
+		}
+	}
+
+	// A struct keeps Axiom's key order, which sends fields before columns.
+	type table struct {
+		Name    string        `json:"name"`
+		Fields  []query.Field `json:"fields"`
+		Columns []any         `json:"columns"`
+	}
+	b, err := json.Marshal(map[string]any{
+		"format": "tabular",
+		"tables": []table{{
+			Name: "0",
+			Fields: []query.Field{
+				{Name: "_time", Type: "datetime"},
+				{Name: "host", Type: "string"},
+				{Name: "status", Type: "integer"},
+				{Name: "duration", Type: "float"},
+			},
+			Columns: []any{times, hosts, statuses, durations},
+		}},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+func TestFieldsMatchTrace(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []query.Field
+		want   bool
+	}{
+		{
+			name: "grafana trace fields",
+			fields: []query.Field{
+				{Name: "traceID"},
+				{Name: "spanID"},
+				{Name: "operationName"},
+				{Name: "serviceName"},
+				{Name: "startTime"},
+				{Name: "duration"},
+			},
+			want: true,
+		},
+		{
+			name: "apl otel aliases",
+			fields: []query.Field{
+				{Name: "trace_id"},
+				{Name: "span_id"},
+				{Name: "name"},
+				{Name: "service.name"},
+				{Name: "_time"},
+				{Name: "duration"},
+			},
+			want: true,
+		},
+		{
+			name: "missing duration",
+			fields: []query.Field{
+				{Name: "trace_id"},
+				{Name: "span_id"},
+				{Name: "name"},
+				{Name: "service.name"},
+				{Name: "_time"},
+			},
+			want: false,
+		},
+		{
+			name: "parent and service tags are not required",
+			fields: []query.Field{
+				{Name: "trace_id"},
+				{Name: "span_id"},
+				{Name: "name"},
+				{Name: "service.name"},
+				{Name: "_time"},
+				{Name: "duration"},
+				{Name: "parent_span_id"},
+			},
+			want: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.want, fieldsMatchTrace(context.Background(), test.fields))
+		})
+	}
+}
+
+func TestBuildFrameSetsTraceMetadata(t *testing.T) {
+	table := query.Table{
+		Fields: []query.Field{
+			{Name: "trace_id", Type: "string"},
+			{Name: "span_id", Type: "string"},
+			{Name: "name", Type: "string"},
+			{Name: "service.name", Type: "string"},
+			{Name: "_time", Type: "datetime"},
+			{Name: "duration", Type: "timespan"},
+			{Name: "attributes.custom", Type: "unknown"},
+			{Name: "http.method", Type: "string"},
+		},
+		Columns: []query.Column{
+			{"trace-1"},
+			{"span-1"},
+			{"GET /"},
+			{"api"},
+			{"2026-06-11T02:19:39Z"},
+			{"666.387µs"},
+			{map[string]any{"normalizedDatasetName": "axiom-dataset"}},
+			{"POST"},
+		},
+	}
+
+	got, err := buildAPLFrame(context.Background(), &table)
+	require.NoError(t, err)
+	require.Equal(t, "Trace", got.Name)
+	require.NotNil(t, got.Meta)
+	require.EqualValues(t, data.VisTypeTrace, got.Meta.PreferredVisualization)
+	require.Len(t, got.Fields, 10)
+	require.Equal(t, "startTime", got.Fields[6].Name)
+	require.Equal(t, data.FieldTypeNullableFloat64, got.Fields[6].Type())
+	startTime, ok := got.Fields[6].At(0).(*float64)
+	require.True(t, ok)
+	require.InDelta(t, float64(1781144379000), *startTime, 0.001)
+
+	require.Equal(t, "duration", got.Fields[7].Name)
+	require.Equal(t, data.FieldTypeNullableFloat64, got.Fields[7].Type())
+	duration, ok := got.Fields[7].At(0).(*float64)
+	require.True(t, ok)
+	require.InDelta(t, 0.666387, *duration, 0.000001)
+
+	serviceTags, ok := got.Fields[5].At(0).(*json.RawMessage)
+	require.True(t, ok)
+	require.JSONEq(t, `[{"key":"service.name","value":"api"}]`, string(*serviceTags))
+
+	tags, ok := got.Fields[9].At(0).(*json.RawMessage)
+	require.True(t, ok)
+	require.JSONEq(t, `[{"key":"attributes.custom.normalizedDatasetName","value":"axiom-dataset"},{"key":"http.method","value":"POST"}]`, string(*tags))
 }
 
 func TestBuildFrameNormalizesTraceLogs(t *testing.T) {
@@ -1294,208 +2582,1285 @@ func TestBuildFrameNormalizesTraceLogs(t *testing.T) {
 			{Name: "duration", Type: "timespan"},
 			{Name: "events", Type: "unknown"},
 		},
-		Columns: []query.Column{
-			{"trace-1"},
-			{"span-1"},
-			{"GET /"},
-			{"api"},
-			{"2026-06-11T02:19:39Z"},
-			{"666.387µs"},
-			{
-				[]any{
-					map[string]any{
-						"timestamp": "2026-06-11T02:19:40Z",
-						"_time":     "2026-06-11T02:20:40Z",
-						"name":      "exception",
-						"attributes": map[string]any{
-							"exception.message": "boom",
-						},
-					},
-				},
+		Columns: []query.Column{
+			{"trace-1"},
+			{"span-1"},
+			{"GET /"},
+			{"api"},
+			{"2026-06-11T02:19:39Z"},
+			{"666.387µs"},
+			{
+				[]any{
+					map[string]any{
+						"timestamp": "2026-06-11T02:19:40Z",
+						"_time":     "2026-06-11T02:20:40Z",
+						"name":      "exception",
+						"attributes": map[string]any{
+							"exception.message": "boom",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	got, err := buildAPLFrame(context.Background(), &table)
+	require.NoError(t, err)
+
+	logs, ok := got.Fields[8].At(0).(*json.RawMessage)
+	require.True(t, ok)
+	require.JSONEq(t, `[{"fields":[{"key":"exception.message","value":"boom"}],"name":"exception","timestamp":1781144440000}]`, string(*logs))
+}
+
+func TestAPLWideFrameBuilderFillsMissingWithPreviousValue(t *testing.T) {
+	t1 := time.Date(2026, 6, 11, 13, 45, 0, 0, time.UTC)
+	t2 := time.Date(2026, 6, 11, 13, 50, 0, 0, time.UTC)
+	t3 := time.Date(2026, 6, 11, 13, 55, 0, 0, time.UTC)
+	v1 := float64(100)
+	v2 := float64(200)
+	v3 := float64(110)
+
+	longFrame := data.NewFrame(
+		"response",
+		data.NewField("_time", nil, []time.Time{t1, t2, t3}),
+		data.NewField("Lambda Name", nil, []*string{stringPtr("a"), stringPtr("b"), stringPtr("a")}),
+		data.NewField("Duration", nil, []*float64{&v1, &v2, &v3}),
+	)
+	longFrame.Fields[2].Config = &data.FieldConfig{Unit: "ms"}
+
+	wideFrame, err := aplWideFrameBuilder{}.Build(longFrame)
+	require.NoError(t, err)
+	require.Len(t, wideFrame.Fields, 3)
+
+	var seriesA *data.Field
+	for _, field := range wideFrame.Fields {
+		if field.Labels["Lambda Name"] == "a" {
+			seriesA = field
+			break
+		}
+	}
+	require.NotNil(t, seriesA)
+	require.NotNil(t, seriesA.Config)
+	require.Equal(t, "a", seriesA.Config.DisplayNameFromDS)
+	require.Equal(t, "ms", seriesA.Config.Unit)
+
+	filledValue, ok := seriesA.At(1).(*float64)
+	require.True(t, ok)
+	require.Equal(t, float64(100), *filledValue)
+}
+
+func TestMetricsFrameBuilderUsesTagValuesForSeriesName(t *testing.T) {
+	v1 := float64(0.1)
+	v2 := float64(0.2)
+
+	frame := newMetricsFrameBuilder(axiomapi.MetricsQueryMetadata{Unit: "{cpu}"}, "A").Build(
+		axiomapi.MetricsQuerySeries{
+			Resolution: 60,
+			Start:      1781186400,
+			Metric:     "k8s.pod.cpu.usage",
+			Tags: map[string]string{
+				"container.image.name": "602401143452.dkr.ecr.eu-west-1.amazonaws.com/eks/kube-proxy",
+				"container.name":       "api",
+				"pod.name":             "api-7d9",
+			},
+			Data: []*float64{&v1, &v2},
+		},
+	)
+
+	require.Equal(t, "A", frame.RefID)
+	require.Len(t, frame.Fields, 2)
+	require.NotNil(t, frame.Meta)
+	require.Equal(t, data.FrameTypeTimeSeriesMulti, frame.Meta.Type)
+	require.Equal(t, data.FrameTypeVersion{0, 1}, frame.Meta.TypeVersion)
+	require.Equal(t, "602401143452.dkr.ecr.eu-west-1.amazonaws.com/eks/kube-proxy | api | api-7d9", frame.Fields[1].Name)
+	require.NotNil(t, frame.Fields[0].Config)
+	require.Equal(t, float64(60000), frame.Fields[0].Config.Interval)
+	require.NotNil(t, frame.Fields[1].Config)
+	require.Equal(t, "", frame.Fields[1].Config.DisplayNameFromDS)
+	require.Equal(t, "api", frame.Fields[1].Labels["container.name"])
+	require.Equal(t, "{cpu}", frame.Fields[1].Config.Unit)
+}
+
+func TestMetricsFrameBuilderUsesExplicitLabelTagForSeriesName(t *testing.T) {
+	v1 := float64(0.1)
+
+	frame := newMetricsFrameBuilder(axiomapi.MetricsQueryMetadata{Unit: "short"}, "A").Build(
+		axiomapi.MetricsQuerySeries{
+			Resolution: 60,
+			Start:      1781186400,
+			Metric:     "k8s.pod.cpu.usage",
+			Tags: map[string]string{
+				"__label":  "api-7d9",
+				"pod.name": "api-7d9",
+			},
+			Data: []*float64{&v1},
+		},
+	)
+
+	require.Len(t, frame.Fields, 2)
+	require.Equal(t, "api-7d9", frame.Fields[1].Name)
+	require.NotNil(t, frame.Fields[1].Config)
+	require.Equal(t, "api-7d9", frame.Fields[1].Config.DisplayNameFromDS)
+	require.Equal(t, "api-7d9", frame.Fields[1].Labels["__label"])
+	require.Equal(t, "api-7d9", frame.Fields[1].Labels["pod.name"])
+}
+
+func TestMetricsFrameBuilderBuildsLabeledTableFrame(t *testing.T) {
+	cpuAPI := float64(0.2)
+	cpuAPIReplica := float64(0.4)
+	memAPI := float64(512)
+	cpuWorker := float64(0.8)
+
+	frame := newMetricsFrameBuilder(axiomapi.MetricsQueryMetadata{Unit: "short"}, "A").BuildTable(
+		[]axiomapi.MetricsQuerySeries{
+			{
+				Metric: "cpu",
+				Tags:   map[string]string{"__label": "api", "container.name": "app", "pod.name": "api-7d9"},
+				Data:   []*float64{nil, &cpuAPI},
+			},
+			{
+				Metric: "memory",
+				Tags:   map[string]string{"__label": "api", "container.name": "app", "pod.name": "api-7d9"},
+				Data:   []*float64{&memAPI},
+			},
+			{
+				Metric: "cpu",
+				Tags:   map[string]string{"__label": "api", "container.name": "sidecar", "pod.name": "api-8bc"},
+				Data:   []*float64{&cpuAPIReplica},
+			},
+			{
+				Metric: "cpu",
+				Tags:   map[string]string{"__label": "worker", "container.name": "app", "pod.name": "worker-5f8"},
+				Data:   []*float64{&cpuWorker},
+			},
+			{
+				Metric: "memory",
+				Tags:   map[string]string{"__label": "worker", "container.name": "app", "pod.name": "worker-5f8"},
+				Data:   []*float64{nil},
+			},
+		},
+	)
+
+	require.Equal(t, "A", frame.RefID)
+	require.NotNil(t, frame.Meta)
+	require.EqualValues(t, data.VisTypeTable, frame.Meta.PreferredVisualization)
+	require.Len(t, frame.Fields, 5)
+	require.Equal(t, "__label", frame.Fields[0].Name)
+	require.Equal(t, "container.name", frame.Fields[1].Name)
+	require.Equal(t, "pod.name", frame.Fields[2].Name)
+	require.Equal(t, "cpu", frame.Fields[3].Name)
+	require.Equal(t, "memory", frame.Fields[4].Name)
+	require.Equal(t, "api", *frame.Fields[0].At(0).(*string))
+	require.Equal(t, "api", *frame.Fields[0].At(1).(*string))
+	require.Equal(t, "worker", *frame.Fields[0].At(2).(*string))
+	require.Equal(t, "app", *frame.Fields[1].At(0).(*string))
+	require.Equal(t, "sidecar", *frame.Fields[1].At(1).(*string))
+	require.Equal(t, "app", *frame.Fields[1].At(2).(*string))
+	require.Equal(t, "api-7d9", *frame.Fields[2].At(0).(*string))
+	require.Equal(t, "api-8bc", *frame.Fields[2].At(1).(*string))
+	require.Equal(t, "worker-5f8", *frame.Fields[2].At(2).(*string))
+	require.Equal(t, cpuAPI, *frame.Fields[3].At(0).(*float64))
+	require.Equal(t, cpuAPIReplica, *frame.Fields[3].At(1).(*float64))
+	require.Equal(t, cpuWorker, *frame.Fields[3].At(2).(*float64))
+	require.Equal(t, memAPI, *frame.Fields[4].At(0).(*float64))
+	require.Nil(t, frame.Fields[4].At(1))
+	require.Nil(t, frame.Fields[4].At(2))
+	require.NotNil(t, frame.Fields[3].Config)
+	require.Equal(t, "short", frame.Fields[3].Config.Unit)
+}
+
+func TestMetricsFrameBuilderSetsDisplayNameFromRefIDWhenTagsAreEmpty(t *testing.T) {
+	v1 := float64(0.1)
+
+	frame := newMetricsFrameBuilder(axiomapi.MetricsQueryMetadata{}, "A").Build(
+		axiomapi.MetricsQuerySeries{
+			Resolution: 60,
+			Start:      1781186400,
+			Metric:     "k8s.pod.cpu.usage",
+			Data:       []*float64{&v1},
+		},
+	)
+
+	require.Len(t, frame.Fields, 2)
+	require.Equal(t, "k8s.pod.cpu.usage", frame.Fields[1].Name)
+	require.NotNil(t, frame.Fields[1].Config)
+	require.Empty(t, frame.Fields[1].Labels)
+	require.Equal(t, "A", frame.Fields[1].Config.DisplayNameFromDS)
+}
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := tracing.DefaultTracer()
+	tracing.InitDefaultTracer(provider.Tracer("test"))
+	t.Cleanup(func() { tracing.InitDefaultTracer(previous) })
+
+	return recorder
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestQueryDataTracesQueriesAndPropagatesTraceContext(t *testing.T) {
+	recorder := recordSpans(t)
+	responseBody := `{"format":"tabular","tables":[{"name":"0","fields":[{"name":"_time","type":"datetime"},{"name":"message","type":"string"}],"columns":[["2024-01-01T00:00:01Z","2024-01-01T00:00:00Z"],["b","a"]]}]}`
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Axiom-Trace-Id", "axiom-trace")
+		_, err := w.Write([]byte(responseBody))
+		require.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			RefID:     "A",
+			JSON:      json.RawMessage(`{"kind":"apl","query":"['logs']"}`),
+			TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)},
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "axiom.QueryData")
+	require.Contains(t, spans, "axiom.execQuery")
+	require.Contains(t, spans, "axiom.buildFrames")
+	require.Contains(t, spans, "axiom.request")
+
+	root := spans["axiom.QueryData"].SpanContext()
+	for _, name := range []string{"axiom.execQuery", "axiom.buildFrames", "axiom.request"} {
+		require.Equal(t, root.TraceID(), spans[name].SpanContext().TraceID(), name)
+	}
+	require.Equal(t, root.SpanID(), spans["axiom.execQuery"].Parent().SpanID())
+	require.Equal(t, spans["axiom.execQuery"].SpanContext().SpanID(), spans["axiom.request"].Parent().SpanID())
+
+	// Axiom gets the W3C trace context of the request span.
+	request := spans["axiom.request"].SpanContext()
+	require.Equal(t, fmt.Sprintf("00-%s-%s-01", request.TraceID(), request.SpanID()), traceparent)
+
+	exec := spanAttributes(spans["axiom.execQuery"])
+	require.Equal(t, "A", exec[attributeRefID].AsString())
+	require.Equal(t, "apl", exec[axiomapi.AttributeQueryKind].AsString())
+	require.Equal(t, int64(2), exec[axiomapi.AttributeRows].AsInt64())
+	require.Equal(t, int64(len(responseBody)), exec[axiomapi.AttributeResponseBytes].AsInt64())
+	require.Equal(t, "axiom-trace", exec[axiomapi.AttributeTraceID].AsString())
+
+	frames := spanAttributes(spans["axiom.buildFrames"])
+	require.Equal(t, int64(2), frames[axiomapi.AttributeRows].AsInt64())
+
+	httpSpan := spanAttributes(spans["axiom.request"])
+	require.Equal(t, int64(len(responseBody)), httpSpan[axiomapi.AttributeResponseBytes].AsInt64())
+	require.Equal(t, "axiom-trace", httpSpan[axiomapi.AttributeTraceID].AsString())
+	require.Equal(t, int64(http.StatusOK), httpSpan["http.response.status_code"].AsInt64())
+}
+
+func TestQueryDataRecordsFailedQueriesOnSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Axiom-Trace-Id", "failed-trace")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message":"bad query"}`))
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs'] | bad"}`)}},
+	})
+	require.NoError(t, err)
+	require.Error(t, resp.Responses["A"].Error)
+
+	var exec sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "axiom.execQuery" {
+			exec = span
+		}
+	}
+	require.NotNil(t, exec)
+	require.Equal(t, codes.Error, exec.Status().Code)
+	require.Equal(t, "failed-trace", spanAttributes(exec)[axiomapi.AttributeTraceID].AsString())
+}
+
+// collectedMetric returns the value of a counter, or the sample count of a
+// histogram, from the default registry the SDK serves metrics from.
+func collectedMetric(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	var family *dto.MetricFamily
+	for _, f := range families {
+		if f.GetName() == name {
+			family = f
+		}
+	}
+	if family == nil {
+		return 0
+	}
+	for _, metric := range family.GetMetric() {
+		matched := 0
+		for _, label := range metric.GetLabel() {
+			if value, ok := labels[label.GetName()]; ok && value == label.GetValue() {
+				matched++
+			}
+		}
+		if matched != len(labels) {
+			continue
+		}
+		if metric.GetHistogram() != nil {
+			return float64(metric.GetHistogram().GetSampleCount())
+		}
+		return metric.GetCounter().GetValue()
+	}
+	return 0
+}
+
+func TestMetricsReportQueriesUpstreamRequestsAndResources(t *testing.T) {
+	responseBody := `{"format":"tabular","tables":[{"name":"0","fields":[{"name":"_time","type":"datetime"},{"name":"message","type":"string"}],"columns":[["2024-01-01T00:00:00Z"],["a"]]}]}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/query/_apl":
+			_, _ = w.Write([]byte(responseBody))
+		case "/v1/query/_mpl":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message":"bad query"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	ds := &Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	aplSuccess := map[string]string{"kind": "apl", "outcome": "success"}
+	mplError := map[string]string{"kind": "mpl", "outcome": "error"}
+	aplRequests := map[string]string{"endpoint": "/v1/query/_apl", "status_class": "2xx"}
+	mplRequests := map[string]string{"endpoint": "/v1/query/_mpl", "status_class": "4xx"}
+	schemaLookups := map[string]string{"endpoint": "/schema-lookup", "status_class": "5xx"}
+	before := map[string]float64{
+		"apl":      collectedMetric(t, "axiom_datasource_query_duration_seconds", aplSuccess),
+		"mpl":      collectedMetric(t, "axiom_datasource_query_duration_seconds", mplError),
+		"bytes":    collectedMetric(t, "axiom_datasource_query_response_bytes_total", map[string]string{"kind": "apl"}),
+		"frames":   collectedMetric(t, "axiom_datasource_query_frames_total", map[string]string{"kind": "apl"}),
+		"upAPL":    collectedMetric(t, "axiom_datasource_upstream_request_duration_seconds", aplRequests),
+		"upMPL":    collectedMetric(t, "axiom_datasource_upstream_request_duration_seconds", mplRequests),
+		"resource": collectedMetric(t, "axiom_datasource_resource_request_duration_seconds", schemaLookups),
+	}
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: json.RawMessage(`{"kind":"apl","query":"['logs']"}`)},
+			{RefID: "B", JSON: json.RawMessage(`{"kind":"mpl","query":"metrics:cpu"}`)},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	require.Error(t, resp.Responses["B"].Error)
+	require.Equal(t, http.StatusInternalServerError, callResource(t, ds.newResourceHandler(), "/schema-lookup").Status)
+
+	require.Equal(t, before["apl"]+1, collectedMetric(t, "axiom_datasource_query_duration_seconds", aplSuccess))
+	require.Equal(t, before["mpl"]+1, collectedMetric(t, "axiom_datasource_query_duration_seconds", mplError))
+	require.Equal(t, before["bytes"]+float64(len(responseBody)), collectedMetric(t, "axiom_datasource_query_response_bytes_total", map[string]string{"kind": "apl"}))
+	require.Equal(t, before["frames"]+1, collectedMetric(t, "axiom_datasource_query_frames_total", map[string]string{"kind": "apl"}))
+	require.Equal(t, before["upAPL"]+1, collectedMetric(t, "axiom_datasource_upstream_request_duration_seconds", aplRequests))
+	require.Equal(t, before["upMPL"]+1, collectedMetric(t, "axiom_datasource_upstream_request_duration_seconds", mplRequests))
+	require.Equal(t, before["resource"]+1, collectedMetric(t, "axiom_datasource_resource_request_duration_seconds", schemaLookups))
+}
+
+func newDatasetCatalogUpstream(t *testing.T, fieldsStatus int) *httptest.Server {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v2/datasets":
+			_, _ = w.Write([]byte(`[
+				{"id":"web-logs","name":"web-logs","description":"Web server logs","kind":"axiom:events:v1","created":"2024-01-02T03:04:05Z","useRetentionPeriod":true,"retentionDays":30},
+				{"id":"spans","name":"spans","kind":"axiom:events:v1","created":"2024-02-01T00:00:00Z"},
+				{"id":"host-metrics","name":"host-metrics","kind":"otel:metrics:v1","created":"2024-03-01T00:00:00Z","useRetentionPeriod":false,"retentionDays":90}
+			]`))
+		case "/v1/datasets/_fields":
+			if fieldsStatus != http.StatusOK {
+				w.WriteHeader(fieldsStatus)
+				return
+			}
+			_, _ = w.Write([]byte(`[
+				{"datasetName":"web-logs","fields":[{"name":"message","type":"string"},{"name":"level","type":"string"}]},
+				{"datasetName":"spans","fields":[{"name":"trace_id","type":"string"},{"name":"span_id","type":"string"},{"name":"name","type":"string"},{"name":"service.name","type":"string"},{"name":"duration","type":"timespan"}]}
+			]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	return upstream
+}
+
+func TestDatasetsResourceReturnsDatasetMetadata(t *testing.T) {
+	upstream := newDatasetCatalogUpstream(t, http.StatusOK)
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+
+	resp := callResource(t, ds.newResourceHandler(), "/datasets")
+	require.Equal(t, http.StatusOK, resp.Status)
+	require.JSONEq(t, `[
+		{"id":"host-metrics","name":"host-metrics","description":"","kind":"otel:metrics:v1","createdAt":"2024-03-01T00:00:00Z","retentionDays":null,"usage":{"logs":false,"traces":false,"metrics":true}},
+		{"id":"spans","name":"spans","description":"","kind":"axiom:events:v1","createdAt":"2024-02-01T00:00:00Z","retentionDays":null,"usage":{"logs":false,"traces":true,"metrics":false}},
+		{"id":"web-logs","name":"web-logs","description":"Web server logs","kind":"axiom:events:v1","createdAt":"2024-01-02T03:04:05Z","retentionDays":30,"usage":{"logs":true,"traces":false,"metrics":false}}
+	]`, string(resp.Body))
+}
+
+func TestDatasetsResourceFiltersByKindAndName(t *testing.T) {
+	upstream := newDatasetCatalogUpstream(t, http.StatusOK)
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	handler := ds.newResourceHandler()
+
+	names := func(path string) []string {
+		resp := callResource(t, handler, path)
+		require.Equal(t, http.StatusOK, resp.Status)
+		var datasets []datasetInfo
+		require.NoError(t, json.Unmarshal(resp.Body, &datasets))
+		names := []string{}
+		for _, dataset := range datasets {
+			names = append(names, dataset.Name)
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"spans"}, names("/datasets?kind=traces"))
+	require.Equal(t, []string{"host-metrics"}, names("/datasets?kind=otel:metrics:v1"))
+	require.Equal(t, []string{"spans", "web-logs"}, names("/datasets?kind=logs,traces"))
+	require.Equal(t, []string{"spans", "web-logs"}, names("/datasets?kind=logs&kind=traces"))
+	require.Equal(t, []string{"web-logs"}, names("/datasets?name=LOGS"))
+	require.Equal(t, []string{}, names("/datasets?kind=metrics&name=logs"))
+}
+
+func TestDatasetsResourceClassifiesByKindWhenFieldsAreUnavailable(t *testing.T) {
+	upstream := newDatasetCatalogUpstream(t, http.StatusForbidden)
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+
+	resp := callResource(t, ds.newResourceHandler(), "/datasets?kind=metrics")
+	require.Equal(t, http.StatusOK, resp.Status)
+	var datasets []datasetInfo
+	require.NoError(t, json.Unmarshal(resp.Body, &datasets))
+	require.Len(t, datasets, 1)
+	require.Equal(t, "host-metrics", datasets[0].Name)
+}
+
+func TestDatasetFieldsResourceServesCachedSchemaWithETag(t *testing.T) {
+	var lookups atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/v1/datasets/team%2Flogs/fields":
+			lookups.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`[{"name":"message","type":"string","unit":"","hidden":false,"description":""}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{
+		api:     newTestAxiomClient(t, upstream.URL, upstream.URL),
+		schemas: newSchemaCache(&config.PluginConfig{SchemaCacheTTL: time.Minute}),
+	}
+	defer ds.schemas.close()
+	handler := ds.newResourceHandler()
+
+	fetch := func(path, ifNoneMatch string) *backend.CallResourceResponse {
+		var resp *backend.CallResourceResponse
+		err := handler.CallResource(context.Background(), &backend.CallResourceRequest{
+			Method:  http.MethodGet,
+			Path:    path,
+			URL:     path,
+			Headers: map[string][]string{"If-None-Match": {ifNoneMatch}},
+		}, backend.CallResourceResponseSenderFunc(func(r *backend.CallResourceResponse) error {
+			resp = r
+			return nil
+		}))
+		require.NoError(t, err)
+		return resp
+	}
+
+	first := fetch("/datasets/team%2Flogs/fields", "")
+	require.Equal(t, http.StatusOK, first.Status)
+	require.JSONEq(t, `[{"name":"message","type":"string","unit":"","hidden":false,"description":""}]`, string(first.Body))
+	etag := first.Headers["Etag"]
+	require.Len(t, etag, 1)
+
+	notModified := fetch("/datasets/team%2Flogs/fields", etag[0])
+	require.Equal(t, http.StatusNotModified, notModified.Status)
+	require.Empty(t, notModified.Body)
+
+	require.Equal(t, http.StatusOK, fetch("/datasets/team%2Flogs/fields", `"other"`).Status)
+	require.Equal(t, int32(1), lookups.Load())
+
+	require.Equal(t, http.StatusNotFound, fetch("/datasets/missing/fields", "").Status)
+}
+
+func TestSchemaCacheRefreshesStaleEntriesInBackground(t *testing.T) {
+	cache := newSchemaCache(&config.PluginConfig{SchemaCacheTTL: time.Minute})
+	defer cache.close()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	var version atomic.Int32
+	refreshed := make(chan struct{}, 1)
+	load := func(context.Context) (any, error) {
+		v := version.Add(1)
+		if v > 1 {
+			refreshed <- struct{}{}
+		}
+		return []string{fmt.Sprintf("v%d", v)}, nil
+	}
+
+	entry, err := cache.get(context.Background(), "logs", load)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1"}, entry.value)
+
+	entry, err = cache.get(context.Background(), "logs", load)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1"}, entry.value)
+	require.Equal(t, int32(1), version.Load())
+
+	// Once stale, the old schema is served while a refresh runs.
+	now = now.Add(time.Minute)
+	entry, err = cache.get(context.Background(), "logs", load)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1"}, entry.value)
+	<-refreshed
+	cache.wg.Wait()
+
+	refreshedEntry, err := cache.get(context.Background(), "logs", load)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2"}, refreshedEntry.value)
+	require.NotEqual(t, entry.etag, refreshedEntry.etag)
+}
+
+func TestFieldValuesResourceReturnsTopValuesWithCounts(t *testing.T) {
+	var calls atomic.Int32
+	var request axiomapi.APLQueryRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/query/_apl", r.URL.Path)
+		calls.Add(1)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"format":"tabular","tables":[{
+			"name":"0",
+			"fields":[{"name":"_value","type":"string"},{"name":"count_","type":"integer"}],
+			"columns":[["GET","GONE"],[120,3]]
+		}]}`))
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{
+		api:              newTestAxiomClient(t, upstream.URL, upstream.URL),
+		fieldValuesCache: newFieldValuesCache(),
+	}
+	handler := ds.newResourceHandler()
+
+	path := "/datasets/web-logs/fields/request.method/values?start=2024-01-01T00:00:10Z&end=2024-01-01T01:00:00Z&prefix=g'&limit=500"
+	resp := callResource(t, handler, path)
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	require.JSONEq(t, `[{"value":"GET","count":120},{"value":"GONE","count":3}]`, string(resp.Body))
+
+	require.Equal(t, `['web-logs']
+| where isnotempty(tostring(['request.method']))
+| where tostring(['request.method']) startswith 'g\''
+| summarize count_ = count() by _value = tostring(['request.method'])
+| top 100 by count_ desc`, *request.APL)
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), request.StartTime.UTC())
+	require.Equal(t, time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC), request.EndTime.UTC())
+
+	// The same lookup a few seconds later is served from the cache.
+	resp = callResource(t, handler, strings.Replace(path, "00:00:10Z", "00:00:40Z", 1))
+	require.Equal(t, http.StatusOK, resp.Status)
+	require.JSONEq(t, `[{"value":"GET","count":120},{"value":"GONE","count":3}]`, string(resp.Body))
+	require.Equal(t, int32(1), calls.Load())
+}
+
+func TestFieldValuesResourceRejectsInvalidParameters(t *testing.T) {
+	ds := Datasource{}
+	handler := ds.newResourceHandler()
+
+	for _, query := range []string{"start=yesterday", "end=2024-01-01", "limit=0", "limit=ten", "start=2024-01-02T00:00:00Z&end=2024-01-01T00:00:00Z"} {
+		resp := callResource(t, handler, "/datasets/web-logs/fields/status/values?"+query)
+		require.Equal(t, http.StatusBadRequest, resp.Status, query)
+	}
+}
+
+func TestValidateResourceReturnsCompileDiagnostics(t *testing.T) {
+	var request axiomapi.APLQueryRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"code":"apl_parse_error","message":"line: 2, col: 3: syntax error: unexpected token 'whre'"}`))
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+
+	resp := postResource(t, ds.newResourceHandler(), "/validate", map[string]any{"apl": "['logs']\n| whre status == 500"})
+	require.Equal(t, http.StatusOK, resp.Status)
+	require.JSONEq(t, `{
+		"valid": false,
+		"diagnostics": [{"severity":"error","line":2,"column":3,"message":"syntax error: unexpected token 'whre'","code":"apl_parse_error"}],
+		"datasets": ["logs"]
+	}`, string(resp.Body))
+
+	// Validation reads no events.
+	require.Equal(t, time.Unix(0, 0).UTC(), request.StartTime.UTC())
+	require.Equal(t, time.Unix(1, 0).UTC(), request.EndTime.UTC())
+}
+
+func TestValidateResourceExpandsMacros(t *testing.T) {
+	var request axiomapi.APLQueryRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"code":"apl_parse_error","message":"line: 2, col: 80: unknown column 'stauts'\nline: 3, col: 3: syntax error: unexpected token 'whre'"}`))
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+
+	resp := postResource(t, ds.newResourceHandler(), "/validate", map[string]any{"apl": "['logs']\n| where $__timeFilter() and stauts == 500\n| whre true"})
+	require.Equal(t, http.StatusOK, resp.Status)
+	require.Equal(t, "['logs']\n| where (_time >= datetime(1970-01-01T00:00:00Z) and _time <= datetime(1970-01-01T00:00:01Z)) and stauts == 500\n| whre true", *request.APL)
+
+	// The column on the line with the macro points into the expanded query,
+	// so that diagnostic covers the whole line.
+	var validation aplValidation
+	require.NoError(t, json.Unmarshal(resp.Body, &validation))
+	require.False(t, validation.Valid)
+	require.Equal(t, []aplDiagnostic{
+		{Severity: "error", Line: 2, Column: 1, Message: "unknown column 'stauts'", Code: "apl_parse_error"},
+		{Severity: "error", Line: 3, Column: 3, Message: "syntax error: unexpected token 'whre'", Code: "apl_parse_error"},
+	}, validation.Diagnostics)
+}
+
+func TestValidateResourceDetectsResultShape(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/datasets/logs/fields":
+			_, _ = w.Write([]byte(`[{"name":"message","type":"string"},{"name":"level","type":"string"}]`))
+		case "/v1/query/_apl":
+			var request axiomapi.APLQueryRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			switch {
+			case strings.Contains(*request.APL, "summarize"):
+				_, _ = w.Write([]byte(`{"format":"tabular","status":{"messages":[{"priority":"warn","code":"apl_unused","msg":"line: 1, col: 12: unused column"}]},"tables":[
+					{"name":"0","fields":[{"name":"_time","type":"datetime"},{"name":"count_","type":"integer"}],"columns":[[],[]]},
+					{"name":"_totals","fields":[{"name":"count_","type":"integer"}],"columns":[[]]}
+				]}`))
+			case strings.Contains(*request.APL, "project"):
+				_, _ = w.Write([]byte(`{"format":"tabular","tables":[{"name":"0","fields":[{"name":"status","type":"integer"}],"columns":[[]]}]}`))
+			default:
+				_, _ = w.Write([]byte(`{"format":"tabular","tables":[{"name":"0","fields":[],"columns":[]}]}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	handler := ds.newResourceHandler()
+
+	validate := func(apl string) aplValidation {
+		resp := postResource(t, handler, "/validate", map[string]any{"apl": apl})
+		require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+		var validation aplValidation
+		require.NoError(t, json.Unmarshal(resp.Body, &validation))
+		return validation
+	}
+
+	timeSeries := validate("['logs'] | summarize count() by bin_auto(_time)")
+	require.True(t, timeSeries.Valid)
+	require.Equal(t, aplShapeTimeSeries, timeSeries.Shape)
+	require.Equal(t, []string{"logs"}, timeSeries.Datasets)
+	require.Equal(t, []aplDiagnostic{{Severity: "warning", Line: 1, Column: 12, Message: "unused column", Code: "apl_unused"}}, timeSeries.Diagnostics)
+
+	table := validate("['logs'] | project status")
+	require.True(t, table.Valid)
+	require.Equal(t, aplShapeTable, table.Shape)
+
+	// Without rows Axiom may not list the fields, so the dataset's schema
+	// decides.
+	logs := validate("['logs']")
+	require.True(t, logs.Valid)
+	require.Equal(t, aplShapeLogs, logs.Shape)
+}
+
+func TestApplyAdhocFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		query   string
+		filters []adhocFilter
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "apl operators after the source",
+			kind:  "apl",
+			query: "['http-logs']\n| summarize count() by status",
+			filters: []adhocFilter{
+				{Key: "service.name", Operator: "=", Value: "it's"},
+				{Key: "method", Operator: "!=", Value: "GET"},
+				{Key: "path", Operator: "=~", Value: `^/api\b`},
+				{Key: "path", Operator: "!~", Value: "health"},
+				{Key: "status", Operator: ">", Value: "499"},
+				{Key: "_time", Operator: "<", Value: "2024-01-01T00:00:00Z"},
 			},
+			want: "['http-logs'] | where tostring(['service.name']) == 'it\\'s' and tostring(['method']) != 'GET' and tostring(['path']) matches regex '^/api\\\\b' and not(tostring(['path']) matches regex 'health') and todouble(['status']) > 499 and ['_time'] < datetime(2024-01-01T00:00:00Z)\n| summarize count() by status",
+		},
+		{
+			name:    "apl query statement after let",
+			kind:    "apl",
+			query:   "let threshold = 500;\nlogs | where status >= threshold",
+			filters: []adhocFilter{{Key: "host", Operator: "=", Value: "a"}},
+			want:    "let threshold = 500;\nlogs | where tostring(['host']) == 'a' | where status >= threshold",
+		},
+		{
+			name:    "mpl filters",
+			kind:    "mpl",
+			query:   "`otel-metrics`:`http.requests` | group by route using sum",
+			filters: []adhocFilter{{Key: "service.name", Operator: "=", Value: `say "hi"`}, {Key: "route", Operator: "=~", Value: "/api/.*"}},
+			want:    "`otel-metrics`:`http.requests` | where `service.name` == \"say \\\"hi\\\"\" | where route =~ /\\/api\\/.*/ | group by route using sum",
+		},
+		{
+			name:    "comparison needs a number or time",
+			kind:    "apl",
+			query:   "logs",
+			filters: []adhocFilter{{Key: "status", Operator: "<", Value: "high"}},
+			wantErr: `ad-hoc filter status < "high": < needs a number or an RFC 3339 time`,
+		},
+		{
+			name:    "unknown operator",
+			kind:    "apl",
+			query:   "logs",
+			filters: []adhocFilter{{Key: "status", Operator: "=|", Value: "500"}},
+			wantErr: `unsupported ad-hoc filter operator "=|"`,
+		},
+		{
+			name:    "no source",
+			kind:    "apl",
+			query:   "let x = 1;",
+			filters: []adhocFilter{{Key: "status", Operator: "=", Value: "500"}},
+			wantErr: "ad-hoc filters need an APL query that starts with a tabular source such as ['dataset']",
 		},
 	}
 
-	got, err := buildAPLFrame(context.Background(), &table)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := applyAdhocFilters(test.kind, test.query, test.filters)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestQueryDataAppliesAdhocFilters(t *testing.T) {
+	var request axiomapi.APLQueryRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"format":"tabular","tables":[{"name":"0","fields":[{"name":"status","type":"integer"}],"columns":[[500]]}]}`))
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			RefID: "A",
+			JSON:  json.RawMessage(`{"kind":"apl","query":"['logs'] | project status","adhocFilters":[{"key":"level","operator":"=","value":"error"}]}`),
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+
+	want := "['logs'] | where tostring(['level']) == 'error' | project status"
+	require.Equal(t, want, *request.APL)
+	require.Equal(t, want, resp.Responses["A"].Frames[0].Meta.ExecutedQueryString)
+}
+
+func TestQueryDataKeepsMacrosInAdhocFilterValues(t *testing.T) {
+	var mpl axiomapi.MPLQueryRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&mpl))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"series":[{"metric":"cpu","start":0,"resolution":60,"data":[1,2]}]}`))
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			RefID: "A",
+			JSON:  json.RawMessage(`{"kind":"mpl","query":"metrics:cpu | align to $__interval using avg","adhocFilters":[{"key":"note","operator":"=","value":"since $__timeFrom"}]}`),
+			TimeRange: backend.TimeRange{
+				From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2024, 1, 1, 4, 0, 0, 0, time.UTC),
+			},
+			Interval: time.Minute,
+		}},
+	})
 	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
 
-	logs, ok := got.Fields[8].At(0).(*json.RawMessage)
-	require.True(t, ok)
-	require.JSONEq(t, `[{"fields":[{"key":"exception.message","value":"boom"}],"name":"exception","timestamp":1781144440000}]`, string(*logs))
+	require.Equal(t, `metrics:cpu | where note == "since $__timeFrom" | align to 1m using avg`, *mpl.MPL)
 }
 
-func TestAPLWideFrameBuilderFillsMissingWithPreviousValue(t *testing.T) {
-	t1 := time.Date(2026, 6, 11, 13, 45, 0, 0, time.UTC)
-	t2 := time.Date(2026, 6, 11, 13, 50, 0, 0, time.UTC)
-	t3 := time.Date(2026, 6, 11, 13, 55, 0, 0, time.UTC)
-	v1 := float64(100)
-	v2 := float64(200)
-	v3 := float64(110)
-
-	longFrame := data.NewFrame(
-		"response",
-		data.NewField("_time", nil, []time.Time{t1, t2, t3}),
-		data.NewField("Lambda Name", nil, []*string{stringPtr("a"), stringPtr("b"), stringPtr("a")}),
-		data.NewField("Duration", nil, []*float64{&v1, &v2, &v3}),
-	)
-	longFrame.Fields[2].Config = &data.FieldConfig{Unit: "ms"}
+func TestQueryDataOmitsDiagnosticsFrameForFilteredQueries(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"code":"apl_parse_error","message":"line: 1, col: 60: syntax error: unexpected token 'whre'"}`))
+	}))
+	defer upstream.Close()
 
-	wideFrame, err := aplWideFrameBuilder{}.Build(longFrame)
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			RefID: "A",
+			JSON:  json.RawMessage(`{"kind":"apl","query":"['logs'] | whre status == 500","adhocFilters":[{"key":"level","operator":"=","value":"error"}]}`),
+		}},
+	})
 	require.NoError(t, err)
-	require.Len(t, wideFrame.Fields, 3)
 
-	var seriesA *data.Field
-	for _, field := range wideFrame.Fields {
-		if field.Labels["Lambda Name"] == "a" {
-			seriesA = field
-			break
+	// The position is in the filtered query, not the one in the editor.
+	require.Error(t, resp.Responses["A"].Error)
+	require.Empty(t, resp.Responses["A"].Frames)
+}
+
+func TestTagKeysAndValuesResources(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/datasets/_fields":
+			_, _ = w.Write([]byte(`[
+				{"datasetName":"web","fields":[{"name":"level","type":"string"},{"name":"host","type":"string"}]},
+				{"datasetName":"jobs","fields":[{"name":"queue","type":"string"}]}
+			]`))
+		case "/v1/datasets/web/fields":
+			_, _ = w.Write([]byte(`[{"name":"level","type":"string"},{"name":"host","type":"string"}]`))
+		case "/v1/query/_apl":
+			var request axiomapi.APLQueryRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			require.True(t, strings.HasPrefix(*request.APL, "['web']\n"), *request.APL)
+			_, _ = w.Write([]byte(`{"format":"tabular","tables":[{"name":"0","fields":[{"name":"_value","type":"string"},{"name":"count_","type":"integer"}],"columns":[["info","error"],[90,10]]}]}`))
+		default:
+			http.NotFound(w, r)
 		}
-	}
-	require.NotNil(t, seriesA)
-	require.NotNil(t, seriesA.Config)
-	require.Equal(t, "a", seriesA.Config.DisplayNameFromDS)
-	require.Equal(t, "ms", seriesA.Config.Unit)
+	}))
+	defer upstream.Close()
 
-	filledValue, ok := seriesA.At(1).(*float64)
-	require.True(t, ok)
-	require.Equal(t, float64(100), *filledValue)
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	handler := ds.newResourceHandler()
+
+	resp := callResource(t, handler, "/tag-keys")
+	require.Equal(t, http.StatusOK, resp.Status)
+	require.JSONEq(t, `["host","level","queue"]`, string(resp.Body))
+
+	resp = callResource(t, handler, "/tag-keys?dataset=web")
+	require.Equal(t, http.StatusOK, resp.Status)
+	require.JSONEq(t, `["host","level"]`, string(resp.Body))
+
+	// Without datasets, the datasets that have the field are searched.
+	resp = callResource(t, handler, "/tag-values?key=level")
+	require.Equal(t, http.StatusOK, resp.Status, string(resp.Body))
+	require.JSONEq(t, `["info","error"]`, string(resp.Body))
+
+	resp = callResource(t, handler, "/tag-values")
+	require.Equal(t, http.StatusBadRequest, resp.Status)
 }
 
-func TestMetricsFrameBuilderUsesTagValuesForSeriesName(t *testing.T) {
-	v1 := float64(0.1)
-	v2 := float64(0.2)
+func TestExpandMacros(t *testing.T) {
+	dataQuery := backend.DataQuery{
+		TimeRange: backend.TimeRange{
+			From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+		},
+		Interval: 30 * time.Second,
+	}
 
-	frame := newMetricsFrameBuilder(axiomapi.MetricsQueryMetadata{Unit: "{cpu}"}, "A").Build(
-		axiomapi.MetricsQuerySeries{
-			Resolution: 60,
-			Start:      1781186400,
-			Metric:     "k8s.pod.cpu.usage",
-			Tags: map[string]string{
-				"container.image.name": "602401143452.dkr.ecr.eu-west-1.amazonaws.com/eks/kube-proxy",
-				"container.name":       "api",
-				"pod.name":             "api-7d9",
-			},
-			Data: []*float64{&v1, &v2},
+	tests := []struct {
+		name    string
+		kind    string
+		query   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "apl",
+			kind:  "apl",
+			query: "['logs'] | where $__timeFilter(['event.time']) | summarize count() by $__bin() | extend ms = $__interval_ms, range = $__timeTo - $__timeFrom",
+			want:  "['logs'] | where (['event.time'] >= datetime(2024-01-01T00:00:00Z) and ['event.time'] <= datetime(2024-01-01T01:00:00Z)) | summarize count() by bin(_time, 30s) | extend ms = 30000, range = datetime(2024-01-01T01:00:00Z) - datetime(2024-01-01T00:00:00Z)",
 		},
-	)
+		{
+			name:  "mpl",
+			kind:  "mpl",
+			query: "metrics:cpu | align to $__interval using avg | where since > $__timeFrom",
+			want:  `metrics:cpu | align to 30s using avg | where since > "2024-01-01T00:00:00Z"`,
+		},
+		{
+			name:  "unknown macros are kept",
+			kind:  "apl",
+			query: "logs | where a == '$__intervalish' and b == $__rate_interval",
+			want:  "logs | where a == '$__intervalish' and b == $__rate_interval",
+		},
+		{
+			name:  "apl strings and comments are kept",
+			kind:  "apl",
+			query: "['logs'] // per $__interval\n| where msg == \"$__interval\" and ['$__timeFrom'] != 'at $__timeTo' | summarize count() by $__bin()",
+			want:  "['logs'] // per $__interval\n| where msg == \"$__interval\" and ['$__timeFrom'] != 'at $__timeTo' | summarize count() by bin(_time, 30s)",
+		},
+		{
+			name:  "mpl strings and names are kept",
+			kind:  "mpl",
+			query: "`$__interval`:cpu | where note == \"$__timeFrom\" | align to $__interval using avg // not $__timeTo",
+			want:  "`$__interval`:cpu | where note == \"$__timeFrom\" | align to 30s using avg // not $__timeTo",
+		},
+		{
+			name:    "apl only macro in mpl",
+			kind:    "mpl",
+			query:   "metrics:cpu | where $__timeFilter(_time)",
+			wantErr: "$__timeFilter is only supported in APL queries",
+		},
+	}
 
-	require.Equal(t, "A", frame.RefID)
-	require.Len(t, frame.Fields, 2)
-	require.NotNil(t, frame.Meta)
-	require.Equal(t, data.FrameTypeTimeSeriesMulti, frame.Meta.Type)
-	require.Equal(t, data.FrameTypeVersion{0, 1}, frame.Meta.TypeVersion)
-	require.Equal(t, "602401143452.dkr.ecr.eu-west-1.amazonaws.com/eks/kube-proxy | api | api-7d9", frame.Fields[1].Name)
-	require.NotNil(t, frame.Fields[0].Config)
-	require.Equal(t, float64(60000), frame.Fields[0].Config.Interval)
-	require.NotNil(t, frame.Fields[1].Config)
-	require.Equal(t, "", frame.Fields[1].Config.DisplayNameFromDS)
-	require.Equal(t, "api", frame.Fields[1].Labels["container.name"])
-	require.Equal(t, "{cpu}", frame.Fields[1].Config.Unit)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := expandMacros(test.kind, test.query, dataQuery)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
 }
 
-func TestMetricsFrameBuilderUsesExplicitLabelTagForSeriesName(t *testing.T) {
-	v1 := float64(0.1)
+func TestQueryDataExpandsMacrosWithoutTheFrontend(t *testing.T) {
+	var mpl axiomapi.MPLQueryRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&mpl))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"series":[{"metric":"cpu","start":0,"resolution":60,"data":[1,2]}]}`))
+	}))
+	defer upstream.Close()
 
-	frame := newMetricsFrameBuilder(axiomapi.MetricsQueryMetadata{Unit: "short"}, "A").Build(
-		axiomapi.MetricsQuerySeries{
-			Resolution: 60,
-			Start:      1781186400,
-			Metric:     "k8s.pod.cpu.usage",
-			Tags: map[string]string{
-				"__label":  "api-7d9",
-				"pod.name": "api-7d9",
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			RefID: "A",
+			JSON:  json.RawMessage(`{"kind":"mpl","query":"metrics:cpu | align to $__interval using avg"}`),
+			TimeRange: backend.TimeRange{
+				From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				To:   time.Date(2024, 1, 1, 4, 0, 0, 0, time.UTC),
 			},
-			Data: []*float64{&v1},
-		},
-	)
+			MaxDataPoints: 240,
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
 
-	require.Len(t, frame.Fields, 2)
-	require.Equal(t, "api-7d9", frame.Fields[1].Name)
-	require.NotNil(t, frame.Fields[1].Config)
-	require.Equal(t, "api-7d9", frame.Fields[1].Config.DisplayNameFromDS)
-	require.Equal(t, "api-7d9", frame.Fields[1].Labels["__label"])
-	require.Equal(t, "api-7d9", frame.Fields[1].Labels["pod.name"])
+	// Without an interval, the range is split into MaxDataPoints buckets.
+	require.Equal(t, "metrics:cpu | align to 1m using avg", *mpl.MPL)
+	require.Equal(t, "metrics:cpu | align to 1m using avg", resp.Responses["A"].Frames[0].Meta.ExecutedQueryString)
 }
 
-func TestMetricsFrameBuilderBuildsLabeledTableFrame(t *testing.T) {
-	cpuAPI := float64(0.2)
-	cpuAPIReplica := float64(0.4)
-	memAPI := float64(512)
-	cpuWorker := float64(0.8)
+func TestQueryDataMapsAnnotationColumns(t *testing.T) {
+	var apl string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req axiomapi.APLQueryRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		apl = *req.APL
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"format":"tabular",
+			"tables":[{
+				"fields":[{"name":"_sysTime","type":"datetime"},{"name":"_time","type":"datetime"},{"name":"finished","type":"datetime"},{"name":"service","type":"string"},{"name":"message","type":"string"},{"name":"labels","type":"array"},{"name":"env","type":"string"}],
+				"columns":[
+					["2024-01-01T00:00:05Z","2024-01-01T00:01:05Z",null],
+					["2024-01-01T00:00:00Z","2024-01-01T00:01:00Z",null],
+					["2024-01-01T00:00:30Z",null,null],
+					["api","worker","db"],
+					["deploy started","deploy failed","lost"],
+					[["deploy","v2"],null,null],
+					["prod","staging","prod"]
+				]
+			}]
+		}`))
+	}))
+	defer upstream.Close()
 
-	frame := newMetricsFrameBuilder(axiomapi.MetricsQueryMetadata{Unit: "short"}, "A").BuildTable(
-		[]axiomapi.MetricsQuerySeries{
-			{
-				Metric: "cpu",
-				Tags:   map[string]string{"__label": "api", "container.name": "app", "pod.name": "api-7d9"},
-				Data:   []*float64{nil, &cpuAPI},
-			},
-			{
-				Metric: "memory",
-				Tags:   map[string]string{"__label": "api", "container.name": "app", "pod.name": "api-7d9"},
-				Data:   []*float64{&memAPI},
-			},
-			{
-				Metric: "cpu",
-				Tags:   map[string]string{"__label": "api", "container.name": "sidecar", "pod.name": "api-8bc"},
-				Data:   []*float64{&cpuAPIReplica},
-			},
-			{
-				Metric: "cpu",
-				Tags:   map[string]string{"__label": "worker", "container.name": "app", "pod.name": "worker-5f8"},
-				Data:   []*float64{&cpuWorker},
-			},
-			{
-				Metric: "memory",
-				Tags:   map[string]string{"__label": "worker", "container.name": "app", "pod.name": "worker-5f8"},
-				Data:   []*float64{nil},
-			},
-		},
-	)
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	query := func(model string) backend.DataResponse {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{
+				RefID:     "Anno",
+				QueryType: annotationsQueryType,
+				JSON:      json.RawMessage(model),
+				TimeRange: backend.TimeRange{
+					From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					To:   time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+				},
+			}},
+		})
+		require.NoError(t, err)
+		return resp.Responses["Anno"]
+	}
 
-	require.Equal(t, "A", frame.RefID)
-	require.NotNil(t, frame.Meta)
-	require.EqualValues(t, data.VisTypeTable, frame.Meta.PreferredVisualization)
-	require.Len(t, frame.Fields, 5)
-	require.Equal(t, "__label", frame.Fields[0].Name)
-	require.Equal(t, "container.name", frame.Fields[1].Name)
-	require.Equal(t, "pod.name", frame.Fields[2].Name)
-	require.Equal(t, "cpu", frame.Fields[3].Name)
-	require.Equal(t, "memory", frame.Fields[4].Name)
-	require.Equal(t, "api", *frame.Fields[0].At(0).(*string))
-	require.Equal(t, "api", *frame.Fields[0].At(1).(*string))
-	require.Equal(t, "worker", *frame.Fields[0].At(2).(*string))
-	require.Equal(t, "app", *frame.Fields[1].At(0).(*string))
-	require.Equal(t, "sidecar", *frame.Fields[1].At(1).(*string))
-	require.Equal(t, "app", *frame.Fields[1].At(2).(*string))
-	require.Equal(t, "api-7d9", *frame.Fields[2].At(0).(*string))
-	require.Equal(t, "api-8bc", *frame.Fields[2].At(1).(*string))
-	require.Equal(t, "worker-5f8", *frame.Fields[2].At(2).(*string))
-	require.Equal(t, cpuAPI, *frame.Fields[3].At(0).(*float64))
-	require.Equal(t, cpuAPIReplica, *frame.Fields[3].At(1).(*float64))
-	require.Equal(t, cpuWorker, *frame.Fields[3].At(2).(*float64))
-	require.Equal(t, memAPI, *frame.Fields[4].At(0).(*float64))
-	require.Nil(t, frame.Fields[4].At(1))
-	require.Nil(t, frame.Fields[4].At(2))
-	require.NotNil(t, frame.Fields[3].Config)
-	require.Equal(t, "short", frame.Fields[3].Config.Unit)
+	// By default, _time wins over _sysTime and the message becomes the text.
+	// The row without a time is left out.
+	resp := query(`{"query":"['deploys'] | where $__timeFilter()"}`)
+	require.NoError(t, resp.Error)
+	require.Contains(t, apl, "_time >= datetime(")
+	require.Len(t, resp.Frames, 1)
+	frame := resp.Frames[0]
+	require.Equal(t, "Anno", frame.RefID)
+	require.Equal(t, 2, frame.Rows())
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), frame.Fields[0].At(0))
+	require.Nil(t, frame.Fields[1].At(0))
+	require.Equal(t, []any{"", "deploy started", ""}, []any{frame.Fields[2].At(0), frame.Fields[3].At(0), frame.Fields[4].At(0)})
+	require.Equal(t, "deploy failed", frame.Fields[3].At(1))
+
+	resp = query(`{"query":"['deploys']","annotation":{"time":"_sysTime","timeEnd":"finished","title":"service","text":"message","tags":["labels","env"]}}`)
+	require.NoError(t, resp.Error)
+	frame = resp.Frames[0]
+	require.Equal(t, 2, frame.Rows())
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 5, 0, time.UTC), frame.Fields[0].At(0))
+	end := time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC)
+	require.Equal(t, &end, frame.Fields[1].At(0))
+	require.Nil(t, frame.Fields[1].At(1))
+	require.Equal(t, "api", frame.Fields[2].At(0))
+	require.Equal(t, "deploy,v2,prod", frame.Fields[4].At(0))
+	require.Equal(t, "staging", frame.Fields[4].At(1))
+
+	resp = query(`{"query":"['deploys']","annotation":{"title":"missing"}}`)
+	require.Equal(t, backend.StatusBadRequest, resp.Status)
+	require.ErrorContains(t, resp.Error, `annotation title column "missing"`)
+
+	resp = query(`{"kind":"mpl","query":"metrics:cpu"}`)
+	require.Equal(t, backend.StatusBadRequest, resp.Status)
 }
 
-func TestMetricsFrameBuilderSetsDisplayNameFromRefIDWhenTagsAreEmpty(t *testing.T) {
-	v1 := float64(0.1)
+type streamPackets chan *backend.StreamPacket
 
-	frame := newMetricsFrameBuilder(axiomapi.MetricsQueryMetadata{}, "A").Build(
-		axiomapi.MetricsQuerySeries{
-			Resolution: 60,
-			Start:      1781186400,
-			Metric:     "k8s.pod.cpu.usage",
-			Data:       []*float64{&v1},
-		},
-	)
+func (p streamPackets) Send(packet *backend.StreamPacket) error {
+	p <- packet
+	return nil
+}
 
-	require.Len(t, frame.Fields, 2)
-	require.Equal(t, "k8s.pod.cpu.usage", frame.Fields[1].Name)
-	require.NotNil(t, frame.Fields[1].Config)
-	require.Empty(t, frame.Fields[1].Labels)
-	require.Equal(t, "A", frame.Fields[1].Config.DisplayNameFromDS)
+func receiveStreamFrame(t *testing.T, packets streamPackets) *data.Frame {
+	t.Helper()
+	select {
+	case packet := <-packets:
+		var frame data.Frame
+		require.NoError(t, frame.UnmarshalJSON(packet.Data))
+		return &frame
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a live tail frame")
+		return nil
+	}
+}
+
+func TestRunStreamTailsNewEventsOnce(t *testing.T) {
+	start := time.Now().Add(time.Minute).UTC().Truncate(time.Second)
+	at := func(seconds int) string {
+		return start.Add(time.Duration(seconds) * time.Second).Format(time.RFC3339Nano)
+	}
+	responses := []string{
+		fmt.Sprintf(`[["%s","%s"],["b","a"],["second","first"]]`, at(2), at(1)),
+		fmt.Sprintf(`[["%s","%s","%s"],["d","c","b"],["fourth","third","second"]]`, at(3), at(2), at(2)),
+	}
+
+	var mu sync.Mutex
+	var requests []axiomapi.APLQueryRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req axiomapi.APLQueryRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		requests = append(requests, req)
+		columns := responses[min(len(requests), len(responses))-1]
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"format":"tabular","tables":[{"fields":[{"name":"_time","type":"datetime"},{"name":"_id","type":"string"},{"name":"message","type":"string"}],"columns":%s}]}`, columns)
+	}))
+	defer upstream.Close()
+
+	ds := &Datasource{
+		api:                  newTestAxiomClient(t, upstream.URL, upstream.URL),
+		liveTailPollInterval: 10 * time.Millisecond,
+		liveTailMaxRows:      3,
+	}
+	subscribed, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+		Path: "tail/abc",
+		Data: json.RawMessage(`{"query":"['logs']"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, backend.SubscribeStreamStatusOK, subscribed.Status)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	packets := make(streamPackets, 10)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- ds.RunStream(ctx, &backend.RunStreamRequest{
+			Path: "tail/abc",
+			Data: json.RawMessage(`{"query":"['logs']","adhocFilters":[{"key":"service","operator":"=","value":"api"}]}`),
+		}, backend.NewStreamSender(packets))
+	}()
+
+	first := receiveStreamFrame(t, packets)
+	require.Equal(t, data.FrameTypeLogLines, first.Meta.Type)
+	require.Equal(t, []any{"a", "b"}, []any{first.Fields[3].At(0), first.Fields[3].At(1)})
+	require.Empty(t, first.Meta.Notices)
+
+	// "b" shares the watermark and was sent already; the full page is
+	// reported.
+	second := receiveStreamFrame(t, packets)
+	require.Equal(t, 2, second.Rows())
+	require.Equal(t, []any{"c", "d"}, []any{second.Fields[3].At(0), second.Fields[3].At(1)})
+	require.Len(t, second.Meta.Notices, 1)
+
+	// Later polls return "d" again and send nothing.
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests) >= 4
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-stopped)
+	require.Empty(t, packets)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Contains(t, *requests[0].APL, "(['logs'] | where tostring(['service']) == 'api')")
+	require.Contains(t, *requests[0].APL, "| take 3")
+	require.True(t, requests[2].StartTime.Equal(start.Add(3*time.Second)))
+}
+
+func TestDisposeStopsLiveTails(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"format":"tabular","tables":[]}`))
+	}))
+	defer upstream.Close()
+
+	ds := &Datasource{
+		api:                  newTestAxiomClient(t, upstream.URL, upstream.URL),
+		liveTailPollInterval: 10 * time.Millisecond,
+		liveTailMaxRows:      10,
+	}
+	req := &backend.RunStreamRequest{Path: "tail/abc", Data: json.RawMessage(`{"query":"['logs']"}`)}
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- ds.RunStream(context.Background(), req, backend.NewStreamSender(make(streamPackets, 1)))
+	}()
+
+	require.Eventually(t, func() bool {
+		ds.tails.mu.Lock()
+		defer ds.tails.mu.Unlock()
+		return len(ds.tails.cancels) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	ds.Dispose()
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("live tail did not stop on Dispose")
+	}
+
+	// Tails started after Dispose return right away.
+	require.NoError(t, ds.RunStream(context.Background(), req, backend.NewStreamSender(make(streamPackets, 1))))
+
+	response, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "other"})
+	require.NoError(t, err)
+	require.Equal(t, backend.SubscribeStreamStatusNotFound, response.Status)
+}
+
+func TestQueryDataReturnsNumericLongFramesForAlerting(t *testing.T) {
+	response := `{"format":"tabular","tables":[{"name":"0","fields":[{"name":"service","type":"string"},{"name":"ok","type":"bool"},{"name":"count_","type":"integer"}],"columns":[["api","api","worker"],[true,false,null],[3,5,7]]}]}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	defer upstream.Close()
+
+	ds := Datasource{api: newTestAxiomClient(t, upstream.URL, upstream.URL)}
+	query := func(headers map[string]string) *data.Frame {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			Headers: headers,
+			Queries: []backend.DataQuery{{
+				RefID: "A",
+				JSON:  json.RawMessage(`{"query":"['logs'] | summarize count() by service, ok"}`),
+				TimeRange: backend.TimeRange{
+					From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					To:   time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+				},
+			}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+		require.Len(t, resp.Responses["A"].Frames, 1)
+		return resp.Responses["A"].Frames[0]
+	}
+
+	frame := query(map[string]string{"FromAlert": "true"})
+	require.Equal(t, data.FrameTypeNumericLong, frame.Meta.Type)
+	require.Equal(t, "['logs'] | summarize count() by service, ok", frame.Meta.ExecutedQueryString)
+	require.Len(t, frame.Fields, 3)
+	require.Equal(t, "service", frame.Fields[0].Name)
+	require.Equal(t, "count_", frame.Fields[2].Name)
+	require.Equal(t, 3, frame.Rows())
+	// Bools become labels too, so groups that only differ by them stay apart.
+	require.Equal(t, "ok", frame.Fields[1].Name)
+	require.Equal(t, data.FieldTypeNullableString, frame.Fields[1].Type())
+	ok, _ := frame.Fields[1].ConcreteAt(0)
+	require.Equal(t, "true", ok)
+	ok, _ = frame.Fields[1].ConcreteAt(1)
+	require.Equal(t, "false", ok)
+	_, isSet := frame.Fields[1].ConcreteAt(2)
+	require.False(t, isSet)
+
+	frame = query(map[string]string{"http_X-Grafana-From-Expr": "true"})
+	require.Equal(t, data.FrameTypeNumericLong, frame.Meta.Type)
+
+	// Dashboards keep the table.
+	frame = query(nil)
+	require.NotEqual(t, data.FrameTypeNumericLong, frame.Meta.Type)
+	require.Len(t, frame.Fields, 3)
+
+	// Results with a time column already suit alerting.
+	response = `{"format":"tabular","tables":[{"name":"0","fields":[{"name":"_time","type":"datetime"},{"name":"count_","type":"integer"}],"columns":[["2024-01-01T00:00:00Z"],[3]]}]}`
+	frame = query(map[string]string{"FromAlert": "true"})
+	require.NotEqual(t, data.FrameTypeNumericLong, frame.Meta.Type)
 }
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// queryErrorResponse converts a failed query into a DataResponse whose status
+// and error source let Grafana tell Axiom-side failures from plugin bugs.
+func queryErrorResponse(err error) backend.DataResponse {
+	status, source := queryErrorStatus(err)
+	return backend.ErrDataResponseWithSource(status, source, fmt.Sprintf("axiom error: %v", err.Error()))
+}
+
+func queryErrorStatus(err error) (backend.Status, backend.ErrorSource) {
+	var apiErr *axiomapi.APIError
+	if errors.As(err, &apiErr) {
+		return apiErrorStatus(apiErr.StatusCode), backend.ErrorSourceDownstream
+	}
+	var tooLargeErr *axiomapi.ResponseTooLargeError
+	if errors.As(err, &tooLargeErr) {
+		return backend.StatusBadRequest, backend.ErrorSourceDownstream
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return backend.StatusTimeout, backend.ErrorSourceDownstream
+	case errors.Is(err, context.Canceled):
+		return backend.StatusBadRequest, backend.ErrorSourceDownstream
+	case backend.IsDownstreamHTTPError(err):
+		return backend.StatusBadGateway, backend.ErrorSourceDownstream
+	default:
+		return backend.StatusInternal, backend.ErrorSourcePlugin
+	}
+}
+
+func apiErrorStatus(statusCode int) backend.Status {
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return backend.StatusUnauthorized
+	case http.StatusForbidden:
+		return backend.StatusForbidden
+	case http.StatusNotFound:
+		return backend.StatusNotFound
+	case http.StatusUnprocessableEntity:
+		return backend.StatusValidationFailed
+	case http.StatusTooManyRequests:
+		return backend.StatusTooManyRequests
+	case http.StatusGatewayTimeout:
+		return backend.StatusTimeout
+	}
+
+	if statusCode >= http.StatusInternalServerError {
+		return backend.StatusBadGateway
+	}
+
+	return backend.StatusBadRequest
+}
+
+func apiErrorTraceID(err error) string {
+	var apiErr *axiomapi.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.TraceID
+	}
+	return ""
+}
@@ -0,0 +1,199 @@
+package plugin
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	defaultFieldValuesLimit = 20
+	maxFieldValuesLimit     = 100
+	// defaultFieldValuesRange is searched when the request has no time range.
+	defaultFieldValuesRange = time.Hour
+	// fieldValuesTimeout keeps a slow summarize from holding up the editor.
+	fieldValuesTimeout = 10 * time.Second
+	// fieldValuesCacheTTL and fieldValuesCacheMaxBytes bound the cache of
+	// suggestions. Its keys use the time range rounded to the minute, so the
+	// suggestions of a dashboard stay the same while it is edited.
+	fieldValuesCacheTTL      = time.Minute
+	fieldValuesCacheMaxBytes = 4 << 20
+)
+
+// fieldValue is a value of a field with the number of events that have it.
+type fieldValue struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// newFieldValuesCache returns the cache of field value suggestions. Editors
+// ask for them on every keystroke, so it is kept even when the result cache
+// is disabled.
+func newFieldValuesCache() *resultCache {
+	return &resultCache{
+		ttl:      fieldValuesCacheTTL,
+		maxBytes: fieldValuesCacheMaxBytes,
+		now:      time.Now,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// handleFieldValues returns the most frequent values of a field between the
+// start and end parameters, for APL autocomplete. The optional prefix
+// parameter keeps values starting with it, ignoring case, and limit caps the
+// number of values.
+func (d *Datasource) handleFieldValues(w http.ResponseWriter, r *http.Request) {
+	logger := log.DefaultLogger.FromContext(r.Context())
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dataset := r.PathValue("dataset")
+	field := r.PathValue("field")
+	params := r.URL.Query()
+
+	timeRange, err := fieldValuesTimeRange(params.Get("start"), params.Get("end"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultFieldValuesLimit
+	if value := params.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", value), http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxFieldValuesLimit)
+	}
+
+	values, err := d.fieldValues(r.Context(), dataset, field, params.Get("prefix"), limit, timeRange)
+	if err != nil {
+		var apiErr *axiomapi.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			http.Error(w, "Dataset not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("fetching field values failed", "dataset", dataset, "field", field, "error", err.Error())
+		http.Error(w, "Failed to fetch field values", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, logger, values)
+}
+
+// fieldValuesTimeRange parses the RFC 3339 start and end parameters. A
+// missing start searches the hour before end, and a missing end means now.
+func fieldValuesTimeRange(start, end string, now time.Time) (backend.TimeRange, error) {
+	timeRange := backend.TimeRange{To: now}
+	if end != "" {
+		to, err := time.Parse(time.RFC3339Nano, end)
+		if err != nil {
+			return backend.TimeRange{}, fmt.Errorf("invalid end %q: use an RFC 3339 time", end)
+		}
+		timeRange.To = to
+	}
+	timeRange.From = timeRange.To.Add(-defaultFieldValuesRange)
+	if start != "" {
+		from, err := time.Parse(time.RFC3339Nano, start)
+		if err != nil {
+			return backend.TimeRange{}, fmt.Errorf("invalid start %q: use an RFC 3339 time", start)
+		}
+		timeRange.From = from
+	}
+	if !timeRange.From.Before(timeRange.To) {
+		return backend.TimeRange{}, errors.New("start must be before end")
+	}
+
+	return alignTimeRange(timeRange, time.Minute), nil
+}
+
+// fieldValues runs the top-k summarize behind handleFieldValues. Identical
+// lookups share one call to Axiom and its cached result.
+func (d *Datasource) fieldValues(ctx context.Context, dataset, field, prefix string, limit int, timeRange backend.TimeRange) ([]fieldValue, error) {
+	apl := fieldValuesAPL(dataset, field, prefix, limit)
+	q := &queryModel{useCache: true, timeout: fieldValuesTimeout}
+	key := resultCacheKey("field-values", apl, timeRange.From, timeRange.To)
+
+	values, _, err := sharedQuery(ctx, d, d.fieldValuesCache, q, key, func(ctx context.Context) ([]fieldValue, int64, error) {
+		result, err := d.api.QueryAPL(ctx, axiomapi.APLQueryRequest{
+			APL:       &apl,
+			StartTime: timeRange.From,
+			EndTime:   timeRange.To,
+			Timeout:   fieldValuesTimeout,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		return fieldValuesFromResult(result)
+	}, readOnlyResult[[]fieldValue])
+
+	return values, err
+}
+
+// fieldValuesAPL counts the values of field in dataset and keeps the limit
+// most frequent ones. Values are compared as strings so fields of any type
+// can be suggested.
+func fieldValuesAPL(dataset, field, prefix string, limit int) string {
+	value := "tostring(" + aplQuotedIdentifier(field) + ")"
+
+	var b strings.Builder
+	b.WriteString(aplQuotedIdentifier(dataset))
+	b.WriteString("\n| where isnotempty(" + value + ")")
+	if prefix != "" {
+		b.WriteString("\n| where " + value + " startswith " + aplStringLiteral(prefix))
+	}
+	b.WriteString("\n| summarize count_ = count() by _value = " + value)
+	fmt.Fprintf(&b, "\n| top %d by count_ desc", limit)
+
+	return b.String()
+}
+
+// fieldValuesFromResult reads the rows of fieldValuesAPL's result, most
+// frequent first.
+func fieldValuesFromResult(result axiomapi.APLQueryResponse) ([]fieldValue, int64, error) {
+	values := []fieldValue{}
+	if len(result.Tables) == 0 {
+		return values, 0, nil
+	}
+
+	table := result.Tables[0]
+	valueIndex, countIndex := -1, -1
+	for i, field := range table.Fields {
+		switch field.Name {
+		case "_value":
+			valueIndex = i
+		case "count_":
+			countIndex = i
+		}
+	}
+	if valueIndex < 0 || countIndex < 0 || valueIndex >= len(table.Columns) || countIndex >= len(table.Columns) {
+		return nil, 0, errors.New("field values result is missing the _value or count_ column")
+	}
+
+	var size int64
+	valueColumn, countColumn := table.Columns[valueIndex], table.Columns[countIndex]
+	for row := 0; row < len(valueColumn) && row < len(countColumn); row++ {
+		value, ok := valueColumn[row].(string)
+		if !ok {
+			continue
+		}
+		count, _ := countColumn[row].(float64)
+		values = append(values, fieldValue{Value: value, Count: int64(count)})
+		size += int64(len(value)) + 16
+	}
+
+	return values, size, nil
+}
@@ -0,0 +1,156 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-grafana/pkg/config"
+	"github.com/axiomhq/axiom-grafana/pkg/util"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryLimiter throttles the queries a datasource instance sends to Axiom. It
+// bounds how many run at once across all Grafana requests and how many start
+// per second, so a large dashboard cannot exhaust the org's Axiom rate limit.
+type queryLimiter struct {
+	// slots holds one token per running query. It is nil when the number of
+	// in-flight queries is not limited.
+	slots  chan struct{}
+	bucket *tokenBucket
+}
+
+// newQueryLimiter returns nil when neither limit is configured.
+func newQueryLimiter(c *config.PluginConfig) *queryLimiter {
+	if c.MaxInflightQueries <= 0 && c.QueryRateLimit <= 0 {
+		return nil
+	}
+
+	limiter := &queryLimiter{}
+	if c.MaxInflightQueries > 0 {
+		limiter.slots = make(chan struct{}, c.MaxInflightQueries)
+	}
+	if c.QueryRateLimit > 0 {
+		limiter.bucket = newTokenBucket(c.QueryRateLimit, c.QueryRateBurst, time.Now)
+	}
+
+	return limiter
+}
+
+// acquire blocks until a query may be sent. It returns how long the query was
+// queued and a release function that must be called once the query is done.
+func (l *queryLimiter) acquire(ctx context.Context) (release func(), queued time.Duration, err error) {
+	start := time.Now()
+	release = func() {}
+
+	if l.bucket != nil {
+		if err := l.bucket.wait(ctx); err != nil {
+			return nil, time.Since(start), err
+		}
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+			release = func() { <-l.slots }
+		case <-ctx.Done():
+			return nil, time.Since(start), fmt.Errorf("waiting for a free query slot: %w", ctx.Err())
+		}
+	}
+
+	return release, time.Since(start), nil
+}
+
+// tokenBucket is a token bucket rate limiter. Tokens accrue at rate per
+// second up to burst, and each query takes one.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now func() time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		now:    now,
+		sleep:  util.SleepContext,
+		tokens: float64(burst),
+		last:   now(),
+	}
+}
+
+// wait takes a token, sleeping until one is available. It fails right away
+// when the token would only become available after ctx's deadline.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	delay := b.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && b.now().Add(delay).After(deadline) {
+		b.cancel()
+		return fmt.Errorf("waiting %s for the query rate limit: %w", delay.Round(time.Millisecond), context.DeadlineExceeded)
+	}
+
+	if err := b.sleep(ctx, delay); err != nil {
+		b.cancel()
+		return fmt.Errorf("waiting for the query rate limit: %w", err)
+	}
+
+	return nil
+}
+
+// reserve takes a token and returns how long the caller has to wait before
+// using it. Tokens may go negative, which queues callers in arrival order.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a reserved token that was not used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+func applyQueueTime(frames []*data.Frame, queued time.Duration) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Stats = append(frame.Meta.Stats, data.QueryStat{
+			FieldConfig: data.FieldConfig{DisplayName: "Queue time", Unit: "ms"},
+			Value:       float64(queued) / float64(time.Millisecond),
+		})
+	}
+}
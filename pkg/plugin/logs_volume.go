@@ -3,7 +3,6 @@ package plugin
 import (
 	"context"
 	"fmt"
-	"math"
 	"strings"
 	"time"
 
@@ -26,24 +25,31 @@ func isLogsVolumeQuery(query backend.DataQuery, model *queryModel) bool {
 }
 
 func (d *Datasource) queryLogsVolume(ctx context.Context, q *queryModel, query backend.DataQuery, datasourceName string) (*backend.DataResponse, error) {
-	apl := logsVolumeAPL(*q.Query, logsVolumeInterval(query))
+	interval := queryInterval(query)
+	apl := logsVolumeAPL(*q.Query, interval)
 	reqBody := axiomapi.APLQueryRequest{
 		APL:       &apl,
 		StartTime: query.TimeRange.From,
 		EndTime:   query.TimeRange.To,
+		Timeout:   q.timeout,
 	}
 
-	result, err := d.api.QueryAPL(ctx, reqBody)
+	result, runStats, err := d.queryAPLTableCached(ctx, q, logsVolumeQueryType, reqBody, interval.String())
 	if err != nil {
 		return nil, err
 	}
 
+	_, span := startSpan(ctx, "axiom.buildFrames", axiomapi.AttributeQueryKind.String("logs-volume"))
 	frame, err := newLogsVolumeFrameBuilder(query, datasourceName, *q.Query).Build(result)
 	if err != nil {
+		endFramesSpan(span, nil, err)
 		return nil, err
 	}
 	applyAxiomTraceID(frame, result.TraceID)
 
+	applyQueryRunStats([]*data.Frame{frame}, runStats)
+	endFramesSpan(span, []*data.Frame{frame}, nil)
+
 	var response backend.DataResponse
 	response.Frames = append(response.Frames, frame)
 	return &response, nil
@@ -60,28 +66,6 @@ func logsVolumeAPL(query string, interval time.Duration) string {
 | order by _time asc`, sourceQuery, aplDurationLiteral(interval))
 }
 
-func logsVolumeInterval(query backend.DataQuery) time.Duration {
-	if query.Interval > 0 {
-		return query.Interval
-	}
-
-	timeRange := query.TimeRange.To.Sub(query.TimeRange.From)
-	if timeRange <= 0 {
-		return time.Minute
-	}
-
-	maxDataPoints := query.MaxDataPoints
-	if maxDataPoints <= 0 {
-		maxDataPoints = 240
-	}
-
-	interval := time.Duration(math.Ceil(float64(timeRange) / float64(maxDataPoints)))
-	if interval < time.Second {
-		return time.Second
-	}
-	return interval
-}
-
 func aplDurationLiteral(duration time.Duration) string {
 	if duration < time.Second {
 		duration = time.Second
@@ -0,0 +1,127 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// macroPattern matches the Grafana macros expanded by expandMacros:
+//
+//	$__timeFilter(field)  field >= start and field <= end of the time range (APL)
+//	$__bin(field)         bin(field, $__interval) (APL)
+//	$__timeFrom           start of the time range
+//	$__timeTo             end of the time range
+//	$__interval           interval of the query as a duration, such as 30s
+//	$__interval_ms        interval of the query in milliseconds
+//
+// The field of $__timeFilter and $__bin defaults to _time. Macros in string
+// literals, quoted names and comments are left as they are.
+var macroPattern = regexp.MustCompile(`\$__(timeFilter|bin)\(([^()]*)\)|\$__(interval_ms|interval|timeFrom|timeTo)\b`)
+
+// expandMacros replaces the macros in query with values from the query's
+// time range and interval. The frontend expands the variables Grafana knows
+// itself, but queries from alerting and recorded queries arrive as saved.
+func expandMacros(kind, query string, dataQuery backend.DataQuery) (string, error) {
+	from, to := dataQuery.TimeRange.From.UTC(), dataQuery.TimeRange.To.UTC()
+	interval := queryInterval(dataQuery)
+
+	var err error
+	expand := func(match string) string {
+		groups := macroPattern.FindStringSubmatch(match)
+		name := groups[1] + groups[3]
+		field := strings.TrimSpace(groups[2])
+		if field == "" {
+			field = "_time"
+		}
+
+		switch name {
+		case "timeFilter", "bin":
+			if kind == "mpl" && err == nil {
+				err = fmt.Errorf("$__%s is only supported in APL queries", name)
+			}
+			if name == "bin" {
+				return "bin(" + field + ", " + aplDurationLiteral(interval) + ")"
+			}
+			return "(" + field + " >= " + aplDatetimeLiteral(from) + " and " + field + " <= " + aplDatetimeLiteral(to) + ")"
+		case "timeFrom", "timeTo":
+			t := from
+			if name == "timeTo" {
+				t = to
+			}
+			if kind == "mpl" {
+				return `"` + t.Format(time.RFC3339Nano) + `"`
+			}
+			return aplDatetimeLiteral(t)
+		case "interval_ms":
+			return strconv.FormatInt(interval.Milliseconds(), 10)
+		default:
+			return aplDurationLiteral(interval)
+		}
+	}
+
+	literals := axiomapi.APLLiteralRanges(query)
+	if kind == "mpl" {
+		literals = axiomapi.MPLLiteralRanges(query)
+	}
+	var expanded strings.Builder
+	last := 0
+	for _, match := range macroPattern.FindAllStringIndex(query, -1) {
+		if inRanges(literals, match[0]) {
+			continue
+		}
+		expanded.WriteString(query[last:match[0]])
+		expanded.WriteString(expand(query[match[0]:match[1]]))
+		last = match[1]
+	}
+	expanded.WriteString(query[last:])
+	if err != nil {
+		return "", err
+	}
+
+	return expanded.String(), nil
+}
+
+// inRanges reports whether offset lies in one of ranges.
+func inRanges(ranges [][2]int, offset int) bool {
+	for _, r := range ranges {
+		if offset >= r[0] && offset < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func aplDatetimeLiteral(t time.Time) string {
+	return "datetime(" + t.Format(time.RFC3339Nano) + ")"
+}
+
+// queryInterval is the interval Grafana chose for the query, or the time
+// range divided into MaxDataPoints buckets when it sent none.
+func queryInterval(query backend.DataQuery) time.Duration {
+	if query.Interval > 0 {
+		return query.Interval
+	}
+
+	timeRange := query.TimeRange.To.Sub(query.TimeRange.From)
+	if timeRange <= 0 {
+		return time.Minute
+	}
+
+	maxDataPoints := query.MaxDataPoints
+	if maxDataPoints <= 0 {
+		maxDataPoints = 240
+	}
+
+	interval := time.Duration(math.Ceil(float64(timeRange) / float64(maxDataPoints)))
+	if interval < time.Second {
+		return time.Second
+	}
+	return interval
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "axiom",
+		Subsystem: "datasource",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of queries by kind and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"kind", "outcome"})
+	queryBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "axiom",
+		Subsystem: "datasource",
+		Name:      "query_response_bytes_total",
+		Help:      "Bytes of Axiom results decoded by queries, by kind.",
+	}, []string{"kind"})
+	queryFrames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "axiom",
+		Subsystem: "datasource",
+		Name:      "query_frames_total",
+		Help:      "Data frames built for queries, by kind.",
+	}, []string{"kind"})
+	resourceRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "axiom",
+		Subsystem: "datasource",
+		Name:      "resource_request_duration_seconds",
+		Help:      "Duration of resource requests by endpoint and HTTP status class.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"endpoint", "status_class"})
+)
+
+func init() {
+	// Datasource does not implement backend.CollectMetricsHandler:
+	// datasource.Manage never hands metrics requests to instances and instead
+	// answers them from prometheus.DefaultGatherer, next to the SDK's own
+	// metrics. Registering here is what makes these scrapeable.
+	prometheus.DefaultRegisterer.MustRegister(queryDuration, queryBytes, queryFrames, resourceRequestDuration)
+	prometheus.DefaultRegisterer.MustRegister(axiomapi.Collectors()...)
+}
+
+// observeQuery records a finished query.
+func observeQuery(kind string, start time.Time, response backend.DataResponse, fetchedBytes int64) {
+	queryDuration.WithLabelValues(kind, queryOutcome(response)).Observe(time.Since(start).Seconds())
+	queryBytes.WithLabelValues(kind).Add(float64(fetchedBytes))
+	queryFrames.WithLabelValues(kind).Add(float64(len(response.Frames)))
+}
+
+func queryOutcome(response backend.DataResponse) string {
+	switch {
+	case response.Error == nil:
+		return "success"
+	case response.Status == backend.StatusTimeout:
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// instrumentResources records the duration and status of every resource
+// request by the pattern that served it.
+func instrumentResources(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(recorder, r)
+
+		// The mux stores the matched pattern on the request.
+		endpoint := r.Pattern
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+		resourceRequestDuration.WithLabelValues(endpoint, axiomapi.StatusClass(recorder.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Headers Grafana sets on the queries it runs for alert rules and for
+// server-side expressions.
+const (
+	fromAlertHeader      = "FromAlert"
+	fromExpressionHeader = "X-Grafana-From-Expr"
+)
+
+// isAlertingRequest reports whether a request comes from alerting or an
+// expression. Its headers may also arrive forwarded, with an http_ prefix.
+func isAlertingRequest(headers map[string]string) bool {
+	for key, value := range headers {
+		key = strings.TrimPrefix(key, "http_")
+		if (strings.EqualFold(key, fromAlertHeader) || strings.EqualFold(key, fromExpressionHeader)) && strings.EqualFold(value, "true") {
+			return true
+		}
+	}
+	return false
+}
+
+// numericLongFrames turns the frames of results without a time column into
+// dataplane numeric-long frames, so alerting and expressions get one
+// labelled number per group: number columns become values and every other
+// column a label, with bools and other values written out as strings so
+// that groups stay apart. Frames with a time column, or without a number
+// column, are left as they are.
+func numericLongFrames(frames []*data.Frame) []*data.Frame {
+	converted := make([]*data.Frame, len(frames))
+	for i, frame := range frames {
+		converted[i] = numericLongFrame(frame)
+	}
+	return converted
+}
+
+func numericLongFrame(frame *data.Frame) *data.Frame {
+	var fields []*data.Field
+	hasNumber := false
+	for _, field := range frame.Fields {
+		switch fieldType := field.Type(); {
+		case fieldType.Time():
+			return frame
+		case fieldType.Numeric():
+			hasNumber = true
+			fields = append(fields, field)
+		case fieldType.NonNullableType() == data.FieldTypeString:
+			fields = append(fields, field)
+		default:
+			fields = append(fields, stringLabelField(field))
+		}
+	}
+	if !hasNumber {
+		return frame
+	}
+
+	long := data.NewFrame(frame.Name, fields...)
+	long.RefID = frame.RefID
+	long.Meta = cloneFrameMeta(frame.Meta)
+	if long.Meta == nil {
+		long.Meta = &data.FrameMeta{}
+	}
+	long.Meta.Type = data.FrameTypeNumericLong
+	long.Meta.TypeVersion = data.FrameTypeVersion{0, 1}
+	return long
+}
+
+// stringLabelField copies field as strings, keeping nulls, so it can become
+// a label of a numeric-long frame.
+func stringLabelField(field *data.Field) *data.Field {
+	labels := data.NewFieldFromFieldType(data.FieldTypeNullableString, field.Len())
+	labels.Name = field.Name
+	labels.Labels = field.Labels.Copy()
+	labels.Config = field.Config
+	for i := 0; i < field.Len(); i++ {
+		if value, ok := field.ConcreteAt(i); ok {
+			text := stringifyFrameValue(value)
+			labels.Set(i, &text)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,24 @@
+package plugin
+
+import (
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// applyNextCursor stores the cursor of the next page of raw events in the
+// frame meta as nextCursor. A query asks for that page by setting cursor in
+// its model; Explore does not do this yet and pages logs by time range.
+// Pages the plugin truncated get no cursor, since continuing after Axiom's
+// last row would skip the rows that were dropped.
+func applyNextCursor(frames []*data.Frame, result axiomapi.APLQueryResponse, truncated bool) {
+	cursor := result.NextCursor()
+	if cursor == "" || truncated || isAggregatedAPLResult(result) {
+		return
+	}
+
+	for _, frame := range frames {
+		if frame.Rows() > 0 {
+			setFrameMetaCustom(frame, "nextCursor", cursor)
+		}
+	}
+}
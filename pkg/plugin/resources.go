@@ -14,26 +14,19 @@ func (d *Datasource) newResourceHandler() backend.CallResourceHandler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/schema-lookup", d.handleSchemaLookup)
 	mux.HandleFunc("/metricsdatasets", d.HandleMetricsDatasets)
+	mux.HandleFunc("/validate", d.handleValidate)
+	mux.HandleFunc("/tag-keys", d.handleTagKeys)
+	mux.HandleFunc("/tag-values", d.handleTagValues)
+	mux.HandleFunc("/datasets", d.handleDatasets)
+	mux.HandleFunc("/datasets/{dataset}/fields", d.handleDatasetFields)
+	mux.HandleFunc("/datasets/{dataset}/fields/{field}/values", d.handleFieldValues)
 	mux.HandleFunc("/datasets/{dataset}/metrics", d.handleDatasetMetrics)
 	mux.HandleFunc("/datasets/{dataset}/tags", d.handleDatasetTags)
 	mux.HandleFunc("/datasets/{dataset}/tags/{tag}/values", d.handleDatasetTagValues)
 	mux.HandleFunc("/datasets/{dataset}/metrics/{metric}/tags", d.handleMetricTags)
 	mux.HandleFunc("/datasets/{dataset}/metrics/{metric}/tags/{tag}/values", d.handleMetricTagValues)
 
-	return httpadapter.New(mux)
-}
-
-func (d *Datasource) handleSchemaLookup(w http.ResponseWriter, r *http.Request) {
-	logger := log.DefaultLogger.FromContext(r.Context())
-
-	dsf, err := d.api.DatasetFields(r.Context())
-	if err != nil {
-		logger.Error("error looking up schema", "error", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	writeJSON(w, logger, dsf)
+	return httpadapter.New(instrumentResources(mux))
 }
 
 func (d *Datasource) HandleMetricsDatasets(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,236 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/axiomhq/axiom-grafana/pkg/config"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// allDatasetsSchemaKey is the schema cache key of the fields of every dataset.
+const allDatasetsSchemaKey = "\x00all"
+
+// schemaCache keeps dataset fields so editors do not fetch them from Axiom on
+// every load. An entry older than the TTL is still served, but the first
+// lookup after that refreshes it in the background. A failed refresh keeps
+// the old entry until the next lookup tries again.
+type schemaCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	// ctx is cancelled by close to stop the background refreshes.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// loads shares the initial fetch of a key between concurrent lookups.
+	loads inflightGroup
+
+	mu      sync.Mutex
+	entries map[string]*schemaEntry
+}
+
+// schemaEntry is a cached schema along with its JSON encoding and ETag,
+// which are computed once per fetch.
+type schemaEntry struct {
+	value      any
+	body       []byte
+	etag       string
+	fetched    time.Time
+	refreshing bool
+}
+
+// newSchemaCache returns nil when the cache is disabled, which makes every
+// lookup go to Axiom.
+func newSchemaCache(c *config.PluginConfig) *schemaCache {
+	if c.SchemaCacheTTL <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &schemaCache{
+		ttl:     c.SchemaCacheTTL,
+		now:     time.Now,
+		ctx:     ctx,
+		cancel:  cancel,
+		entries: map[string]*schemaEntry{},
+	}
+}
+
+// get returns the entry for key, calling load when there is none yet.
+func (c *schemaCache) get(ctx context.Context, key string, load func(context.Context) (any, error)) (*schemaEntry, error) {
+	if c == nil {
+		value, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return newSchemaEntry(value, time.Time{})
+	}
+
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok {
+		if c.now().Sub(entry.fetched) >= c.ttl && !entry.refreshing && c.ctx.Err() == nil {
+			entry.refreshing = true
+			c.wg.Add(1)
+			go c.refresh(key, entry, load)
+		}
+		c.mu.Unlock()
+		return entry, nil
+	}
+	c.mu.Unlock()
+
+	value, _, err := c.loads.do(ctx, key, func(ctx context.Context) (any, error) {
+		value, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := newSchemaEntry(value, c.now())
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.entries[key] = entry
+		c.mu.Unlock()
+		return entry, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*schemaEntry), nil
+}
+
+// refresh fetches key again and replaces stale with the result.
+func (c *schemaCache) refresh(key string, stale *schemaEntry, load func(context.Context) (any, error)) {
+	defer c.wg.Done()
+
+	entry, err := func() (*schemaEntry, error) {
+		value, err := load(c.ctx)
+		if err != nil {
+			return nil, err
+		}
+		return newSchemaEntry(value, c.now())
+	}()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		if c.ctx.Err() == nil {
+			log.DefaultLogger.Warn("failed to refresh dataset schema", "key", key, "error", err)
+		}
+		stale.refreshing = false
+		return
+	}
+	if c.entries[key] == stale {
+		c.entries[key] = entry
+	}
+}
+
+// close stops the background refreshes and waits for them to return.
+func (c *schemaCache) close() {
+	if c == nil {
+		return
+	}
+	c.cancel()
+	c.wg.Wait()
+}
+
+func newSchemaEntry(value any, fetched time.Time) (*schemaEntry, error) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+
+	return &schemaEntry{
+		value:   value,
+		body:    body,
+		etag:    `"` + hex.EncodeToString(sum[:16]) + `"`,
+		fetched: fetched,
+	}, nil
+}
+
+// allDatasetFields returns the fields of every dataset.
+func (d *Datasource) allDatasetFields(ctx context.Context) (*schemaEntry, error) {
+	return d.schemas.get(ctx, allDatasetsSchemaKey, func(ctx context.Context) (any, error) {
+		return d.api.DatasetFields(ctx)
+	})
+}
+
+// datasetFields returns the fields of a single dataset.
+func (d *Datasource) datasetFields(ctx context.Context, dataset string) (*schemaEntry, error) {
+	return d.schemas.get(ctx, dataset, func(ctx context.Context) (any, error) {
+		return d.api.DatasetFieldsOf(ctx, dataset)
+	})
+}
+
+func (d *Datasource) handleSchemaLookup(w http.ResponseWriter, r *http.Request) {
+	logger := log.DefaultLogger.FromContext(r.Context())
+
+	entry, err := d.allDatasetFields(r.Context())
+	if err != nil {
+		logger.Error("error looking up schema", "error", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeSchema(w, r, logger, entry)
+}
+
+// handleDatasetFields returns the fields of the dataset in the path, so the
+// editor only loads the schema of the dataset it queries.
+func (d *Datasource) handleDatasetFields(w http.ResponseWriter, r *http.Request) {
+	logger := log.DefaultLogger.FromContext(r.Context())
+	dataset := r.PathValue("dataset")
+
+	entry, err := d.datasetFields(r.Context(), dataset)
+	if err != nil {
+		var apiErr *axiomapi.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			http.Error(w, "Dataset not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("looking up dataset fields failed", "dataset", dataset, "error", err.Error())
+		http.Error(w, "Failed to look up dataset fields", http.StatusInternalServerError)
+		return
+	}
+
+	writeSchema(w, r, logger, entry)
+}
+
+// writeSchema writes a cached schema with its ETag, or 304 Not Modified
+// when the client already has it.
+func writeSchema(w http.ResponseWriter, r *http.Request, logger log.Logger, entry *schemaEntry) {
+	w.Header().Set("ETag", entry.etag)
+	if etagMatches(r.Header.Get("If-None-Match"), entry.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(entry.body); err != nil {
+		logger.Error("error writing response", "error", err.Error())
+	}
+}
+
+// etagMatches reports whether an If-None-Match header names etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,329 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	axiQuery "github.com/axiomhq/axiom-go/axiom/query"
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// liveTailPathPrefix starts the paths of live tail channels. The frontend
+// adds a hash of the request, so subscribers of the same query share a tail.
+const liveTailPathPrefix = "tail/"
+
+// liveTailRequest is the data the frontend subscribes to a live tail with.
+type liveTailRequest struct {
+	Query        string        `json:"query"`
+	AdhocFilters []adhocFilter `json:"adhocFilters"`
+}
+
+// liveTails tracks the running live tails so Dispose can stop them. The zero
+// value is ready to use.
+type liveTails struct {
+	mu      sync.Mutex
+	closed  bool
+	next    int
+	cancels map[int]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// start registers a tail. ok is false once the datasource is disposed;
+// otherwise done must be called when the tail stops.
+func (t *liveTails) start(ctx context.Context) (tailCtx context.Context, done func(), ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, nil, false
+	}
+	if t.cancels == nil {
+		t.cancels = map[int]context.CancelFunc{}
+	}
+
+	id := t.next
+	t.next++
+	tailCtx, cancel := context.WithCancel(ctx)
+	t.cancels[id] = cancel
+	t.wg.Add(1)
+
+	return tailCtx, func() {
+		cancel()
+		t.mu.Lock()
+		delete(t.cancels, id)
+		t.mu.Unlock()
+		t.wg.Done()
+	}, true
+}
+
+// close stops every tail and waits for them to return.
+func (t *liveTails) close() {
+	t.mu.Lock()
+	t.closed = true
+	for _, cancel := range t.cancels {
+		cancel()
+	}
+	t.mu.Unlock()
+
+	t.wg.Wait()
+}
+
+// SubscribeStream allows subscriptions to live tail channels whose request
+// holds an APL query.
+func (d *Datasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if !strings.HasPrefix(req.Path, liveTailPathPrefix) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	if _, err := parseLiveTailRequest(req.Data); err != nil {
+		return nil, err
+	}
+
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream rejects publishing: live tails only flow from Axiom.
+func (d *Datasource) PublishStream(context.Context, *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream tails an APL log query until the last subscriber leaves or the
+// datasource is disposed.
+func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	tailReq, err := parseLiveTailRequest(req.Data)
+	if err != nil {
+		return err
+	}
+	if _, err := applyAdhocFilters("apl", tailReq.Query, tailReq.AdhocFilters); err != nil {
+		return err
+	}
+
+	ctx, done, ok := d.tails.start(ctx)
+	if !ok {
+		return nil
+	}
+	defer done()
+
+	tail := &liveTail{
+		d:         d,
+		query:     tailReq.Query,
+		filters:   tailReq.AdhocFilters,
+		interval:  d.liveTailPollInterval,
+		maxRows:   d.liveTailMaxRows,
+		watermark: time.Now(),
+		sent:      map[string]bool{},
+	}
+	return tail.run(ctx, sender)
+}
+
+func parseLiveTailRequest(raw json.RawMessage) (liveTailRequest, error) {
+	var req liveTailRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return liveTailRequest{}, fmt.Errorf("invalid live tail request: %w", err)
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return liveTailRequest{}, errors.New("live tail needs an APL query")
+	}
+	return req, nil
+}
+
+// liveTail polls Axiom for the events of a query that arrived since the
+// previous poll. The watermark is the newest _time sent so far. Each poll
+// starts at the watermark itself, so events sharing its time that arrived
+// late are not missed; sent holds the IDs of the events at the watermark to
+// drop the ones already sent.
+type liveTail struct {
+	d         *Datasource
+	query     string
+	filters   []adhocFilter
+	interval  time.Duration
+	maxRows   int
+	watermark time.Time
+	sent      map[string]bool
+}
+
+func (t *liveTail) run(ctx context.Context, sender *backend.StreamSender) error {
+	logger := log.DefaultLogger.FromContext(ctx)
+	// A timer rather than a ticker: a slow subscriber delays the next poll
+	// instead of queueing them up.
+	timer := time.NewTimer(t.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		frame, err := t.poll(ctx, time.Now())
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			// Compile errors will not go away by polling again; anything
+			// else may be transient.
+			var apiErr *axiomapi.APIError
+			if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnprocessableEntity) {
+				return err
+			}
+			logger.Warn("live tail poll failed", "error", err)
+		case frame != nil:
+			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				return err
+			}
+		}
+
+		timer.Reset(t.interval)
+	}
+}
+
+// poll returns the frame of the events that arrived since the previous poll,
+// or nil when there are none.
+func (t *liveTail) poll(ctx context.Context, now time.Time) (*data.Frame, error) {
+	expanded, err := expandMacros("apl", t.query, backend.DataQuery{
+		TimeRange: backend.TimeRange{From: t.watermark, To: now},
+		Interval:  t.interval,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// As for panel queries, the filters go in after the macros.
+	filtered, err := applyAdhocFilters("apl", expanded, t.filters)
+	if err != nil {
+		return nil, err
+	}
+	apl := liveTailAPL(filtered, t.maxRows)
+
+	q := &queryModel{}
+	key := resultCacheKey("live-tail", apl, t.watermark, now)
+	result, _, err := sharedQuery(ctx, t.d, nil, q, key, func(ctx context.Context) (axiomapi.APLQueryResponse, int64, error) {
+		result, err := t.d.api.QueryAPL(ctx, axiomapi.APLQueryRequest{
+			APL:       &apl,
+			StartTime: t.watermark,
+			EndTime:   now,
+		})
+		return result, aplTablesSize(result), err
+	}, readOnlyResult[axiomapi.APLQueryResponse])
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Tables) == 0 {
+		return nil, nil
+	}
+
+	table := t.newRows(&result.Tables[0])
+	if table == nil {
+		return nil, nil
+	}
+	frame, err := aplLogsFrameBuilder{}.Build(ctx, table, aplFrameOptions{Query: apl, TraceID: result.TraceID})
+	if err != nil {
+		return nil, err
+	}
+	if traceRowCount(result.Tables[0].Columns) >= t.maxRows {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("More than %d events arrived since the last poll; only the newest %d are shown.", t.maxRows, t.maxRows),
+		})
+	}
+
+	return frame, nil
+}
+
+// newRows returns the rows of result that were not sent yet, oldest first,
+// and moves the watermark past them. It returns nil when there are none.
+func (t *liveTail) newRows(result *axiQuery.Table) *axiQuery.Table {
+	timestamps := logTimestampColumns(result.Fields)
+	idColumn, hasID := logColumns(result.Fields)["id"]
+	if !hasID {
+		idColumn.index = -1
+	}
+
+	watermark := t.watermark
+	var rows []int
+	var keys []string
+	var times []time.Time
+	// The query returns the newest rows first.
+	for row := traceRowCount(result.Columns) - 1; row >= 0; row-- {
+		timestamp, ok := logRowTimestamp(newAPLTableValues(result, nil), timestamps, row)
+		if !ok || timestamp.Before(t.watermark) {
+			continue
+		}
+		key := liveTailRowKey(result, idColumn.index, row)
+		if timestamp.Equal(t.watermark) && t.sent[key] {
+			continue
+		}
+		rows = append(rows, row)
+		keys = append(keys, key)
+		times = append(times, timestamp)
+		if timestamp.After(watermark) {
+			watermark = timestamp
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	if watermark.After(t.watermark) {
+		t.watermark = watermark
+		t.sent = map[string]bool{}
+	}
+	for i, key := range keys {
+		if times[i].Equal(t.watermark) {
+			t.sent[key] = true
+		}
+	}
+
+	table := &axiQuery.Table{
+		Name:    result.Name,
+		Fields:  result.Fields,
+		Columns: make([]axiQuery.Column, len(result.Columns)),
+	}
+	for i, column := range result.Columns {
+		values := make([]any, 0, len(rows))
+		for _, row := range rows {
+			if row < len(column) {
+				values = append(values, column[row])
+			} else {
+				values = append(values, nil)
+			}
+		}
+		table.Columns[i] = values
+	}
+	return table
+}
+
+// liveTailRowKey identifies a row by its _id, or by all of its values when
+// the query does not return one. idIndex is -1 without an _id column.
+func liveTailRowKey(result *axiQuery.Table, idIndex, row int) string {
+	if idIndex >= 0 && idIndex < len(result.Columns) && row < len(result.Columns[idIndex]) {
+		if id := logValueString(result.Columns[idIndex][row]); id != "" {
+			return id
+		}
+	}
+
+	values := make([]any, len(result.Columns))
+	for i, column := range result.Columns {
+		if row < len(column) {
+			values[i] = column[row]
+		}
+	}
+	return stringifyFrameValue(values)
+}
+
+// liveTailAPL keeps the maxRows newest events of query.
+func liveTailAPL(query string, maxRows int) string {
+	sourceQuery := strings.TrimSpace(query)
+	sourceQuery = strings.TrimSuffix(sourceQuery, ";")
+
+	return fmt.Sprintf(`(%s)
+| order by _time desc
+| take %d`, sourceQuery, maxRows)
+}
@@ -86,10 +86,14 @@ type traceColumn struct {
 	priority int
 }
 
-type aplTraceFrameBuilder struct{}
+type aplTraceFrameBuilder struct {
+	// fields are typed columns already decoded by aplStreamDecoder; see
+	// aplTableValues.
+	fields []*data.Field
+}
 
-func (aplTraceFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) (*data.Frame, error) {
-	frame, err := buildTraceFrame(ctx, result)
+func (b aplTraceFrameBuilder) Build(ctx context.Context, result *axiQuery.Table, opts aplFrameOptions) (*data.Frame, error) {
+	frame, err := buildTraceFrame(ctx, newAPLTableValues(result, b.fields))
 	if err != nil {
 		return nil, err
 	}
@@ -116,10 +120,10 @@ func fieldsMatchTrace(ctx context.Context, fields []axiQuery.Field) bool {
 	return true
 }
 
-func buildTraceFrame(ctx context.Context, result *axiQuery.Table) (*data.Frame, error) {
+func buildTraceFrame(ctx context.Context, result aplTableValues) (*data.Frame, error) {
 	logger := log.DefaultLogger.FromContext(ctx)
-	columns := traceColumns(result.Fields)
-	rowCount := traceRowCount(result.Columns)
+	columns := traceColumns(result.table.Fields)
+	rowCount := result.rowCount()
 
 	traceIDField := data.NewField("traceID", nil, []*string{})
 	spanIDField := data.NewField("spanID", nil, []*string{})
@@ -205,13 +209,13 @@ func traceRowCount(columns []axiQuery.Column) int {
 	return rowCount
 }
 
-func traceColumnValue(result *axiQuery.Table, columns map[string]traceColumn, canonicalName string, row int) any {
+func traceColumnValue(result aplTableValues, columns map[string]traceColumn, canonicalName string, row int) any {
 	column, ok := columns[canonicalName]
-	if !ok || column.index >= len(result.Columns) || row >= len(result.Columns[column.index]) {
+	if !ok {
 		return nil
 	}
 
-	return result.Columns[column.index][row]
+	return result.value(column.index, row)
 }
 
 func traceValueString(value any) string {
@@ -227,7 +231,7 @@ func traceValueString(value any) string {
 	}
 }
 
-func traceServiceTagsValue(result *axiQuery.Table, columns map[string]traceColumn, serviceName string, row int) any {
+func traceServiceTagsValue(result aplTableValues, columns map[string]traceColumn, serviceName string, row int) any {
 	if value := traceColumnValue(result, columns, "serviceTags", row); value != nil {
 		return traceKeyValuePairs(value, "serviceTags")
 	}
@@ -240,17 +244,14 @@ func traceServiceTagsValue(result *axiQuery.Table, columns map[string]traceColum
 	return tags
 }
 
-func traceTagsValue(result *axiQuery.Table, columns map[string]traceColumn, row int) any {
+func traceTagsValue(result aplTableValues, columns map[string]traceColumn, row int) any {
 	tags := make([]map[string]any, 0)
-	for fieldIndex, field := range result.Fields {
+	for fieldIndex, field := range result.table.Fields {
 		alias, isTraceField := traceFieldAliases[field.Name]
 		if isTraceField && alias.canonicalName != "tags" {
 			continue
 		}
-		if fieldIndex >= len(result.Columns) || row >= len(result.Columns[fieldIndex]) {
-			continue
-		}
-		value := result.Columns[fieldIndex][row]
+		value := result.value(fieldIndex, row)
 		if value == nil {
 			continue
 		}
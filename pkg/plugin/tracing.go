@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const attributeRefID = attribute.Key("axiom.query.ref_id")
+
+// startSpan starts a span with the tracer Grafana set up for the plugin.
+// Without tracing configured in Grafana it is a no-op.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.DefaultTracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endFramesSpan records the frames built under span, or the error that
+// stopped them, and ends it.
+func endFramesSpan(span trace.Span, frames []*data.Frame, err error) {
+	if err != nil {
+		_ = tracing.Error(span, err)
+	}
+	span.SetAttributes(
+		attribute.Int("axiom.frames", len(frames)),
+		axiomapi.AttributeRows.Int(framesRows(frames)),
+	)
+	if traceID := framesTraceID(frames); traceID != "" {
+		span.SetAttributes(axiomapi.AttributeTraceID.String(traceID))
+	}
+	span.End()
+}
+
+func framesRows(frames []*data.Frame) int {
+	rows := 0
+	for _, frame := range frames {
+		rows += frame.Rows()
+	}
+	return rows
+}
+
+// framesTraceID returns the Axiom trace ID applyAxiomTraceID stored on the
+// frames.
+func framesTraceID(frames []*data.Frame) string {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			continue
+		}
+		if custom, ok := frame.Meta.Custom.(map[string]any); ok {
+			if traceID, ok := custom["axiomTraceId"].(string); ok && traceID != "" {
+				return traceID
+			}
+		}
+	}
+	return ""
+}
@@ -3,6 +3,7 @@ package plugin
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -18,6 +19,19 @@ func nullableStringPtr(value string) *string {
 	return &value
 }
 
+// aplQuotedIdentifier quotes name as an APL ['identifier'], so names with
+// dots, dashes or spaces can be referenced.
+func aplQuotedIdentifier(name string) string {
+	return "['" + aplEscaper.Replace(name) + "']"
+}
+
+// aplStringLiteral quotes value as an APL string literal.
+func aplStringLiteral(value string) string {
+	return "'" + aplEscaper.Replace(value) + "'"
+}
+
+var aplEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
 func stringifyFrameValue(value any) string {
 	if value == nil {
 		return ""
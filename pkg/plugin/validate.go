@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	axiQuery "github.com/axiomhq/axiom-go/axiom/query"
+	"github.com/axiomhq/axiom-grafana/pkg/axiomapi"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Result shapes reported by /validate, named after the frames a query with
+// that result gets.
+const (
+	aplShapeTimeSeries = "timeSeries"
+	aplShapeLogs       = "logs"
+	aplShapeTrace      = "trace"
+	aplShapeTable      = "table"
+)
+
+// validateTimeout keeps a slow validation from holding up the editor.
+const validateTimeout = 10 * time.Second
+
+// validateWindow is the time range validation runs the query over. No
+// dataset has events in the first second of the Unix epoch, so Axiom
+// compiles the query but has no blocks to read.
+var validateWindow = [2]time.Time{time.Unix(0, 0), time.Unix(1, 0)}
+
+type validateRequest struct {
+	APL string `json:"apl"`
+}
+
+// aplValidation is the /validate response.
+type aplValidation struct {
+	Valid       bool            `json:"valid"`
+	Diagnostics []aplDiagnostic `json:"diagnostics"`
+	Datasets    []string        `json:"datasets"`
+	// Shape is empty when the query does not compile.
+	Shape string `json:"shape,omitempty"`
+	// Error is the reason Axiom rejected the query when it has no position
+	// to show as a diagnostic.
+	Error string `json:"error,omitempty"`
+}
+
+// handleValidate checks an APL query without reading any events and tells the
+// editor which datasets it reads and what kind of frames its result becomes.
+func (d *Datasource) handleValidate(w http.ResponseWriter, r *http.Request) {
+	logger := log.DefaultLogger.FromContext(r.Context())
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req validateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.APL) == "" {
+		http.Error(w, "apl is required", http.StatusBadRequest)
+		return
+	}
+
+	validation, err := d.validateAPL(r.Context(), req.APL)
+	if err != nil {
+		logger.Error("validating query failed", "error", err.Error())
+		http.Error(w, "Failed to validate query", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, logger, validation)
+}
+
+// validateAPL runs apl over validateWindow. Compile errors come back as
+// diagnostics; err is only set when Axiom could not be asked.
+func (d *Datasource) validateAPL(ctx context.Context, apl string) (aplValidation, error) {
+	validation := aplValidation{
+		Diagnostics: []aplDiagnostic{},
+		Datasets:    axiomapi.DatasetsInAPL(apl),
+	}
+	if validation.Datasets == nil {
+		validation.Datasets = []string{}
+	}
+
+	// Macros are expanded the way a panel query would be, just over
+	// validateWindow, so Axiom does not reject them as unknown names.
+	expanded, err := expandMacros("apl", apl, backend.DataQuery{
+		TimeRange: backend.TimeRange{From: validateWindow[0], To: validateWindow[1]},
+	})
+	if err != nil {
+		validation.Error = err.Error()
+		return validation, nil
+	}
+
+	q := &queryModel{timeout: validateTimeout}
+	key := resultCacheKey("validate", expanded, validateWindow[0], validateWindow[1])
+	result, _, err := sharedQuery(ctx, d, nil, q, key, func(ctx context.Context) (axiomapi.APLQueryResponse, int64, error) {
+		result, err := d.api.QueryAPL(ctx, axiomapi.APLQueryRequest{
+			APL:       &expanded,
+			StartTime: validateWindow[0],
+			EndTime:   validateWindow[1],
+			Timeout:   validateTimeout,
+		})
+		return result, 0, err
+	}, readOnlyResult[axiomapi.APLQueryResponse])
+	if err != nil {
+		var apiErr *axiomapi.APIError
+		if !errors.As(err, &apiErr) || (apiErr.StatusCode != http.StatusBadRequest && apiErr.StatusCode != http.StatusUnprocessableEntity) {
+			return aplValidation{}, err
+		}
+		if diagnostics := aplErrorDiagnostics(err); len(diagnostics) > 0 {
+			validation.Diagnostics = editorDiagnostics(apl, expanded, diagnostics)
+		} else {
+			validation.Error = apiErr.Message
+		}
+		return validation, nil
+	}
+
+	if result.Status != nil {
+		validation.Diagnostics = append(validation.Diagnostics, editorDiagnostics(apl, expanded, aplStatusDiagnostics(*result.Status))...)
+	}
+	validation.Valid = true
+	for _, diagnostic := range validation.Diagnostics {
+		if diagnostic.Severity == data.NoticeSeverityError.String() {
+			validation.Valid = false
+		}
+	}
+	validation.Shape = d.aplResultShape(ctx, result, validation.Datasets)
+
+	return validation, nil
+}
+
+// editorDiagnostics maps the diagnostics of the expanded query back onto
+// apl. Macros never span lines, so lines stay put, but columns after a macro
+// no longer match; diagnostics on such lines cover the whole line instead.
+func editorDiagnostics(apl, expanded string, diagnostics []aplDiagnostic) []aplDiagnostic {
+	if apl == expanded {
+		return diagnostics
+	}
+
+	lines := strings.Split(apl, "\n")
+	expandedLines := strings.Split(expanded, "\n")
+	for i, diagnostic := range diagnostics {
+		line := diagnostic.Line - 1
+		if line >= 0 && line < len(lines) && line < len(expandedLines) && lines[line] != expandedLines[line] {
+			diagnostics[i].Column = 1
+		}
+	}
+	return diagnostics
+}
+
+// aplResultShape picks the shape the same way aplResponseFrameBuilder picks
+// the frames of a result. A result without rows may also come without
+// fields; the schema of the one dataset the query reads then stands in.
+func (d *Datasource) aplResultShape(ctx context.Context, result axiomapi.APLQueryResponse, datasets []string) string {
+	if len(result.Tables) > 1 {
+		return aplShapeTimeSeries
+	}
+
+	var fields []axiQuery.Field
+	if len(result.Tables) > 0 {
+		fields = result.Tables[0].Fields
+	}
+	if len(fields) == 0 && len(datasets) == 1 {
+		if schema, err := d.datasetFields(ctx, datasets[0]); err != nil {
+			log.DefaultLogger.FromContext(ctx).Warn("failed to fetch dataset fields for the result shape", "dataset", datasets[0], "error", err)
+		} else {
+			fields = datasetAPLFields(schema.value.([]axiomapi.DatasetField))
+		}
+	}
+
+	switch newAPLEventFrameBuilder(ctx, fields).(type) {
+	case aplTraceFrameBuilder:
+		return aplShapeTrace
+	case aplLogsFrameBuilder:
+		return aplShapeLogs
+	default:
+		return aplShapeTable
+	}
+}
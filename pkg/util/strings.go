@@ -1,8 +1,68 @@
 package util
 
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
 func CheckString(i interface{}) string {
 	if str, ok := i.(string); ok {
 		return str
 	}
 	return ""
 }
+
+// CheckInt reads an integer setting that Grafana may hand over as a JSON
+// number or, for provisioned datasources, as a string.
+func CheckInt(i interface{}) (int, bool) {
+	switch v := i.(type) {
+	case float64:
+		return int(v), true
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return 0, false
+		}
+		return int(n), true
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, false
+		}
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// CheckFloat reads a numeric setting the same way CheckInt does but keeps
+// fractional values.
+func CheckFloat(i interface{}) (float64, bool) {
+	switch v := i.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		n, err := v.Float64()
+		if err != nil {
+			return 0, false
+		}
+		return n, true
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, false
+		}
+		return n, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,24 @@
+package util
+
+import (
+	"context"
+	"time"
+)
+
+// SleepContext waits for wait or until ctx is done, whichever comes first,
+// and returns ctx's error in the latter case.
+func SleepContext(ctx context.Context, wait time.Duration) error {
+	if wait <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
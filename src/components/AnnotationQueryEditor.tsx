@@ -0,0 +1,71 @@
+import React, { useEffect, useMemo } from 'react';
+import { FieldSet, InlineField, Input, Stack } from '@grafana/ui';
+import { QueryEditorProps } from '@grafana/data';
+
+import { APLQueryEdtior } from './AplQueryEditor';
+import type { DataSource } from '../datasource';
+import { AxiomAnnotationColumns, AxiomDataSourceOptions, AxiomQuery, DEFAULT_QUERY } from '../types';
+import { migrateAxiomQuery, shouldMigrateAxiomQuery } from '../queryMigration';
+
+type Props = QueryEditorProps<DataSource, AxiomQuery, AxiomDataSourceOptions>;
+
+const COLUMNS: Array<{ key: Exclude<keyof AxiomAnnotationColumns, 'tags'>; label: string; placeholder: string }> = [
+  { key: 'time', label: 'Time', placeholder: '_time' },
+  { key: 'timeEnd', label: 'Time end', placeholder: 'timeEnd' },
+  { key: 'title', label: 'Title', placeholder: 'title' },
+  { key: 'text', label: 'Text', placeholder: 'text, message or the first string column' },
+];
+
+export function AnnotationQueryEditor({ query, onChange, onRunQuery, datasource }: Props) {
+  const annotationQuery = useMemo(() => ({ ...DEFAULT_QUERY, ...migrateAxiomQuery(query) } as AxiomQuery), [query]);
+  const columns = annotationQuery.annotation || {};
+
+  useEffect(() => {
+    if (shouldMigrateAxiomQuery(query)) {
+      onChange({ ...DEFAULT_QUERY, ...migrateAxiomQuery(query) } as AxiomQuery);
+    }
+  }, [query, onChange]);
+
+  const updateColumns = (patch: Partial<AxiomAnnotationColumns>) => {
+    onChange({ ...annotationQuery, annotation: { ...columns, ...patch } });
+  };
+
+  return (
+    <Stack direction="column">
+      <APLQueryEdtior
+        value={annotationQuery.query || ''}
+        onChange={(apl) => onChange({ ...annotationQuery, kind: 'apl', query: apl })}
+        datasource={datasource}
+        onRunQuery={onRunQuery}
+      />
+      <FieldSet label="Columns">
+        {COLUMNS.map(({ key, label, placeholder }) => (
+          <InlineField key={key} label={label} labelWidth={12}>
+            <Input
+              id={`annotation-editor-${key}`}
+              value={columns[key] || ''}
+              onChange={(event) => updateColumns({ [key]: event.currentTarget.value.trim() || undefined })}
+              placeholder={placeholder}
+              width={40}
+            />
+          </InlineField>
+        ))}
+        <InlineField label="Tags" labelWidth={12} tooltip="Comma-separated columns whose values become tags">
+          <Input
+            id="annotation-editor-tags"
+            defaultValue={(columns.tags || []).join(', ')}
+            onBlur={(event) => {
+              const tags = event.currentTarget.value
+                .split(',')
+                .map((tag) => tag.trim())
+                .filter(Boolean);
+              updateColumns({ tags: tags.length ? tags : undefined });
+            }}
+            placeholder="tags"
+            width={40}
+          />
+        </InlineField>
+      </FieldSet>
+    </Stack>
+  );
+}
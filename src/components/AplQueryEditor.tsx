@@ -1,6 +1,6 @@
 import React from 'react';
 import { CodeEditor } from '@grafana/ui';
-import { DatasetFields, mapDatasetInfosToSchema } from '../schema';
+import { datasetInQuery, datasetsInQuery, fieldComparisonAtCursor, mapDatasetInfosToSchema, withDatasetNames } from '../schema';
 import type { DataSource } from '../datasource';
 import { registerKustoLanguage } from '../monaco/registerKustoLanguage';
 
@@ -79,6 +79,12 @@ export function APLQueryEdtior({
 }) {
   const [aplEditorContent, setAplEditorContent] = React.useState('');
   const hasAutoFocusedRef = React.useRef(false);
+  const valueCompletionRef = React.useRef<{ dispose: () => void } | undefined>(undefined);
+  const editorRef = React.useRef<{ editor: any; monaco: any } | undefined>(undefined);
+  const validationRef = React.useRef(0);
+  const datasetNamesRef = React.useRef<Promise<string[]> | undefined>(undefined);
+  const schemaKeyRef = React.useRef<string | undefined>(undefined);
+  React.useEffect(() => () => valueCompletionRef.current?.dispose(), []);
   if (value !== aplEditorContent) {
     // query.apl could've changed from the outside (e.g. when a history query
     // is ran), so we need to update the state.
@@ -101,6 +107,137 @@ export function APLQueryEdtior({
     });
   };
 
+  // Suggests field values inside the string of a comparison, such as
+  // `where method == "`, from the values the field has in the time range.
+  const registerValueCompletion = (editor: any, monaco: any) => {
+    valueCompletionRef.current?.dispose();
+    valueCompletionRef.current = monaco.languages.registerCompletionItemProvider('kusto', {
+      triggerCharacters: ['"', "'"],
+      provideCompletionItems: async (model: any, position: any) => {
+        // The provider is registered for the language, so it also runs for
+        // the other APL editors on the page.
+        if (model !== editor.getModel()) {
+          return { suggestions: [] };
+        }
+
+        const textBeforeCursor = model.getValueInRange({
+          startLineNumber: position.lineNumber,
+          startColumn: 1,
+          endLineNumber: position.lineNumber,
+          endColumn: position.column,
+        });
+        const comparison = fieldComparisonAtCursor(textBeforeCursor);
+        const dataset = datasetInQuery(model.getValue());
+        if (!comparison || !dataset) {
+          return { suggestions: [] };
+        }
+
+        try {
+          const values = await datasource.getFieldValues(dataset, comparison.field, comparison.prefix);
+          const range = {
+            startLineNumber: position.lineNumber,
+            startColumn: position.column - comparison.typedLength,
+            endLineNumber: position.lineNumber,
+            endColumn: position.column,
+          };
+          return {
+            suggestions: values.map(({ value, count }, i) => ({
+              label: value,
+              kind: monaco.languages.CompletionItemKind.Value,
+              detail: `${count} events`,
+              insertText: value.replace(/["'\\]/g, '\\$&'),
+              // Keep the most frequent values first.
+              sortText: String(i).padStart(4, '0'),
+              range,
+            })),
+          };
+        } catch (e) {
+          console.warn(e);
+          return { suggestions: [] };
+        }
+      },
+    });
+  };
+
+  // Gives completion every dataset by name and the fields of the datasets the
+  // query reads. The fields are loaded again whenever those datasets change,
+  // such as when the source is edited or a join is added.
+  const loadSchema = async (editor: any, query: string) => {
+    try {
+      datasetNamesRef.current ??= datasource.getDatasets().then((datasets) => datasets.map((d) => d.name));
+      const names = await datasetNamesRef.current;
+      const known = new Set(names);
+      const read = datasetsInQuery(query).filter((name) => known.has(name));
+      const key = read.join('\n');
+      if (key === schemaKeyRef.current) {
+        return;
+      }
+      schemaKeyRef.current = key;
+
+      const fields = await Promise.all(read.map((dataset) => datasource.getDatasetFields(dataset)));
+      if (key !== schemaKeyRef.current) {
+        // A later edit started loading other datasets.
+        return;
+      }
+      const schema = mapDatasetInfosToSchema(withDatasetNames(fields, names));
+
+      const workerAccessor = await (window as any).monaco.languages.kusto.getKustoWorker();
+      const model = editor.getModel();
+      if (model && model.uri) {
+        const worker = await workerAccessor(model.uri);
+        worker.setSchemaFromShowSchema(
+          schema,
+          JSON.stringify(schema), // Not really sure what to put here - it's the database connection string
+          'db', // Should be the name of the database in the schema
+          []
+        );
+      }
+    } catch (e) {
+      // Let the next edit try again.
+      datasetNamesRef.current = undefined;
+      schemaKeyRef.current = undefined;
+      console.warn(e);
+    }
+  };
+
+  // Marks the diagnostics of the query in the editor. Only the latest
+  // validation updates the markers.
+  const markDiagnostics = async (query: string) => {
+    const model = editorRef.current?.editor.getModel();
+    if (!editorRef.current || !model) {
+      return;
+    }
+    const { monaco } = editorRef.current;
+    const validation = ++validationRef.current;
+
+    let markers: any[] = [];
+    if (hasRunnableQuery(query)) {
+      try {
+        const { diagnostics } = await datasource.validateQuery(query);
+        markers = diagnostics.map((diagnostic) => ({
+          severity:
+            diagnostic.severity === 'error'
+              ? monaco.MarkerSeverity.Error
+              : diagnostic.severity === 'warning'
+              ? monaco.MarkerSeverity.Warning
+              : monaco.MarkerSeverity.Info,
+          message: diagnostic.message,
+          code: diagnostic.code,
+          startLineNumber: diagnostic.line,
+          startColumn: diagnostic.column,
+          endLineNumber: diagnostic.line,
+          endColumn: model.getLineMaxColumn(Math.min(diagnostic.line, model.getLineCount())),
+        }));
+      } catch (e) {
+        console.warn(e);
+      }
+    }
+
+    if (validation === validationRef.current && !model.isDisposed()) {
+      monaco.editor.setModelMarkers(model, 'axiom-apl', markers);
+    }
+  };
+
   const focusEditor = (editor: any) => {
     if (!autoFocus || hasAutoFocusedRef.current) {
       return;
@@ -116,6 +253,7 @@ export function APLQueryEdtior({
         const query = normalizeEditorQuery(apl);
         onChange(query);
         setAplEditorContent(query);
+        markDiagnostics(query);
         if (hasRunnableQuery(query)) {
           onRunQuery();
         }
@@ -131,6 +269,7 @@ export function APLQueryEdtior({
       }}
       onEditorDidMount={async (editor, monaco) => {
         await registerKustoLanguage(monaco);
+        editorRef.current = { editor, monaco };
 
         const kustoLanguageId = 'kusto';
         const model = editor.getModel();
@@ -164,6 +303,8 @@ export function APLQueryEdtior({
           });
         }
 
+        registerValueCompletion(editor, monaco);
+
         editor.addAction({
           id: 'submit-query',
           label: 'Submit query',
@@ -172,6 +313,7 @@ export function APLQueryEdtior({
             const query = normalizeEditorQuery(ed.getValue());
             onChange(query);
             setAplEditorContent(query);
+            markDiagnostics(query);
             if (hasRunnableQuery(query)) {
               onRunQuery();
             }
@@ -179,25 +321,8 @@ export function APLQueryEdtior({
         });
 
         // Should have awaited until the lang was registered so safe to access kusto?
-        try {
-          let res = await datasource.lookupSchema();
-          let schema = mapDatasetInfosToSchema(res as DatasetFields[]);
-
-          const workerAccessor = await (window as any).monaco.languages.kusto.getKustoWorker();
-
-          const model = editor.getModel();
-          if (model && model.uri) {
-            const worker = await workerAccessor(model.uri);
-            worker.setSchemaFromShowSchema(
-              schema,
-              JSON.stringify(schema), // Not really sure what to put here - it's the database connection string
-              'db', // Should be the name of the database in the schema
-              []
-            );
-          }
-        } catch (e) {
-          console.warn(e);
-        }
+        editor.onDidChangeModelContent(() => loadSchema(editor, editor.getValue()));
+        await loadSchema(editor, value);
       }}
     />
   );
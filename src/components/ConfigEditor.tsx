@@ -23,7 +23,9 @@ export function ConfigEditor(props: Props) {
   const { onOptionsChange, options } = props;
   const jsonData = useMemo(() => (options.jsonData || {}) as AxiomDataSourceOptions, [options.jsonData]);
   const secureJsonData = (options.secureJsonData || {}) as MySecureJsonData;
-  const [isPersonalToken, setIsPersonalToken] = useState(options.secureJsonData?.accessToken.startsWith('xapt-'));
+  const [isPersonalToken, setIsPersonalToken] = useState(
+    Boolean(options.secureJsonData?.accessToken?.startsWith('xapt-') || options.jsonData?.orgId)
+  );
 
   useEffect(() => {
     const migratedEdgeURL = jsonData.edgeURL || (jsonData.edge ? legacyEdgeToEdgeURL(jsonData.edge) : '');
@@ -59,14 +61,17 @@ export function ConfigEditor(props: Props) {
     onOptionsChange({ ...options, jsonData });
   };
 
+  const onOrgIdChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      orgId: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
   // Secure field (only sent to the backend)
   const onAccessTokenChange = (event: ChangeEvent<HTMLInputElement>) => {
-    if (event.target.value.startsWith('xapt-')) {
-      setIsPersonalToken(true);
-      return;
-    } else {
-      setIsPersonalToken(false);
-    }
+    setIsPersonalToken(event.target.value.startsWith('xapt-') || Boolean(options.jsonData?.orgId));
 
     onOptionsChange({
       ...options,
@@ -106,15 +111,23 @@ export function ConfigEditor(props: Props) {
           onChange={onAccessTokenChange}
         />
       </InlineField>
-      <br />
-      {/* If personal token is used, show an error message */}
+      {/* Personal access tokens need the organization they should act on */}
       {isPersonalToken && (
+        <InlineField
+          label="Organization ID"
+          labelWidth={17}
+          tooltip="Sent as the X-Axiom-Org-Id header. Required for personal access tokens."
+        >
+          <Input onChange={onOrgIdChange} value={jsonData.orgId || ''} placeholder="my-org-a1b2" width={40} />
+        </InlineField>
+      )}
+      <br />
+      {isPersonalToken && !jsonData.orgId && (
         <div>
           <Alert
-            title="Personal tokens are deprecated. Please switch to advanced API tokens."
+            title="Personal access tokens need an organization ID. Set it above or switch to an API token."
             about="Token"
-            severity="error"
-            buttonContent="Learn more"
+            severity="warning"
             topSpacing={0}
           />
         </div>
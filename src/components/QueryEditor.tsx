@@ -34,6 +34,13 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource, app }: Pr
     });
   };
 
+  const onAsyncChange = (e: FormEvent<HTMLInputElement>) => {
+    onChange({
+      ...migratedQuery,
+      async: e.currentTarget.checked,
+    });
+  };
+
   const runMplQuery = (mpl: string) => {
     onChange({
       ...migratedQuery,
@@ -95,6 +102,17 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource, app }: Pr
                 onChange={onTotalsChange}
               />
             </InlineField>
+            <InlineField
+              label="Long-running"
+              tooltip="Runs the query as a job on Axiom that is polled until it finishes. Partial results are shown if it times out."
+            >
+              <InlineSwitch
+                label="Run as async job"
+                showLabel={true}
+                value={migratedQuery.async ?? false}
+                onChange={onAsyncChange}
+              />
+            </InlineField>
           </InlineFieldRow>
         )}
       </FieldSet>
@@ -1,21 +1,37 @@
 import {
+  AdHocVariableFilter,
+  AnnotationQuery,
   CoreApp,
   DataQueryRequest,
+  DataSourceGetTagKeysOptions,
+  DataSourceGetTagValuesOptions,
   DataQueryResponse,
   DataSourceInstanceSettings,
+  LiveChannelScope,
   MetricFindValue,
   ScopedVars,
 } from '@grafana/data';
-import { DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
+import { DataSourceWithBackend, getGrafanaLiveSrv, getTemplateSrv } from '@grafana/runtime';
 
-import { AxiomQuery, AxiomDataSourceOptions } from './types';
+import {
+  AxiomQuery,
+  AxiomDataSourceOptions,
+  AxiomDataset,
+  AxiomDatasetFilters,
+  AxiomFieldValue,
+  AxiomQueryValidation,
+} from './types';
 import { migrateAxiomQuery } from './queryMigration';
+import { DatasetFields, datasetInQuery } from './schema';
+import { datasetInMplQuery } from './mplVariableQuery';
 import { AxiomVariableSupport } from './variables';
+import { AnnotationQueryEditor } from './components/AnnotationQueryEditor';
 import { getMetricFindValues, textValuesToMetricFindValues } from './variableValues';
-import { lastValueFrom } from 'rxjs';
+import { lastValueFrom, merge, Observable } from 'rxjs';
 
 const SUPPLEMENTARY_QUERY_TYPE_LOGS_VOLUME = 'LogsVolume';
 const LOGS_VOLUME_QUERY_TYPE = 'logs-volume';
+const ANNOTATIONS_QUERY_TYPE = 'annotations';
 
 const PANEL_APPS = new Set<CoreApp | string>([CoreApp.Dashboard, CoreApp.PanelEditor, CoreApp.PanelViewer]);
 
@@ -26,9 +42,15 @@ export class DataSource extends DataSourceWithBackend<AxiomQuery, AxiomDataSourc
     super(instanceSettings);
     this.url = instanceSettings.url;
     this.variables = new AxiomVariableSupport(this);
+    this.annotations = {
+      QueryEditor: AnnotationQueryEditor,
+      // The backend maps the APL result to time, timeEnd, title, text and tags.
+      prepareQuery: (annotation: AnnotationQuery<AxiomQuery>) =>
+        annotation.target?.query ? { ...annotation.target, queryType: ANNOTATIONS_QUERY_TYPE, totals: false } : undefined,
+    };
   }
 
-  applyTemplateVariables(query: AxiomQuery, scopedVars: ScopedVars) {
+  applyTemplateVariables(query: AxiomQuery, scopedVars: ScopedVars, filters?: AdHocVariableFilter[]) {
     const templateSrv = getTemplateSrv();
     const migratedQuery = migrateAxiomQuery(query);
     const queryText = migratedQuery.query;
@@ -37,10 +59,56 @@ export class DataSource extends DataSourceWithBackend<AxiomQuery, AxiomDataSourc
     return {
       ...migratedQuery,
       query: interpolatedQuery,
+      // The backend adds the filters after the query's source.
+      ...(filters?.length ? { adhocFilters: filters } : {}),
     };
   }
 
-  query(request: DataQueryRequest<AxiomQuery>) {
+  /** Lists the fields and tags ad-hoc filters can use in the dashboard's queries. */
+  async getTagKeys(options?: DataSourceGetTagKeysOptions<AxiomQuery>): Promise<MetricFindValue[]> {
+    const keys: string[] = await this.getResource(`tag-keys?${this.tagParams(options?.queries)}`);
+    return textValuesToMetricFindValues(keys);
+  }
+
+  /** Lists the values of an ad-hoc filter key, most frequent first. */
+  async getTagValues(options: DataSourceGetTagValuesOptions<AxiomQuery>): Promise<MetricFindValue[]> {
+    const params = this.tagParams(options.queries);
+    params.set('key', options.key);
+    const values: string[] = await this.getResource(`tag-values?${params}`);
+    return textValuesToMetricFindValues(values);
+  }
+
+  // tagParams names the datasets the queries read from, so ad-hoc filters
+  // only offer keys and values that apply to them.
+  private tagParams(queries: AxiomQuery[] = []): URLSearchParams {
+    const params = new URLSearchParams(this.timeRangeParams());
+    const datasets = new Set<string>();
+    const metricsDatasets = new Set<string>();
+    for (const query of queries.map(migrateAxiomQuery)) {
+      const text = getTemplateSrv().replace(query.query);
+      if (query.kind === 'mpl') {
+        const dataset = datasetInMplQuery(text) ?? query.dataset;
+        if (dataset) {
+          metricsDatasets.add(dataset);
+        }
+        continue;
+      }
+      const dataset = datasetInQuery(text);
+      if (dataset) {
+        datasets.add(dataset);
+      }
+    }
+    datasets.forEach((dataset) => params.append('dataset', dataset));
+    metricsDatasets.forEach((dataset) => params.append('metricsDataset', dataset));
+
+    return params;
+  }
+
+  query(request: DataQueryRequest<AxiomQuery>): Observable<DataQueryResponse> {
+    if (request.liveStreaming) {
+      return this.liveTail(request);
+    }
+
     const includeTotalsTableFrame = request.app === CoreApp.Explore;
     // Dashboard panels default to Time series, which needs a numeric frame.
     // When an APL query returns logs, the backend uses this flag to prepend a
@@ -54,6 +122,7 @@ export class DataSource extends DataSourceWithBackend<AxiomQuery, AxiomDataSourc
 
         return {
           ...migratedQuery,
+          app: request.app,
           includeTotalsTableFrame: includeTotalsTableFrame && !migratedQuery.totals,
           includeLogsVolumeFrame: includeLogsVolumeFrame && migratedQuery.kind !== 'mpl' && !migratedQuery.totals,
         };
@@ -61,6 +130,31 @@ export class DataSource extends DataSourceWithBackend<AxiomQuery, AxiomDataSourc
     });
   }
 
+  // liveTail subscribes each APL query to a backend stream that pushes the
+  // events arriving after it started, for Explore's live mode. Queries with
+  // the same text share one stream.
+  private liveTail(request: DataQueryRequest<AxiomQuery>): Observable<DataQueryResponse> {
+    const streams = request.targets
+      .map(migrateAxiomQuery)
+      .filter((query) => !query.hide && query.kind !== 'mpl' && query.query)
+      .map((query) => {
+        const { query: apl, adhocFilters } = this.applyTemplateVariables(query, request.scopedVars, request.filters);
+        const data = { query: apl, adhocFilters };
+
+        return getGrafanaLiveSrv().getDataStream({
+          key: `${request.requestId}-${query.refId}`,
+          addr: {
+            scope: LiveChannelScope.DataSource,
+            namespace: this.uid,
+            path: `tail/${hashString(JSON.stringify(data))}`,
+            data,
+          },
+        });
+      });
+
+    return merge(...streams);
+  }
+
   private timeRangeParams(): Record<string, string> {
     const from = getTemplateSrv().replace('$__from');
     const to = getTemplateSrv().replace('$__to');
@@ -109,8 +203,48 @@ export class DataSource extends DataSourceWithBackend<AxiomQuery, AxiomDataSourc
     return res ? getMetricFindValues(res, interpolatedQuery.kind) : [];
   }
 
-  async lookupSchema() {
-    return this.getResource('/schema-lookup');
+  /** Fetches the fields of a single dataset. */
+  async getDatasetFields(dataset: string): Promise<DatasetFields> {
+    const fields = await this.getResource(`datasets/${encodeURIComponent(dataset)}/fields`);
+    return { datasetName: dataset, fields };
+  }
+
+  /**
+   * Suggests the most frequent values of a field in the dashboard time range,
+   * optionally only those starting with prefix.
+   */
+  async getFieldValues(dataset: string, field: string, prefix = ''): Promise<AxiomFieldValue[]> {
+    const params = new URLSearchParams(this.timeRangeParams());
+    if (prefix) {
+      params.set('prefix', prefix);
+    }
+
+    return this.getResource(`datasets/${encodeURIComponent(dataset)}/fields/${encodeURIComponent(field)}/values?${params}`);
+  }
+
+  /**
+   * Lists the datasets with their metadata, optionally filtered by kind or
+   * signal and by name.
+   */
+  async getDatasets(filters: AxiomDatasetFilters = {}): Promise<AxiomDataset[]> {
+    const params = new URLSearchParams();
+    for (const kind of filters.kind ?? []) {
+      params.append('kind', kind);
+    }
+    if (filters.name) {
+      params.set('name', filters.name);
+    }
+
+    const search = params.toString();
+    return this.getResource(search ? `datasets?${search}` : 'datasets');
+  }
+
+  /**
+   * Checks an APL query without reading any events, returning its
+   * diagnostics, the datasets it reads and the shape of its result.
+   */
+  async validateQuery(apl: string, scopedVars: ScopedVars = {}): Promise<AxiomQueryValidation> {
+    return this.postResource('validate', { apl: getTemplateSrv().replace(apl, scopedVars) });
   }
 
   getQueryDisplayText(query: AxiomQuery) {
@@ -211,3 +345,14 @@ export class DataSource extends DataSourceWithBackend<AxiomQuery, AxiomDataSourc
     );
   }
 }
+
+// hashString is a 32-bit FNV-1a hash, used to name live tail channels after
+// their query since channel paths only allow a few characters.
+function hashString(value: string): string {
+  let hash = 0x811c9dc5;
+  for (let i = 0; i < value.length; i++) {
+    hash ^= value.charCodeAt(i);
+    hash = Math.imul(hash, 0x01000193);
+  }
+  return (hash >>> 0).toString(16);
+}
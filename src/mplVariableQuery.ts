@@ -23,3 +23,22 @@ function escapeMplIdentifier(value: string): string {
 
   return `\`${value.replace(/`/g, '\\`')}\``;
 }
+
+/**
+ * Returns the dataset of an MPL query's dataset:metric source, or undefined
+ * when the query does not start with one.
+ */
+export function datasetInMplQuery(mpl: string): string | undefined {
+  const source = mpl
+    .split('\n')
+    .filter((line) => !line.trim().startsWith('//'))
+    .join('\n')
+    .trimStart();
+
+  const match = source.match(/^(?:`((?:[^`\\]|\\.)+)`|([\w.-]+))\s*:/);
+  if (!match) {
+    return undefined;
+  }
+
+  return match[1] !== undefined ? match[1].replace(/\\(.)/g, '$1') : match[2];
+}
@@ -84,6 +84,17 @@ export function mapDatasetInfosToSchema(datasetInfos: DatasetFields[]): MonacoKu
     };
 }
 
+/**
+ * Adds the named datasets that have no fields loaded as tables without
+ * columns, so their names can still be completed.
+ */
+export function withDatasetNames(datasetInfos: DatasetFields[], names: string[]): DatasetFields[] {
+    const loaded = new Set(datasetInfos.map((datasetInfo) => datasetInfo.datasetName));
+    const unloaded = names.filter((name) => !loaded.has(name)).map((datasetName) => ({ datasetName, fields: [] }));
+
+    return [...datasetInfos, ...unloaded];
+}
+
 const columnTypeForAxiomType = (axiomType: string): { Type: string; CslType: string } | undefined => {
     // 'System.Int32': 'int',
     // 'System.String': 'string',
@@ -123,3 +134,65 @@ const columnTypeForAxiomType = (axiomType: string): { Type: string; CslType: str
 
     return undefined;
 };
+
+/**
+ * Returns the dataset an APL query starts from, such as logs in
+ * `['logs'] | where ...`, or undefined when the query does not start with one.
+ */
+export function datasetInQuery(apl: string): string | undefined {
+    const source = apl
+        .split('\n')
+        .filter((line) => !line.trim().startsWith('//'))
+        .join('\n')
+        .trimStart();
+
+    const quoted = source.match(/^\[\s*(['"])(.+?)\1\s*\]/);
+    if (quoted) {
+        return quoted[2];
+    }
+
+    const identifier = source.match(/^([A-Za-z_][\w-]*)\s*(\||$)/);
+    return identifier ? identifier[1] : undefined;
+}
+
+/**
+ * Returns the datasets an APL query may read: the one it starts from and every
+ * name quoted as ['name'], which covers join, union and lookup. Quoted field
+ * names are returned too, so callers match the result against the known
+ * datasets.
+ */
+export function datasetsInQuery(apl: string): string[] {
+    const code = apl
+        .split('\n')
+        .filter((line) => !line.trim().startsWith('//'))
+        .join('\n');
+
+    const names = new Set<string>();
+    const source = datasetInQuery(code);
+    if (source) {
+        names.add(source);
+    }
+    for (const match of code.matchAll(/\[\s*(['"])(.+?)\1\s*\]/g)) {
+        names.add(match[2]);
+    }
+
+    return [...names];
+}
+
+/**
+ * Returns the field and the typed part of the string literal when the cursor
+ * is inside the value of a comparison such as `where method == "GE`. The
+ * prefix is unescaped; typedLength is its length as written.
+ */
+export function fieldComparisonAtCursor(
+    textBeforeCursor: string
+): { field: string; prefix: string; typedLength: number } | undefined {
+    const match = textBeforeCursor.match(
+        /(?:\[\s*(['"])(.+?)\1\s*\]|([A-Za-z_][\w.-]*))\s*(?:==|!=|=~|!~)\s*(['"])((?:[^'"\\]|\\.)*)$/
+    );
+    if (!match) {
+        return undefined;
+    }
+
+    return { field: match[2] ?? match[3], prefix: match[5].replace(/\\(.)/g, '$1'), typedLength: match[5].length };
+}
@@ -1,3 +1,4 @@
+import type { AdHocVariableFilter } from '@grafana/data';
 import { DataQuery, DataSourceJsonData } from '@grafana/schema';
 
 export const QUERY_MODEL_VERSION = '2.0';
@@ -19,6 +20,28 @@ export interface AxiomQuery extends DataQuery {
   supportingQueryType?: 'LogsVolume';
   startTime?: string;
   endTime?: string;
+  /** Lowers the datasource's maximum rows for this query. */
+  maxRows?: number;
+  /** Grafana app that issued the query. Explore queries bypass the backend result cache. */
+  app?: string;
+  /** Shortens the datasource's query timeout for this query, e.g. "30s" or "2m". */
+  timeout?: string;
+  /** Runs the APL query as a job on Axiom that is polled until it finishes, for long-running queries. */
+  async?: boolean;
+  /** Continues a raw event query after the page whose frames carried this nextCursor. */
+  cursor?: string;
+  /** The dashboard's ad-hoc filters, added by the backend after the query's source. */
+  adhocFilters?: AdHocVariableFilter[];
+  /** Columns of an annotation query's result; empty columns are picked by name. */
+  annotation?: AxiomAnnotationColumns;
+}
+
+export interface AxiomAnnotationColumns {
+  time?: string;
+  timeEnd?: string;
+  title?: string;
+  text?: string;
+  tags?: string[];
 }
 
 export const DEFAULT_QUERY: Partial<AxiomQuery> = {
@@ -31,8 +54,15 @@ export const DEFAULT_QUERY: Partial<AxiomQuery> = {
 /**
  * These are options configured for each DataSource instance
  */
+export interface AxiomEdgeRoute {
+  dataset: string;
+  edgeURL: string;
+}
+
 export interface AxiomDataSourceOptions extends DataSourceJsonData {
   apiHost: string;
+  /** Organization ID sent as X-Axiom-Org-Id. Required when a personal access token is used. */
+  orgId?: string;
   /**
    * Legacy regional edge domain for ingest and query operations.
    * Kept for migrating existing datasource settings to edgeURL.
@@ -46,6 +76,67 @@ export interface AxiomDataSourceOptions extends DataSourceJsonData {
    * Takes precedence over edge if both are set.
    */
   edgeURL?: string;
+  /**
+   * Sends queries on matching datasets to another edge than edgeURL. Dataset is an exact
+   * name or a glob such as "eu-*"; exact names win, then globs in order. The first dataset
+   * a query reads from picks the edge.
+   */
+  edgeRoutes?: AxiomEdgeRoute[];
+  /** Edge URLs tried in order when edgeURL cannot be reached. */
+  failoverEdgeURLs?: string[];
+  /** Transport errors in a row before an edge is skipped. Defaults to 3. */
+  edgeFailureThreshold?: number;
+  /** How often an unreachable edge is probed, in seconds. Defaults to 30. */
+  edgeProbeIntervalSeconds?: number;
+  /**
+   * How often transient query and metadata failures (429, 502, 503, 504) are retried.
+   * Defaults to 3; set to 0 to disable retries.
+   */
+  maxRetries?: number;
+  /** Lower bound of the jittered retry backoff in milliseconds. Defaults to 500. */
+  retryWaitMinMs?: number;
+  /** Upper bound of the jittered retry backoff in milliseconds. Defaults to 10000. */
+  retryWaitMaxMs?: number;
+  /**
+   * Maximum size of a query response in megabytes. Larger responses fail with an error
+   * instead of exhausting plugin memory. Defaults to 256; 0 means no limit.
+   */
+  maxResponseSizeMB?: number;
+  /**
+   * Maximum number of rows an APL query returns into a frame. Extra rows are dropped
+   * with a warning. Unset or 0 means no limit.
+   */
+  maxRows?: number;
+  /** How long query results are served from the backend result cache, in seconds. Unset or 0 disables it. */
+  cacheTTLSeconds?: number;
+  /** Memory budget of the result cache in megabytes. Defaults to 64. */
+  cacheMaxSizeMB?: number;
+  /** Ranges ending less than this many seconds before now bypass the result cache. Defaults to 60. */
+  cacheNowToleranceSeconds?: number;
+  /** How many queries of one panel or dashboard request run at once. Defaults to 10. */
+  maxConcurrentQueries?: number;
+  /** How many queries are sent to Axiom at once across all users. Unset or 0 means no limit. */
+  maxInflightQueries?: number;
+  /** Queries per second sent to Axiom. Unset or 0 disables rate limiting. */
+  queryRateLimit?: number;
+  /** Largest burst of queries allowed by the rate limit. Defaults to the rate rounded up. */
+  queryRateBurst?: number;
+  /** Timeout of APL and MPL queries in seconds. Defaults to 300. */
+  queryTimeoutSeconds?: number;
+  /** Timeout of schema and autocomplete lookups in seconds. Defaults to 30. */
+  metadataTimeoutSeconds?: number;
+  /** Timeout of the health check in seconds. Defaults to 30. */
+  healthCheckTimeoutSeconds?: number;
+  /** Timeout of async APL queries in seconds, from submission to the last poll. Defaults to 1800. */
+  asyncQueryTimeoutSeconds?: number;
+  /** How often a running async APL query is polled, in milliseconds. Defaults to 1000. */
+  asyncPollIntervalMs?: number;
+  /** How long dataset fields are cached before a background refresh, in seconds. 0 disables the cache. Defaults to 300. */
+  schemaCacheTTLSeconds?: number;
+  /** How often a live tail in Explore polls Axiom for new events, in milliseconds. Defaults to 2000. */
+  liveTailPollIntervalMs?: number;
+  /** Most events one live tail poll sends; when more arrived, only the newest are sent. Defaults to 1000. */
+  liveTailMaxRows?: number;
 }
 
 /**
@@ -54,3 +145,58 @@ export interface AxiomDataSourceOptions extends DataSourceJsonData {
 export interface MySecureJsonData {
   accessToken: string;
 }
+
+/** Signals a dataset can serve, as classified by the backend. */
+export interface AxiomDatasetUsage {
+  logs: boolean;
+  traces: boolean;
+  metrics: boolean;
+}
+
+/** A dataset from the datasets resource. */
+export interface AxiomDataset {
+  id: string;
+  name: string;
+  description: string;
+  /** Axiom dataset kind, such as "axiom:events:v1" or "otel:metrics:v1". */
+  kind: string;
+  createdAt: string;
+  /** Null when events are kept forever. */
+  retentionDays: number | null;
+  region?: string;
+  usage: AxiomDatasetUsage;
+}
+
+export interface AxiomFieldValue {
+  value: string;
+  /** Number of events with the value in the time range. */
+  count: number;
+}
+
+export interface AxiomQueryDiagnostic {
+  severity: 'error' | 'warning' | 'info';
+  /** 1-based line of the query. */
+  line: number;
+  /** 1-based column of the query. */
+  column: number;
+  message: string;
+  code?: string;
+}
+
+export interface AxiomQueryValidation {
+  valid: boolean;
+  diagnostics: AxiomQueryDiagnostic[];
+  /** Datasets the query reads from. */
+  datasets: string[];
+  /** The kind of frames the result becomes; absent when the query does not compile. */
+  shape?: 'timeSeries' | 'logs' | 'trace' | 'table';
+  /** Why Axiom rejected the query when there is no position to mark. */
+  error?: string;
+}
+
+export interface AxiomDatasetFilters {
+  /** Axiom dataset kinds or signals ("logs", "traces", "metrics"). */
+  kind?: string[];
+  /** Case-insensitive substring of the dataset name. */
+  name?: string;
+}